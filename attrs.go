@@ -0,0 +1,66 @@
+package parcels
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"spWebFront/FrontKeeper/infrastructure/core"
+)
+
+// rawAttrs is document, decoded once. Attributes are decoded for filter,
+// and raw values of scalar attributes, which filter didn't change, are reused by filtered document.
+type rawAttrs struct {
+	raw     map[string]json.RawMessage
+	scalars map[string]interface{} // Decoded scalar attributes
+}
+
+// Decode attributes of document for filter
+func decodeRawAttrs(document []byte) (*rawAttrs, map[string]interface{}, error) {
+	res := &rawAttrs{}
+	err := core.JsonUnmarshal(document, &res.raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unmarshal: %w", err)
+	}
+
+	attrs := make(map[string]interface{}, len(res.raw))
+	res.scalars = make(map[string]interface{}, len(res.raw))
+	for name, raw := range res.raw {
+		var value interface{}
+		err := json.Unmarshal(raw, &value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		attrs[name] = value
+		if isScalarAttr(value) {
+			res.scalars[name] = value
+		}
+	}
+	return res, attrs, nil
+}
+
+// Encode attributes, left by filter. Other than unchanged scalar attributes are encoded again.
+func (doc *rawAttrs) encode(attrs map[string]interface{}) ([]byte, error) {
+	res := make(map[string]json.RawMessage, len(attrs))
+	for name, value := range attrs {
+		if scalar, ok := doc.scalars[name]; ok && isScalarAttr(value) && scalar == value {
+			res[name] = doc.raw[name]
+			continue
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		res[name] = data
+	}
+	return json.Marshal(res)
+}
+
+// Check, that attribute is comparable JSON value
+func isScalarAttr(value interface{}) bool {
+	switch value.(type) {
+	case nil, string, float64, bool:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,256 @@
+package parcels
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// BandMode is mode of band cutoff
+type BandMode int
+
+const (
+	BandModeDefault  BandMode = iota // Threshold, gap to the previous item and ratio to the top item
+	BandModeAbsolute                 // Threshold only
+	BandModeRelative                 // Threshold and gap to the previous item
+	BandModeTop                      // Threshold and ratio to the top item
+	BandModeKnee                     // Threshold and knee of relevance curve
+)
+
+var bandModeNames = map[string]BandMode{
+	"":         BandModeDefault,
+	"default":  BandModeDefault,
+	"absolute": BandModeAbsolute,
+	"relative": BandModeRelative,
+	"top":      BandModeTop,
+	"knee":     BandModeKnee,
+}
+
+// ParseBandMode converts name of band mode to value
+func ParseBandMode(name string) (BandMode, error) {
+	if mode, ok := bandModeNames[name]; ok {
+		return mode, nil
+	}
+	return BandModeDefault, fmt.Errorf("unknown band mode %q", name)
+}
+
+// BandCutter decides, where band of search results ends.
+type BandCutter interface {
+	// Get count of accepted items. Relevances are sorted DESC.
+	Cut(relevances []float64) int
+}
+
+// prefixCutter is cutter, which decides by relevances of the previous items.
+// Such cutter cuts band, while items are fetched, instead of cutting fetched items.
+type prefixCutter interface {
+	// Check, that item is accepted after accepted items. Relevances are sorted DESC.
+	accepts(relevances []float64, relevance float64) bool
+}
+
+// Cutter by maximal count of items. Not positive capacity is unlimited.
+type capacityCutter struct {
+	capacity int
+}
+
+func (cutter *capacityCutter) Cut(relevances []float64) int {
+	if cutter.capacity > 0 && len(relevances) > cutter.capacity {
+		return cutter.capacity
+	}
+	return len(relevances)
+}
+
+func (cutter *capacityCutter) accepts(relevances []float64, relevance float64) bool {
+	return cutter.capacity <= 0 || len(relevances) < cutter.capacity
+}
+
+func NewCapacityCutter(capacity int) BandCutter {
+	return &capacityCutter{
+		capacity: capacity,
+	}
+}
+
+// Cutter by absolute relevance value
+type thresholdCutter struct {
+	threshold float64
+}
+
+func (cutter *thresholdCutter) Cut(relevances []float64) int {
+	for i, r := range relevances {
+		if r < cutter.threshold {
+			return i
+		}
+	}
+	return len(relevances)
+}
+
+func (cutter *thresholdCutter) accepts(relevances []float64, relevance float64) bool {
+	return relevance >= cutter.threshold
+}
+
+func NewThresholdCutter(threshold float64) BandCutter {
+	return &thresholdCutter{
+		threshold: threshold,
+	}
+}
+
+// Cutter by ratio of item to the previous item
+type gapCutter struct {
+	ratio float64
+}
+
+func (cutter *gapCutter) Cut(relevances []float64) int {
+	for i := 1; i < len(relevances); i++ {
+		if relevances[i] < cutter.ratio*relevances[i-1] {
+			return i
+		}
+	}
+	return len(relevances)
+}
+
+func (cutter *gapCutter) accepts(relevances []float64, relevance float64) bool {
+	return len(relevances) == 0 || relevance >= cutter.ratio*relevances[len(relevances)-1]
+}
+
+func NewGapCutter(ratio float64) BandCutter {
+	return &gapCutter{
+		ratio: ratio,
+	}
+}
+
+// Cutter by ratio of item to the top item
+type topCutter struct {
+	ratio float64
+}
+
+func (cutter *topCutter) Cut(relevances []float64) int {
+	for i := 1; i < len(relevances); i++ {
+		if relevances[i] < cutter.ratio*relevances[0] {
+			return i
+		}
+	}
+	return len(relevances)
+}
+
+func (cutter *topCutter) accepts(relevances []float64, relevance float64) bool {
+	return len(relevances) == 0 || relevance >= cutter.ratio*relevances[0]
+}
+
+func NewTopCutter(ratio float64) BandCutter {
+	return &topCutter{
+		ratio: ratio,
+	}
+}
+
+// Cutter by knee of relevance curve.
+// Knee is the largest drop between adjacent items, if the drop is not less
+// than share of the whole range of relevances.
+type kneeCutter struct {
+	share float64
+}
+
+func (cutter *kneeCutter) Cut(relevances []float64) int {
+	if len(relevances) < 2 {
+		return len(relevances)
+	}
+
+	knee := 0
+	var drop float64
+	for i := 1; i < len(relevances); i++ {
+		if d := relevances[i-1] - relevances[i]; d > drop {
+			drop = d
+			knee = i
+		}
+	}
+
+	span := relevances[0] - relevances[len(relevances)-1]
+	if drop <= 0 || drop < cutter.share*span {
+		return len(relevances)
+	}
+	return knee
+}
+
+func NewKneeCutter(share float64) BandCutter {
+	return &kneeCutter{
+		share: share,
+	}
+}
+
+// Cutter accepts items, which are accepted by all cutters
+type chainCutter []BandCutter
+
+func (cutter chainCutter) Cut(relevances []float64) int {
+	n := len(relevances)
+	for _, c := range cutter {
+		n = c.Cut(relevances[:n])
+	}
+	return n
+}
+
+// Check item by cutters, which decide by prefix. Other cutters can cut band after fetching only.
+func (cutter chainCutter) accepts(relevances []float64, relevance float64) bool {
+	for _, c := range cutter {
+		if p, ok := c.(prefixCutter); ok && !p.accepts(relevances, relevance) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check, that cutter decides by prefix of items only, so band of fetched prefix is final
+func cutsByPrefix(cutter BandCutter) bool {
+	switch c := cutter.(type) {
+	case chainCutter:
+		for _, cc := range c {
+			if !cutsByPrefix(cc) {
+				return false
+			}
+		}
+		return true
+	case prefixCutter:
+		return true
+	default:
+		return false
+	}
+}
+
+func NewChainCutter(cutters ...BandCutter) BandCutter {
+	return chainCutter(cutters)
+}
+
+// NewBandCutter creates cutter by band options.
+// Capacity and threshold are applied in every mode.
+func NewBandCutter(options *BandOptions) (BandCutter, error) {
+	mode, err := ParseBandMode(options.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	cutters := []BandCutter{
+		NewCapacityCutter(options.Capacity),
+		NewThresholdCutter(options.Threshold),
+	}
+	switch mode {
+	case BandModeDefault:
+		cutters = append(cutters, NewGapCutter(options.Diff.Rel), NewTopCutter(options.Diff.Abs))
+	case BandModeRelative:
+		cutters = append(cutters, NewGapCutter(options.Diff.Rel))
+	case BandModeTop:
+		cutters = append(cutters, NewTopCutter(options.Diff.Abs))
+	case BandModeKnee:
+		cutters = append(cutters, NewKneeCutter(options.Knee))
+	}
+	return NewChainCutter(cutters...), nil
+}
+
+// Get the least accepted relevance of unordered items.
+// Items with equal relevance are accepted together.
+func bandBound(cutter BandCutter, relevances []float64) float64 {
+	sorted := make([]float64, len(relevances))
+	copy(sorted, relevances)
+	sort.Sort(sort.Reverse(sort.Float64Slice(sorted)))
+	n := cutter.Cut(sorted)
+	if n == 0 {
+		return math.Inf(1)
+	}
+	return sorted[n-1]
+}
@@ -0,0 +1,71 @@
+package parcels
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBandCutter(t *testing.T) {
+	relevances := []float64{1, 0.9, 0.85, 0.4, 0.38, 0.1}
+
+	for _, tc := range []struct {
+		name     string
+		options  BandOptions
+		expected int
+	}{
+		{"unlimited", BandOptions{}, 6},
+		{"capacity", BandOptions{Capacity: 2}, 2},
+		{"absolute", BandOptions{Mode: "absolute", Threshold: 0.39, Diff: BandDiffOptions{Rel: 1}}, 4},
+		// 0.4/0.85 is the first gap less than 0.5
+		{"relative", BandOptions{Mode: "relative", Diff: BandDiffOptions{Rel: 0.5}}, 3},
+		{"relative with threshold", BandOptions{Mode: "relative", Threshold: 0.88, Diff: BandDiffOptions{Rel: 0.5}}, 2},
+		{"top", BandOptions{Mode: "top", Diff: BandDiffOptions{Abs: 0.38}}, 5},
+		{"top ignores gap", BandOptions{Mode: "top", Diff: BandDiffOptions{Rel: 1, Abs: 0.38}}, 5},
+		// The largest drop is 0.85 -> 0.4, it is 0.45 of range 0.9
+		{"knee", BandOptions{Mode: "knee", Knee: 0.4}, 3},
+		{"knee is too small", BandOptions{Mode: "knee", Knee: 0.6}, 6},
+		{"knee in capacity", BandOptions{Mode: "knee", Capacity: 3}, 1},
+		{"default", BandOptions{Diff: BandDiffOptions{Rel: 0.5, Abs: 0.38}}, 3},
+		{"default top", BandOptions{Diff: BandDiffOptions{Rel: 0.2, Abs: 0.38}}, 5},
+	} {
+		cutter, err := NewBandCutter(&tc.options)
+		require.NoError(t, err, tc.name)
+		assert.Equal(t, tc.expected, cutter.Cut(relevances), tc.name)
+
+		// Band is cut by prefix of items, while they are fetched. Knee is cut after fetching.
+		n := 0
+		for n < len(relevances) && cutter.(prefixCutter).accepts(relevances[:n], relevances[n]) {
+			n++
+		}
+		if tc.options.Mode == "knee" {
+			assert.Equal(t, tc.expected, cutter.Cut(relevances[:n]), tc.name)
+		} else {
+			assert.Equal(t, tc.expected, n, tc.name)
+		}
+	}
+
+	_, err := NewBandCutter(&BandOptions{Mode: "median"})
+	assert.Error(t, err)
+}
+
+func TestBandCutterEdges(t *testing.T) {
+	cutter, err := NewBandCutter(&BandOptions{Mode: "knee"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, cutter.Cut(nil))
+	assert.Equal(t, 1, cutter.Cut([]float64{0.5}))
+	assert.Equal(t, 3, cutter.Cut([]float64{0.5, 0.5, 0.5}))
+
+	cutter, err = NewBandCutter(&BandOptions{Threshold: 0.6})
+	require.NoError(t, err)
+	assert.Equal(t, 0, cutter.Cut([]float64{0.5}))
+}
+
+func TestBandBound(t *testing.T) {
+	cutter := NewChainCutter(NewCapacityCutter(2))
+	assert.Equal(t, 0.8, bandBound(cutter, []float64{0.5, 0.8, 1, 0.8}))
+
+	cutter = NewThresholdCutter(2)
+	assert.True(t, bandBound(cutter, []float64{0.5, 1}) > 1)
+}
@@ -0,0 +1,74 @@
+package parcels
+
+import "sort"
+
+// ngramBuilder collects postings of ngram index during batch build.
+// Posting lists are accumulated without ordering and sorted once at commit.
+type ngramBuilder struct {
+	items   map[NgramId][]Ref   // Unsorted posting lists
+	forward map[int64][]NgramId // Forward index: document -> ngrams
+}
+
+// Append document
+func (builder *ngramBuilder) add(id int64, ngrams []NgramEntry, weight float64) {
+	builder.remove(id)
+
+	entries := distinctNgrams(ngrams)
+	ids := make([]NgramId, len(entries))
+	for i, n := range entries {
+		ids[i] = n.Id
+		builder.items[n.Id] = append(
+			builder.items[n.Id],
+			Ref{
+				Doc:    id,
+				Pos:    n.Pos,
+				Weight: weight,
+				Len:    refLen(len(entries)),
+			},
+		)
+	}
+
+	if len(ids) != 0 {
+		builder.forward[id] = ids
+	}
+}
+
+// Remove document
+func (builder *ngramBuilder) remove(id int64) {
+	ids, ok := builder.forward[id]
+	if !ok {
+		return
+	}
+
+	for _, n := range ids {
+		rs := builder.items[n]
+		for i, r := range rs {
+			if r.Doc == id {
+				rs = append(rs[:i], rs[i+1:]...)
+				break
+			}
+		}
+		if len(rs) == 0 {
+			delete(builder.items, n)
+		} else {
+			builder.items[n] = rs
+		}
+	}
+
+	delete(builder.forward, id)
+}
+
+// Make immutable segment from collected postings
+func (builder *ngramBuilder) segment(codec postingCodec) *ngramSegment {
+	for _, rs := range builder.items {
+		sort.Slice(rs, func(i, j int) bool { return rs[i].Doc < rs[j].Doc })
+	}
+	return newNgramSegmentFromItems(builder.items, builder.forward, codec)
+}
+
+func newNgramBuilder() *ngramBuilder {
+	return &ngramBuilder{
+		items:   make(map[NgramId][]Ref, 32768),
+		forward: make(map[int64][]NgramId, 1024),
+	}
+}
@@ -0,0 +1,181 @@
+package parcels
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Generate synthetic drug names
+func newSyntheticNames(count int) []string {
+	syllables := []string{
+		"ас", "пи", "рин", "ан", "аль", "гин", "ко", "рб", "ин", "ка",
+		"ме", "та", "фор", "мин", "па", "ра", "це", "мол", "ибу", "про",
+		"фен", "но", "шпа", "лор", "ата", "дин", "ци", "тра", "зол", "вит",
+	}
+	forms := []string{"таблетки", "капсулы", "раствор", "сироп", "мазь"}
+
+	rnd := rand.New(rand.NewSource(1))
+	res := make([]string, count)
+	for i := range res {
+		var sb strings.Builder
+		n := 2 + rnd.Intn(4)
+		for j := 0; j < n; j++ {
+			sb.WriteString(syllables[rnd.Intn(len(syllables))])
+		}
+		sb.WriteString(" ")
+		sb.WriteString(forms[rnd.Intn(len(forms))])
+		sb.WriteString(fmt.Sprintf(" %dмг", 10*(1+rnd.Intn(50))))
+		res[i] = sb.String()
+	}
+	return res
+}
+
+func newBuilderTestRule() Rule {
+	return NewNgramRule(
+		"ngram.3",
+		NewNgramIndex(nil, NgramIndexPositions{}),
+		NewNgramParserPrimary(3, NewParserEstimatorPrimary(1)),
+	)
+}
+
+func TestNgramRuleBuildEqualsAppend(t *testing.T) {
+	ctx := context.Background()
+	names := newSyntheticNames(1000)
+
+	incremental := newBuilderTestRule()
+	for i, name := range names {
+		require.NoError(t, incremental.Append(ctx, int64(i+1), []rune(name), 1))
+	}
+
+	batch := newBuilderTestRule()
+	batch.BeginBuild(ctx)
+	for i, name := range names {
+		require.NoError(t, batch.Append(ctx, int64(i+1), []rune(name), 1))
+	}
+	batch.Remove(ctx, 1)
+	require.NoError(t, batch.Append(ctx, 1, []rune(names[0]), 1))
+
+	// Documents are invisible until commit
+	assert.Equal(t, 0, batch.(*ngramRule).Index.Statistics().Count)
+	require.NoError(t, batch.Commit(ctx))
+
+	a := incremental.(*ngramRule).Index.(*ngramIndex).segment()
+	b := batch.(*ngramRule).Index.(*ngramIndex).segment()
+	assert.Equal(t, a.items(), b.items())
+	assert.Equal(t, a.docs, b.docs)
+	assert.Equal(t, a.refs, b.refs)
+}
+
+func TestNgramRuleRebuildDictionary(t *testing.T) {
+	ctx := context.Background()
+	rule := newBuilderTestRule()
+	require.NoError(t, rule.Append(ctx, 1, []rune("аспирин"), 1))
+
+	rule.BeginBuild(ctx)
+	require.NoError(t, rule.Append(ctx, 2, []rune("нокспрей"), 1))
+	// Published index still parses queries by the current dictionary
+	parser := rule.(*ngramRule).Parser
+	ngrams, err := parser.Parse(ctx, []rune("аспирин"), false)
+	require.NoError(t, err)
+	assert.Len(t, ngrams, 5)
+	require.NoError(t, rule.Commit(ctx))
+	// Dictionary of published index is left intact, parser and index are replaced together
+	assert.Equal(t, 5, parser.Count())
+	parser = rule.(*ngramRule).Parser
+
+	// Ngrams of removed document don't survive rebuild, identifiers are allocated from scratch
+	assert.Equal(t, 6, parser.Count())
+	ngrams, err = parser.Parse(ctx, []rune("аспирин"), false)
+	require.NoError(t, err)
+	assert.Empty(t, ngrams)
+	ngrams, err = parser.Parse(ctx, []rune("нокспрей"), false)
+	require.NoError(t, err)
+	require.Len(t, ngrams, 6)
+	assert.Equal(t, NgramId(1), ngrams[0].Id)
+
+	res := &resolver{cache: make(map[resolverKey]Hypotheses)}
+	hs := res.Resolve(ctx, rule, []rune("нокспрей"), 1, nil)
+	assert.True(t, hs[2] > 0)
+	assert.Len(t, hs, 1)
+}
+
+func TestNgramRuleRebuildConcurrentSearch(t *testing.T) {
+	ctx := context.Background()
+	rule := newBuilderTestRule()
+	names := newSyntheticNames(100)
+	for i, name := range names {
+		require.NoError(t, rule.Append(ctx, int64(i+1), []rune(name), 1))
+	}
+
+	// Searches get parser and index of the same build, so the document is found at any time
+	done := make(chan struct{})
+	found := make(chan bool)
+	go func() {
+		ok := true
+		for {
+			select {
+			case <-done:
+				found <- ok
+				return
+			default:
+			}
+			res := &resolver{cache: make(map[resolverKey]Hypotheses)}
+			hs := res.Resolve(ctx, rule, []rune(names[0]), 1, nil)
+			ok = ok && hs[1] > 0
+		}
+	}()
+	for n := 0; n < 10; n++ {
+		rule.BeginBuild(ctx)
+		for i := len(names) - 1; i >= 0; i-- {
+			require.NoError(t, rule.Append(ctx, int64(i+1), []rune(names[i]), 1))
+		}
+		require.NoError(t, rule.Commit(ctx))
+	}
+	close(done)
+	assert.True(t, <-found)
+}
+
+func benchmarkNgramRuleBuild(b *testing.B, count int) {
+	ctx := context.Background()
+	names := newSyntheticNames(count)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		rule := newBuilderTestRule()
+		rule.BeginBuild(ctx)
+		for i, name := range names {
+			_ = rule.Append(ctx, int64(i+1), []rune(name), 1)
+		}
+		_ = rule.Commit(ctx)
+	}
+}
+
+func benchmarkNgramRuleAppend(b *testing.B, count int) {
+	ctx := context.Background()
+	names := newSyntheticNames(count)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		rule := newBuilderTestRule()
+		for i, name := range names {
+			_ = rule.Append(ctx, int64(i+1), []rune(name), 1)
+		}
+	}
+}
+
+func BenchmarkNgramRuleBuild100k(b *testing.B) {
+	benchmarkNgramRuleBuild(b, 100000)
+}
+
+func BenchmarkNgramRuleBuild1M(b *testing.B) {
+	benchmarkNgramRuleBuild(b, 1000000)
+}
+
+// Per-document inserts for comparison. 1M is omitted, because it takes hours.
+func BenchmarkNgramRuleAppend100k(b *testing.B) {
+	benchmarkNgramRuleAppend(b, 100000)
+}
@@ -0,0 +1,88 @@
+// Command evaluate measures relevance of search strategy offline.
+//
+// Usage:
+//
+//	evaluate -catalog drugs.csv -queries testdata/poll [-options a.json] [-compare b.json] [-k 10]
+//
+// Catalog is JSON array of documents or list of names (one name per line).
+// Queries are JSON array of judged queries or poll directory (file name is query,
+// lines are relevant names, the best first). Report is written to stdout as JSON.
+// If compare options are given, report contains per-query difference of two configurations.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"spWebFront/FrontKeeper/server/app/domain/service/searcher/parcels"
+)
+
+func main() {
+	catalogPath := flag.String("catalog", "", "path to catalog dump")
+	queriesPath := flag.String("queries", "", "path to judged queries (JSON file or poll directory)")
+	optionsPath := flag.String("options", "", "path to strategy options (JSON), default options if empty")
+	comparePath := flag.String("compare", "", "path to strategy options (JSON) for comparison")
+	k := flag.Int("k", 10, "cutoff of nDCG@k and Recall@k")
+	flag.Parse()
+
+	if *catalogPath == "" || *queriesPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	res, err := run(context.Background(), *catalogPath, *queriesPath, *optionsPath, *comparePath, *k)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(res)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(
+	ctx context.Context,
+	catalogPath string,
+	queriesPath string,
+	optionsPath string,
+	comparePath string,
+	k int,
+) (interface{}, error) {
+	catalog, err := parcels.ReadEvalCatalog(catalogPath)
+	if err != nil {
+		return nil, fmt.Errorf("ReadEvalCatalog: %w", err)
+	}
+	queries, err := parcels.ReadEvalQueries(queriesPath)
+	if err != nil {
+		return nil, fmt.Errorf("ReadEvalQueries: %w", err)
+	}
+
+	options, err := parcels.ReadStrategyOptions(optionsPath)
+	if err != nil {
+		return nil, fmt.Errorf("ReadStrategyOptions: %w", err)
+	}
+	base, err := parcels.Evaluate(ctx, catalog, queries, options, k)
+	if err != nil {
+		return nil, fmt.Errorf("Evaluate: %w", err)
+	}
+	if comparePath == "" {
+		return base, nil
+	}
+
+	options, err = parcels.ReadStrategyOptions(comparePath)
+	if err != nil {
+		return nil, fmt.Errorf("ReadStrategyOptions: %w", err)
+	}
+	other, err := parcels.Evaluate(ctx, catalog, queries, options, k)
+	if err != nil {
+		return nil, fmt.Errorf("Evaluate: %w", err)
+	}
+	return parcels.CompareEvalReports(base, other), nil
+}
@@ -0,0 +1,82 @@
+// Command tune searches options of search strategy, which maximize relevance of judged queries.
+//
+// Usage:
+//
+//	tune -catalog drugs.csv -queries testdata/poll [-options a.json] [-method coordinate] [-metric ndcg] [-iterations 100]
+//
+// Catalog and queries have the same format as for evaluate command.
+// The best options and their score are written to stdout as JSON.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"spWebFront/FrontKeeper/server/app/domain/service/searcher/parcels"
+)
+
+func main() {
+	catalogPath := flag.String("catalog", "", "path to catalog dump")
+	queriesPath := flag.String("queries", "", "path to judged queries (JSON file or poll directory)")
+	optionsPath := flag.String("options", "", "path to initial strategy options (JSON), default options if empty")
+	var settings parcels.TuneSettings
+	flag.StringVar(&settings.Method, "method", parcels.TuneMethodCoordinate, "method of search: coordinate or random")
+	flag.StringVar(&settings.Metric, "metric", parcels.TuneMetricNDCG, "metric to maximize: mrr, ndcg or recall")
+	flag.IntVar(&settings.K, "k", 10, "cutoff of nDCG@k and Recall@k")
+	flag.IntVar(&settings.Iterations, "iterations", 100, "maximal count of evaluations")
+	flag.Int64Var(&settings.Seed, "seed", 1, "seed of random search")
+	params := flag.String("params", "", "comma separated names of tuned parameters, all if empty")
+	flag.Parse()
+
+	if *catalogPath == "" || *queriesPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *params != "" {
+		settings.Params = strings.Split(*params, ",")
+	}
+
+	res, err := run(context.Background(), *catalogPath, *queriesPath, *optionsPath, settings)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(res)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(
+	ctx context.Context,
+	catalogPath string,
+	queriesPath string,
+	optionsPath string,
+	settings parcels.TuneSettings,
+) (*parcels.TuneResult, error) {
+	catalog, err := parcels.ReadEvalCatalog(catalogPath)
+	if err != nil {
+		return nil, fmt.Errorf("ReadEvalCatalog: %w", err)
+	}
+	queries, err := parcels.ReadEvalQueries(queriesPath)
+	if err != nil {
+		return nil, fmt.Errorf("ReadEvalQueries: %w", err)
+	}
+	options, err := parcels.ReadStrategyOptions(optionsPath)
+	if err != nil {
+		return nil, fmt.Errorf("ReadStrategyOptions: %w", err)
+	}
+
+	res, err := parcels.TuneStrategyOptions(ctx, catalog, queries, options, settings)
+	if err != nil {
+		return nil, fmt.Errorf("TuneStrategyOptions: %w", err)
+	}
+	return res, nil
+}
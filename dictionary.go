@@ -0,0 +1,183 @@
+package parcels
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// NgramId is internal ngram identifier
+type NgramId uint32
+
+// MaxNgramId is the largest identifier, that can be allocated by dictionary by default.
+const MaxNgramId NgramId = math.MaxUint32
+
+// ErrNgramDictionaryExhausted is returned, when dictionary has no free identifiers.
+var ErrNgramDictionaryExhausted = errors.New("ngram dictionary is exhausted")
+
+// NgramDictionary is bidirectional map between ngram text and ngram identifier.
+// Dictionary is safe for concurrent use: lookups share read lock,
+// and write lock is held only while new ngram is inserted.
+type NgramDictionary struct {
+	mx      sync.RWMutex
+	Counter NgramId            // Last allocated identifier
+	Limit   NgramId            // Max allowed identifier
+	Items   map[string]NgramId // ngramText -> ngramId
+	texts   []string           // ngramId -> ngramText
+}
+
+// Purge all ngrams
+func (dict *NgramDictionary) Purge() {
+	dict.mx.Lock()
+	defer dict.mx.Unlock()
+	dict.Counter = 0
+	dict.Items = make(map[string]NgramId, 32768)
+	dict.texts = ngramTexts(0, nil)
+}
+
+// Count of ngrams
+func (dict *NgramDictionary) Count() int {
+	dict.mx.RLock()
+	defer dict.mx.RUnlock()
+	return len(dict.Items)
+}
+
+// Find ngram text by identifier
+func (dict *NgramDictionary) Find(id NgramId) string {
+	dict.mx.RLock()
+	defer dict.mx.RUnlock()
+	if int(id) < len(dict.texts) {
+		return dict.texts[id]
+	}
+	return ""
+}
+
+// Make list of ngram texts by identifiers
+func ngramTexts(counter NgramId, items map[string]NgramId) []string {
+	texts := make([]string, int(counter)+1)
+	for text, id := range items {
+		texts[id] = text
+	}
+	return texts
+}
+
+// Accept ngram text and get its identifier.
+// If ngram is absent and allowNew is false, then returns false.
+func (dict *NgramDictionary) Accept(text string, allowNew bool) (NgramId, bool, error) {
+	dict.mx.RLock()
+	id, ok := dict.Items[text]
+	dict.mx.RUnlock()
+	if ok {
+		return id, true, nil
+	}
+
+	if !allowNew {
+		return 0, false, nil
+	}
+
+	dict.mx.Lock()
+	defer dict.mx.Unlock()
+
+	if id, ok := dict.Items[text]; ok {
+		return id, true, nil
+	}
+
+	if dict.Counter >= dict.Limit {
+		return 0, false, fmt.Errorf("%w: limit %d", ErrNgramDictionaryExhausted, dict.Limit)
+	}
+
+	if dict.texts == nil {
+		dict.texts = ngramTexts(dict.Counter, dict.Items)
+	}
+	dict.Counter++
+	dict.Items[text] = dict.Counter
+	dict.texts = append(dict.texts, text)
+	return dict.Counter, true, nil
+}
+
+// Load dictionary from decoder.
+// Snapshots from older versions used 16-bit identifiers and can contain
+// overflowed identifiers, so dictionary is validated after decoding.
+func (dict *NgramDictionary) Load(dec *gob.Decoder) error {
+	var counter NgramId
+	err := dec.Decode(&counter)
+	if err != nil {
+		return fmt.Errorf("Decode counter: %w", err)
+	}
+
+	items := make(map[string]NgramId)
+	err = dec.Decode(&items)
+	if err != nil {
+		return fmt.Errorf("Decode items: %w", err)
+	}
+
+	err = validateNgramDictionary(counter, dict.Limit, items)
+	if err != nil {
+		return fmt.Errorf("validate: %w", err)
+	}
+
+	dict.mx.Lock()
+	defer dict.mx.Unlock()
+	dict.Counter = counter
+	dict.Items = items
+	dict.texts = ngramTexts(counter, items)
+	return nil
+}
+
+// Save dictionary to encoder
+func (dict *NgramDictionary) Save(enc *gob.Encoder) error {
+	dict.mx.RLock()
+	defer dict.mx.RUnlock()
+	err := enc.Encode(dict.Counter)
+	if err != nil {
+		return fmt.Errorf("Encode counter: %w", err)
+	}
+	err = enc.Encode(dict.Items)
+	if err != nil {
+		return fmt.Errorf("Encode items: %w", err)
+	}
+	return nil
+}
+
+func validateNgramDictionary(
+	counter NgramId,
+	limit NgramId,
+	items map[string]NgramId,
+) error {
+	if counter > limit {
+		return fmt.Errorf("counter %d exceeds limit %d", counter, limit)
+	}
+
+	if uint64(len(items)) > uint64(counter) {
+		return fmt.Errorf("dictionary size %d exceeds counter %d", len(items), counter)
+	}
+
+	ids := make(map[NgramId]string, len(items))
+	for text, id := range items {
+		if id == 0 || id > counter {
+			return fmt.Errorf("identifier %d of %q is out of range", id, text)
+		}
+		if other, ok := ids[id]; ok {
+			return fmt.Errorf("identifier %d is shared by %q and %q", id, text, other)
+		}
+		ids[id] = text
+	}
+
+	return nil
+}
+
+// NewNgramDictionary is constructor for creating instance of ngram dictionary.
+// Zero limit means MaxNgramId.
+func NewNgramDictionary(limit NgramId) *NgramDictionary {
+	if limit == 0 {
+		limit = MaxNgramId
+	}
+
+	return &NgramDictionary{
+		Limit: limit,
+		Items: make(map[string]NgramId, 32768),
+		texts: ngramTexts(0, nil),
+	}
+}
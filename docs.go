@@ -2,11 +2,13 @@ package parcels
 
 import (
 	"context"
-	"encoding/json"
+	"encoding/gob"
+	"errors"
 	"fmt"
 	"math"
 	"sort"
 	"strings"
+	"sync"
 
 	"spWebFront/FrontKeeper/infrastructure/core"
 	"spWebFront/FrontKeeper/server/app/domain/model"
@@ -14,6 +16,9 @@ import (
 )
 
 
+// ErrUnsupported is error of feature, which document manager doesn't support.
+var ErrUnsupported = errors.New("unsupported by document manager")
+
 type DocManagerEx interface {
 	DocManager
 	ForEach(
@@ -22,15 +27,20 @@ type DocManagerEx interface {
 	) error
 }
 
+// mapDocManager is in-memory document manager.
+// Documents are stored in sync.Map, so readers never wait for writers.
 type mapDocManager struct {
-	items   map[int64]*Doc
+	items   sync.Map // int64 -> *Doc
 	parcels repository.ParcelRepository
 }
 
 func (docs *mapDocManager) Purge(
 	ctx context.Context,
 ) error {
-	docs.items = make(map[int64]*Doc, 1024)
+	docs.items.Range(func(key, value interface{}) bool {
+		docs.items.Delete(key)
+		return true
+	})
 	return nil
 }
 
@@ -38,7 +48,7 @@ func (docs *mapDocManager) Remove(
 	ctx context.Context,
 	id int64,
 ) error {
-	delete(docs.items, id)
+	docs.items.Delete(id)
 	return nil
 }
 
@@ -46,7 +56,14 @@ func (docs *mapDocManager) Append(
 	ctx context.Context,
 	doc *Doc,
 ) error {
-	docs.items[doc.Id] = doc
+	docs.items.Store(doc.Id, doc)
+	return nil
+}
+
+func (docs *mapDocManager) find(id int64) *Doc {
+	if doc, ok := docs.items.Load(id); ok {
+		return doc.(*Doc)
+	}
 	return nil
 }
 
@@ -59,7 +76,11 @@ func (docs *mapDocManager) Versions(
 	i := 0
 	vs := make(versions, len(hs))
 	for k, v := range hs {
-		doc := docs.items[k]
+		doc := docs.find(k)
+		if doc == nil {
+			// Document was removed after hypotheses have been built
+			continue
+		}
 		// if v < threshold {
 		// 	continue
 		// }
@@ -80,6 +101,18 @@ func (docs *mapDocManager) Versions(
 	return vs, nil
 }
 
+// ParcelBatchRepository is repository, which fetches documents by chunks.
+// Documents are returned in any order, missing documents are skipped.
+type ParcelBatchRepository interface {
+	FindBatch(ctx context.Context, ids []int64) ([]*model.Raw, error)
+}
+
+// Bounds of chunk size for batch fetch of documents
+const (
+	minResolveBatch = 16
+	maxResolveBatch = 256
+)
+
 func (docs *mapDocManager) Resolve(
 	ctx context.Context,
 	hs Hypotheses,
@@ -96,94 +129,248 @@ func (docs *mapDocManager) Resolve(
 	if err != nil {
 		return nil, fmt.Errorf("Versions: %w", err)
 	}
-	res = make(model.Parcels, 0, len(vs))
 	band := &details.Band
-	var start float64
-	var prev float64
+	page := pageStateFrom(ctx)
+	if page != nil {
+		page.sorted(vs)
+		band = page.band(band)
+	}
+	cutter, err := NewBandCutter(band)
+	if err != nil {
+		return nil, fmt.Errorf("NewBandCutter: %w", err)
+	}
+
+	fetched := newFetchedBand(len(vs), cutter)
+	// Knee of band depends on all documents, so page can't stop fetch
+	if page != nil && cutsByPrefix(cutter) {
+		fetched.page = page
+	}
+	if batch, ok := docs.parcels.(ParcelBatchRepository); ok {
+		err = docs.resolveBatch(ctx, vs, batch, filter, band, fetched)
+	} else {
+		err = docs.resolveRows(ctx, vs, filter, fetched)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res, accepted := fetched.parcels, fetched.accepted
+
+	// Only filter can leave candidates of truncated top, that are missed by band
+	if state := topKStateFrom(ctx); state != nil && fetched.exhausted && fetched.rejected > 0 {
+		state.rejected = true
+	}
+
+	// Band is cut by accepted documents only
+	if page != nil {
+		res, accepted, err = page.cut(res, accepted, &details.Band)
+		if err != nil {
+			return nil, fmt.Errorf("page.cut: %w", err)
+		}
+	} else {
+		n := cutter.Cut(parcelRelevances(res))
+		res, accepted = res[:n], accepted[:n]
+	}
+
+	if state := groupStateFrom(ctx); state != nil {
+		counts := make(map[string]int)
+		for _, v := range vs {
+			counts[v.group]++
+		}
+		keys := make([]string, len(accepted))
+		for i, v := range accepted {
+			keys[i] = v.group
+		}
+		state.groups = groupParcels(res, keys, counts, state.options)
+	}
+	if state := highlightStateFrom(ctx); state != nil {
+		state.docs = make([]*Doc, len(accepted))
+		for i, v := range accepted {
+			state.docs[i] = v.doc
+		}
+	}
+	return res, nil
+}
+
+// fetchedBand is documents, fetched into band.
+// Band is cut by prefix cutters while documents are fetched, other cutters cut fetched band.
+type fetchedBand struct {
+	cutter     BandCutter
+	parcels    model.Parcels
+	accepted   versions   // Versions of accepted documents
+	relevances []float64  // Relevances of accepted documents
+	rejected   int        // Count of documents, rejected by entity filter
+	exhausted  bool       // All candidates are fetched before band is filled
+	page       *pageState // Paginated search, fetch stops after document of the next page
+	following  int        // Count of accepted documents after cursor of page
+}
+
+func newFetchedBand(size int, cutter BandCutter) *fetchedBand {
+	return &fetchedBand{
+		cutter:   cutter,
+		parcels:  make(model.Parcels, 0, size),
+		accepted: make(versions, 0, size),
+	}
+}
+
+// Check, that document of relevance can enter band. Candidates are sorted by relevance DESC,
+// so candidates after rejected one can't enter band too.
+func (fetched *fetchedBand) accepts(relevance float64) bool {
+	if fetched.page != nil && fetched.following > fetched.page.size {
+		return false
+	}
+	cutter, ok := fetched.cutter.(prefixCutter)
+	return !ok || cutter.accepts(fetched.relevances, relevance)
+}
+
+// Append parcel of version. Nil parcel is rejected by filter.
+func (fetched *fetchedBand) append(parcel *model.Parcel, v *version) {
+	if parcel == nil {
+		fetched.rejected++
+		return
+	}
+	fetched.parcels = append(fetched.parcels, parcel)
+	fetched.accepted = append(fetched.accepted, v)
+	fetched.relevances = append(fetched.relevances, parcel.Relevance)
+	if fetched.page != nil && fetched.page.after.precedes(v) {
+		fetched.following++
+	}
+}
 
+// Fetch documents one by one, until band is filled.
+func (docs *mapDocManager) resolveRows(
+	ctx context.Context,
+	vs versions,
+	filter model.EntityFilter,
+	fetched *fetchedBand,
+) error {
 	for _, v := range vs {
+		if !fetched.accepts(v.relevance) {
+			return nil
+		}
+
 		doc, err := docs.parcels.Find(ctx, v.doc.Id)
 		if err != nil {
-			return nil, fmt.Errorf("Find: %w", err)
+			return fmt.Errorf("Find: %w", err)
 		}
 		if doc == nil {
 			continue
 		}
 
-		document, err := docs.filter(
-			ctx,
-			doc.Document,
-			filter,
-			v.doc.Id,
-			v.relevance,
-		)
+		parcel, err := docs.parcel(ctx, doc.Document, filter, v.doc.Id, v.relevance)
 		if err != nil {
 			// todo: log error
 			continue
 		}
-		if document == "" {
-			continue
-		}
+		fetched.append(parcel, v)
+	}
+	fetched.exhausted = true
+	return nil
+}
 
-		ln := len(res)
-		if ln >= band.Capacity {
-			break
+// Fetch documents by chunks, until band is filled.
+// Size of chunk follows count of documents, which are missing in band.
+func (docs *mapDocManager) resolveBatch(
+	ctx context.Context,
+	vs versions,
+	repo ParcelBatchRepository,
+	filter model.EntityFilter,
+	band *BandOptions,
+	fetched *fetchedBand,
+) error {
+	for len(vs) != 0 {
+		size := maxResolveBatch
+		if band.Capacity > 0 {
+			size = band.Capacity - len(fetched.parcels)
+			if size <= 0 {
+				return nil
+			}
+			if size < minResolveBatch {
+				size = minResolveBatch
+			}
+			if size > maxResolveBatch {
+				size = maxResolveBatch
+			}
 		}
 
-		if v.relevance < band.Threshold {
-			break
+		// The first candidate is checked by band, the rest of chunk is bounded by threshold
+		if !fetched.accepts(vs[0].relevance) {
+			return nil
 		}
+		n := 1
+		for n < len(vs) && n < size && vs[n].relevance >= band.Threshold {
+			n++
+		}
+		chunk := vs[:n]
+		vs = vs[n:]
 
-		prev = v.relevance
-		if ln == 0 {
-			start = v.relevance
-		} else {
-			ratioRel := v.relevance / prev
-			ratioAbs := v.relevance / start
-			if ratioRel < band.Diff.Rel || ratioAbs < band.Diff.Abs {
-				break
+		ids := make([]int64, len(chunk))
+		for i, v := range chunk {
+			ids[i] = v.doc.Id
+		}
+		raws, err := repo.FindBatch(ctx, ids)
+		if err != nil {
+			return fmt.Errorf("FindBatch: %w", err)
+		}
+		found := make(map[int64]*model.Raw, len(raws))
+		for _, raw := range raws {
+			if raw != nil {
+				found[raw.Id] = raw
 			}
 		}
 
-		parcel := new(model.Parcel)
-		err = core.JsonUnmarshal([]byte(document), &parcel)
-		if err != nil {
-			return nil, fmt.Errorf("Unmarshal: %w", err)
+		for _, v := range chunk {
+			if !fetched.accepts(v.relevance) {
+				return nil
+			}
+			raw := found[v.doc.Id]
+			if raw == nil {
+				continue
+			}
+			parcel, err := docs.parcel(ctx, raw.Document, filter, v.doc.Id, v.relevance)
+			if err != nil {
+				// todo: log error
+				continue
+			}
+			fetched.append(parcel, v)
 		}
-		parcel.Document = document
-		parcel.Relevance = v.relevance
-		res = append(res, parcel)
 	}
-
-	return res, nil
+	fetched.exhausted = true
+	return nil
 }
 
-func (docs *mapDocManager) filter(
+// Make parcel of document, passed through filter. Document is decoded once for filter,
+// and filtered document reuses raw values of unchanged attributes.
+// Nil is returned, if document is rejected by filter.
+func (docs *mapDocManager) parcel(
 	ctx context.Context,
 	document string,
 	filter model.EntityFilter,
 	id int64,
 	relevance float64,
-) (string, error) {
+) (*model.Parcel, error) {
 	modify := debug && id != 0
 
+	parcel := new(model.Parcel)
 	if filter == nil && !modify {
-		return document, nil
+		err := core.JsonUnmarshal([]byte(document), parcel)
+		if err != nil {
+			return nil, fmt.Errorf("Unmarshal: %w", err)
+		}
+		parcel.Document = document
+		parcel.Relevance = relevance
+		return parcel, nil
 	}
 
-	var attrs map[string]interface{}
-	err := core.JsonUnmarshal([]byte(document), &attrs)
+	raw, attrs, err := decodeRawAttrs([]byte(document))
 	if err != nil {
-		return "", fmt.Errorf("Unmarshal: %w", err)
+		return nil, fmt.Errorf("decodeRawAttrs: %w", err)
 	}
 
 	if filter != nil {
 		err := filter.Filter(ctx, id, attrs)
 		if err != nil {
-			if core.Cause(err) == core.ErrAbort {
-				return "", nil
-			}
-			return "", fmt.Errorf("Filter: %w", err)
+			// todo: log error
+			return nil, nil
 		}
 	}
 
@@ -192,25 +379,66 @@ func (docs *mapDocManager) filter(
 		attrs[".relevance"] = relevance
 	}
 
-	data, err := json.Marshal(attrs)
+	data, err := raw.encode(attrs)
 	if err != nil {
-		return "", fmt.Errorf("Marshal: %w", err)
+		return nil, fmt.Errorf("encode: %w", err)
 	}
-	return string(data), nil
+	err = core.JsonUnmarshal(data, parcel)
+	if err != nil {
+		return nil, fmt.Errorf("Unmarshal: %w", err)
+	}
+	parcel.Document = string(data)
+	parcel.Relevance = relevance
+	return parcel, nil
 }
 
 func (docs *mapDocManager) ForEach(
 	ctx context.Context,
 	action func(ctx context.Context, doc *Doc) error,
-) error {
-	for _, doc := range docs.items {
-		err := action(ctx, doc)
-		if err != nil {
-			if err == core.ErrBreak {
-				break
-			}
-			return fmt.Errorf("action: %w", err)
+) (err error) {
+	docs.items.Range(func(key, value interface{}) bool {
+		err = action(ctx, value.(*Doc))
+		return err == nil
+	})
+	if err != nil {
+		if err == core.ErrBreak {
+			return nil
 		}
+		return fmt.Errorf("action: %w", err)
+	}
+	return nil
+}
+
+func (docs *mapDocManager) Load(
+	dec *gob.Decoder,
+) error {
+	items := make(map[int64]*Doc, 1024)
+	err := dec.Decode(&items)
+	if err != nil {
+		return fmt.Errorf("Decode: %w", err)
+	}
+	// Documents, which are absent in snapshot, must not survive warm load
+	err = docs.Purge(context.Background())
+	if err != nil {
+		return fmt.Errorf("Purge: %w", err)
+	}
+	for id, doc := range items {
+		docs.items.Store(id, doc)
+	}
+	return nil
+}
+
+func (docs *mapDocManager) Save(
+	enc *gob.Encoder,
+) error {
+	items := make(map[int64]*Doc, 1024)
+	docs.items.Range(func(key, value interface{}) bool {
+		items[key.(int64)] = value.(*Doc)
+		return true
+	})
+	err := enc.Encode(items)
+	if err != nil {
+		return fmt.Errorf("Encode: %w", err)
 	}
 	return nil
 }
@@ -219,7 +447,6 @@ func NewMapDocManager(
 	parcels repository.ParcelRepository,
 ) DocManagerEx {
 	return &mapDocManager{
-		items:   make(map[int64]*Doc, 1024),
 		parcels: parcels,
 	}
 }
@@ -248,6 +475,8 @@ func (docs *repositoryDocManager) Append(
 	return nil
 }
 
+// Resolve documents by repository.
+// Repository sorts documents by itself, so pages are not supported: the whole band is the single page.
 func (docs *repositoryDocManager) Resolve(
 	ctx context.Context,
 	hs Hypotheses,
@@ -270,7 +499,13 @@ func (docs *repositoryDocManager) Resolve(
 	if err != nil {
 		return nil, fmt.Errorf("FindByHypotheses: %w", err)
 	}
-	return sortParcels(ps)
+	ps, err = cutParcels(ps, &details.Band)
+	if err != nil {
+		return nil, err
+	}
+
+	// Documents of repository are not read by group reader, so groups are not returned
+	return ps, nil
 }
 
 func NewRepositoryDocManager(
@@ -281,6 +516,36 @@ func NewRepositoryDocManager(
 	}
 }
 
+// Sort parcels and cut them by band.
+// Sorted parcels are grouped, so band is cut by relevance of parcels, not by their order.
+func cutParcels(
+	parcels model.Parcels,
+	band *BandOptions,
+) (model.Parcels, error) {
+	cutter, err := NewBandCutter(band)
+	if err != nil {
+		return nil, fmt.Errorf("NewBandCutter: %w", err)
+	}
+
+	bound := bandBound(cutter, parcelRelevances(parcels))
+
+	res := make(model.Parcels, 0, len(parcels))
+	for _, p := range parcels {
+		if p.Relevance >= bound {
+			res = append(res, p)
+		}
+	}
+	return sortParcels(res)
+}
+
+func parcelRelevances(parcels model.Parcels) []float64 {
+	res := make([]float64, len(parcels))
+	for i, p := range parcels {
+		res[i] = p.Relevance
+	}
+	return res
+}
+
 func sortParcels(
 	parcels model.Parcels,
 ) (res model.Parcels, err error) {
@@ -0,0 +1,233 @@
+package parcels
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"spWebFront/FrontKeeper/infrastructure/core"
+	"spWebFront/FrontKeeper/server/app/domain/model"
+	"spWebFront/FrontKeeper/server/app/domain/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Repository of documents with latency of round-trip
+type parcelRepositoryMock struct {
+	repository.ParcelRepository
+	docs    map[int64]string
+	latency time.Duration
+	mx      sync.Mutex
+	calls   int
+}
+
+// Count call of repository, that can be concurrent
+func (repo *parcelRepositoryMock) call() {
+	repo.mx.Lock()
+	repo.calls++
+	repo.mx.Unlock()
+}
+
+func (repo *parcelRepositoryMock) Find(ctx context.Context, id int64) (*model.Raw, error) {
+	repo.call()
+	time.Sleep(repo.latency)
+	if doc, ok := repo.docs[id]; ok {
+		return &model.Raw{Id: id, Document: doc}, nil
+	}
+	return nil, nil
+}
+
+type batchRepositoryMock struct {
+	*parcelRepositoryMock
+}
+
+func (repo batchRepositoryMock) FindBatch(ctx context.Context, ids []int64) ([]*model.Raw, error) {
+	repo.call()
+	time.Sleep(repo.latency)
+	var res []*model.Raw
+	for _, id := range ids {
+		if doc, ok := repo.docs[id]; ok {
+			res = append(res, &model.Raw{Id: id, Document: doc})
+		}
+	}
+	return res, nil
+}
+
+// Filter rejects odd documents and hides price
+type entityFilterMock struct{}
+
+func (f entityFilterMock) Filter(ctx context.Context, id int64, attrs map[string]interface{}) error {
+	if id%2 == 1 {
+		return core.ErrAbort
+	}
+	delete(attrs, "price")
+	return nil
+}
+
+func (f entityFilterMock) Release(ctx context.Context) error {
+	return nil
+}
+
+func newDocManagerMock(count int, latency time.Duration) (*mapDocManager, *parcelRepositoryMock, Hypotheses) {
+	repo := &parcelRepositoryMock{
+		docs:    make(map[int64]string, count),
+		latency: latency,
+	}
+	docs := &mapDocManager{parcels: repo}
+	hs := make(Hypotheses, count)
+	for i := 1; i <= count; i++ {
+		id := int64(i)
+		name := fmt.Sprintf("drug %04d", i)
+		docs.Append(context.Background(), &Doc{Id: id, NameLong: name, NameGroupIndex: name})
+		data, _ := json.Marshal(map[string]interface{}{"name": name, "price": i})
+		repo.docs[id] = string(data)
+		hs[id] = 1 - float64(i)/float64(count+1)
+	}
+	return docs, repo, hs
+}
+
+// Engine over document manager with default strategies of options
+func newEngineMock(t testing.TB, docs *mapDocManager, options *StrategyOptions) *advancedEngine {
+	names, err := NewStrategyDefault(docs, options, docNameSearchIndexReader)
+	require.NoError(t, err)
+	inns, err := NewStrategyDefault(docs, options, docInnSearchIndexReader)
+	require.NoError(t, err)
+	makers, err := NewStrategyDefault(docs, options, docMakerReader)
+	require.NoError(t, err)
+	strategies := &Strategies{
+		docs:   docs,
+		Names:  names,
+		Inns:   inns,
+		Makers: makers,
+	}
+	return &advancedEngine{
+		baseEngine: baseEngine{
+			behavior: strategies,
+		},
+		strategies: strategies,
+		docs:       docs,
+	}
+}
+
+func TestDocManagerResolve(t *testing.T) {
+	ctx := context.Background()
+	details := &Details{
+		Band:   BandOptions{Capacity: 20},
+		Filter: &resolver{EntityFilterEx: entityFilterMock{}},
+	}
+
+	docs, repo, hs := newDocManagerMock(100, 0)
+	rows, err := docs.Resolve(ctx, hs, details, docNameGroupIndexReader)
+	require.NoError(t, err)
+	rowCalls := repo.calls
+
+	repo.calls = 0
+	docs.parcels = batchRepositoryMock{repo}
+	batch, err := docs.Resolve(ctx, hs, details, docNameGroupIndexReader)
+	require.NoError(t, err)
+
+	require.Len(t, rows, 20)
+	assert.Equal(t, rows, batch)
+	assert.Equal(t, 40, rowCalls)
+	// Chunks of 20, 16 and 16 documents
+	assert.Equal(t, 3, repo.calls)
+	for _, p := range batch {
+		assert.NotContains(t, p.Document, "price")
+	}
+	assert.Equal(t, hs[2], batch[0].Relevance)
+
+	// Threshold stops fetch
+	repo.calls = 0
+	details.Band.Threshold = hs[10]
+	batch, err = docs.Resolve(ctx, hs, details, docNameGroupIndexReader)
+	require.NoError(t, err)
+	assert.Len(t, batch, 5)
+	assert.Equal(t, 1, repo.calls)
+
+	// Ratio to the top document stops fetch, before capacity is filled
+	repo.calls = 0
+	docs.parcels = repo
+	details.Band = BandOptions{Capacity: 20, Mode: "top", Diff: BandDiffOptions{Abs: 0.9}}
+	rows, err = docs.Resolve(ctx, hs, details, docNameGroupIndexReader)
+	require.NoError(t, err)
+	assert.Len(t, rows, 5)
+	assert.Equal(t, 11, repo.calls)
+}
+
+func TestDocManagerResolveMalformed(t *testing.T) {
+	ctx := context.Background()
+	details := &Details{
+		Band:   BandOptions{Capacity: 3},
+		Filter: &resolver{EntityFilterEx: entityFilterMock{}},
+	}
+
+	docs, repo, hs := newDocManagerMock(10, 0)
+	repo.docs[2] = "{broken"
+
+	// Malformed document is skipped, band is filled by the next ones
+	rows, err := docs.Resolve(ctx, hs, details, docNameGroupIndexReader)
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	assert.Equal(t, hs[4], rows[0].Relevance)
+
+	docs.parcels = batchRepositoryMock{repo}
+	batch, err := docs.Resolve(ctx, hs, details, docNameGroupIndexReader)
+	require.NoError(t, err)
+	assert.Equal(t, rows, batch)
+}
+
+func TestRawAttrs(t *testing.T) {
+	data := `{"code": "X1", "name": "aspirin", "count": 3, "price": 1.5, "extra": {"k": "v"}, "tags": ["a"]}`
+	raw, attrs, err := decodeRawAttrs([]byte(data))
+	require.NoError(t, err)
+	var expected map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(data), &expected))
+	assert.Equal(t, expected, attrs)
+
+	// Filter removes, changes and adds attributes, including nested ones
+	delete(attrs, "price")
+	attrs["code"] = "X2"
+	attrs["extra"].(map[string]interface{})["k"] = "w"
+	attrs[".id"] = int64(7)
+	actual, err := raw.encode(attrs)
+	require.NoError(t, err)
+	expectedData, err := json.Marshal(attrs)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(expectedData), string(actual))
+
+	_, _, err = decodeRawAttrs([]byte(`[1]`))
+	assert.Error(t, err)
+}
+
+func benchmarkDocManagerResolve(b *testing.B, batch bool) {
+	ctx := context.Background()
+	details := &Details{
+		Band:   BandOptions{Capacity: 100},
+		Filter: &resolver{EntityFilterEx: entityFilterMock{}},
+	}
+	docs, repo, hs := newDocManagerMock(1000, 50*time.Microsecond)
+	if batch {
+		docs.parcels = batchRepositoryMock{repo}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := docs.Resolve(ctx, hs, details, docNameGroupIndexReader)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(repo.calls)/float64(b.N), "calls/op")
+}
+
+func BenchmarkDocManagerResolveRows(b *testing.B) {
+	benchmarkDocManagerResolve(b, false)
+}
+
+func BenchmarkDocManagerResolveBatch(b *testing.B) {
+	benchmarkDocManagerResolve(b, true)
+}
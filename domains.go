@@ -43,13 +43,24 @@ func (sorter *versionSorter) sort() {
 		v.name = strings.ToLower(v.doc.NameLong)
 	}
 
-	sorter.epsilon = 0.01 * float64(sorter.min()) / float64(len(sorter.versions))
+	sorter.epsilon = versionEpsilon(sorter.versions)
 	sort.Sort(sorter)
 }
 
+// Get tolerance of relevance for sorting of versions
+func versionEpsilon(vs versions) float64 {
+	if len(vs) == 0 {
+		return 0
+	}
+	sorter := &versionSorter{
+		versions: vs,
+	}
+	return 0.01 * float64(sorter.min()) / float64(len(vs))
+}
+
 func (sorter *versionSorter) isEqual(a, b float64) bool {
 	delta := math.Abs(float64(a - b))
-	return delta < sorter.epsilon
+	return delta == 0 || delta < sorter.epsilon
 }
 
 func (sorter *versionSorter) Swap(i, j int) {
@@ -62,7 +73,11 @@ func (sorter *versionSorter) Less(i, j int) bool {
 	b := sorter.versions[j]
 
 	if sorter.isEqual(a.relevance, b.relevance) {
-		return core.Compare(a.name, b.name) < 0
+		if c := core.Compare(a.name, b.name); c != 0 {
+			return c < 0
+		}
+		// Identifier makes order stable for pages
+		return a.doc.Id < b.doc.Id
 	}
 
 	return a.relevance > b.relevance
@@ -0,0 +1,158 @@
+package parcels
+
+import (
+	"context"
+	"encoding/gob"
+	"sort"
+)
+
+// Default count of the best hypotheses, judged by estimator
+const defaultEstimatorK = 5
+
+// QueryEstimator is estimator, that also judges query of rule, which produced hypotheses.
+type QueryEstimator interface {
+	Estimator
+	EstimateQuery(ctx context.Context, rule Rule, query []rune, hs Hypotheses) float64
+}
+
+// Estimate hypotheses of rule for query. Estimator, which doesn't judge queries, sees hypotheses only.
+func estimate(ctx context.Context, estimator Estimator, rule Rule, query []rune, hs Hypotheses) float64 {
+	if e, ok := estimator.(QueryEstimator); ok {
+		return e.EstimateQuery(ctx, rule, query, hs)
+	}
+	return estimator.Estimate(hs)
+}
+
+// Get k the best relevances of hypotheses DESC.
+// Missing ranks have zero relevance, so set of fewer hypotheses is judged lower.
+func topRelevances(hs Hypotheses, k int) []float64 {
+	res := make([]float64, 0, len(hs)+k)
+	for _, v := range hs {
+		res = append(res, v)
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(res)))
+	for len(res) < k {
+		res = append(res, 0)
+	}
+	return res[:k]
+}
+
+type topMeanEstimator struct {
+	K int // Count of the best hypotheses
+}
+
+// Estimate is mean relevance of k the best hypotheses. Missing hypotheses have zero relevance.
+func (estimator *topMeanEstimator) Estimate(hs Hypotheses) float64 {
+	k := estimator.K
+	if k <= 0 {
+		k = defaultEstimatorK
+	}
+	var sum float64
+	for _, v := range topRelevances(hs, k) {
+		sum += v
+	}
+	return sum / float64(k)
+}
+
+// NewTopMeanEstimator is constructor of estimator by mean of k the best hypotheses.
+// Count k is 5, if it isn't positive.
+func NewTopMeanEstimator(k int) Estimator {
+	return &topMeanEstimator{K: k}
+}
+
+type gapEstimator struct {
+	K int // Position of hypothesis, compared with the best one
+}
+
+// Estimate is gap between relevance of the best hypothesis and the k-th one,
+// so set with distinct leader wins. Missing k-th hypothesis has zero relevance.
+func (estimator *gapEstimator) Estimate(hs Hypotheses) float64 {
+	k := estimator.K
+	if k <= 1 {
+		k = defaultEstimatorK
+	}
+	top := topRelevances(hs, k)
+	return top[0] - top[k-1]
+}
+
+// NewGapEstimator is constructor of estimator by gap between the best and the k-th hypotheses.
+// Position k is 5, if it is less than 2.
+func NewGapEstimator(k int) Estimator {
+	return &gapEstimator{K: k}
+}
+
+type coverageEstimator struct {
+}
+
+// Estimate is relevance of the best hypothesis, because query is unknown.
+func (estimator *coverageEstimator) Estimate(hs Hypotheses) float64 {
+	return NewMaxEstimator().Estimate(hs)
+}
+
+// EstimateQuery is relevance of the best hypothesis, scaled by share of query ngrams,
+// which are known to ngram rules of the branch. Branch without ngram rules isn't scaled.
+func (estimator *coverageEstimator) EstimateQuery(
+	ctx context.Context,
+	rule Rule,
+	query []rune,
+	hs Hypotheses,
+) float64 {
+	best := estimator.Estimate(hs)
+	if best == 0 {
+		return 0
+	}
+	known, total := ngramCoverage(ctx, rule, query)
+	if total == 0 {
+		return best
+	}
+	return best * float64(known) / float64(total)
+}
+
+// NewCoverageEstimator is constructor of estimator by coverage of query ngrams.
+func NewCoverageEstimator() Estimator {
+	return &coverageEstimator{}
+}
+
+// ngramCounter is parser, that can count all ngrams of runes.
+type ngramCounter interface {
+	// Get count of ngrams, including ngrams, which are absent in dictionary
+	windows(ctx context.Context, runes []rune) int
+}
+
+// Count ngrams of query, which are known to ngram rules of rule, and all ngrams of query.
+// Query is passed down like search does, translations of layout rules are skipped.
+func ngramCoverage(ctx context.Context, rule Rule, query []rune) (int, int) {
+	switch r := rule.(type) {
+	case *Entry:
+		return ngramCoverage(ctx, r.Rule, query)
+	case *ngramRule:
+		parser, _ := r.current()
+		counter, ok := parser.(ngramCounter)
+		if !ok {
+			return 0, 0
+		}
+		ngrams, _ := parser.Parse(ctx, query, false)
+		return len(ngrams), counter.windows(ctx, query)
+	case *muteRule:
+		return ngramCoverage(ctx, r.Rule, r.MutatorSearch.Mute(ctx, query))
+	case *GuardRule:
+		if !r.PredicateSearch.Test(ctx, query) {
+			return 0, 0
+		}
+		return ngramCoverage(ctx, r.Rule, query)
+	default:
+		var known, total int
+		for _, child := range rule.Children() {
+			k, t := ngramCoverage(ctx, child, query)
+			known += k
+			total += t
+		}
+		return known, total
+	}
+}
+
+func init() {
+	gob.Register(&topMeanEstimator{})
+	gob.Register(&gapEstimator{})
+	gob.Register(&coverageEstimator{})
+}
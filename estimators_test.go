@@ -0,0 +1,126 @@
+package parcels
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimators(t *testing.T) {
+	// Single strong hypothesis against several good ones
+	single := Hypotheses{1: 0.9}
+	several := Hypotheses{1: 0.8, 2: 0.7, 3: 0.6}
+
+	// Missing hypotheses have zero relevance
+	assert.InDelta(t, 0.45, NewTopMeanEstimator(2).Estimate(single), 1e-9)
+	assert.InDelta(t, 0.75, NewTopMeanEstimator(2).Estimate(several), 1e-9)
+	assert.InDelta(t, 0.42, NewTopMeanEstimator(0).Estimate(several), 1e-9)
+
+	assert.InDelta(t, 0.9, NewGapEstimator(3).Estimate(single), 1e-9)
+	assert.InDelta(t, 0.2, NewGapEstimator(3).Estimate(several), 1e-9)
+	assert.InDelta(t, 0.8, NewGapEstimator(0).Estimate(several), 1e-9)
+
+	assert.InDelta(t, 0.9, NewCoverageEstimator().Estimate(single), 1e-9)
+
+	for _, estimator := range []Estimator{NewTopMeanEstimator(2), NewGapEstimator(2), NewCoverageEstimator()} {
+		assert.Equal(t, float64(0), estimator.Estimate(nil))
+	}
+}
+
+func TestCoverageEstimator(t *testing.T) {
+	ctx := context.Background()
+	spec, err := ParseStrategySpec([]byte(`{
+		"root": "root",
+		"nodes": {
+			"root": {"kind": "mute", "mutator": "lower", "rule": "ngrams"},
+			"ngrams": {"kind": "multi", "estimator": "coverage", "entries": [
+				{"node": "primary", "weight": 1},
+				{"node": "secondary", "weight": 1}
+			]},
+			"primary": {"kind": "ngram", "length": 3},
+			"secondary": {"kind": "ngram", "parser": "secondary", "length": 3}
+		}
+	}`))
+	require.NoError(t, err)
+	st, err := NewStrategyFromSpec(nil, nil, spec, docNameSearchIndexReader)
+	require.NoError(t, err)
+	require.NoError(t, st.Append(ctx, &Doc{Id: 1, NameSearchIndex: "аспирин"}))
+
+	rule := st.(*strategy).Rule
+	estimator := rule.(*muteRule).Rule.(*MultiRule).Estimator
+	_, ok := estimator.(*coverageEstimator)
+	assert.True(t, ok)
+
+	// Query is lowered by mute rule. Primary parser knows 3 of 4 ngrams, secondary parser knows 3 of 6 ngrams
+	known, total := ngramCoverage(ctx, rule, []rune("АСПИР ЁЁЁ"))
+	assert.Equal(t, 6, known)
+	assert.Equal(t, 10, total)
+	known, total = ngramCoverage(ctx, rule, []rune("аспирин"))
+	assert.Equal(t, 10, known)
+	assert.Equal(t, 10, total)
+
+	hs := Hypotheses{1: 0.8}
+	assert.InDelta(t, 0.48, estimate(ctx, estimator, rule, []rune("АСПИР ЁЁЁ"), hs), 1e-9)
+	assert.InDelta(t, 0.8, estimate(ctx, estimator, rule, []rune("аспирин"), hs), 1e-9)
+	// Plain estimator doesn't judge query
+	assert.InDelta(t, 0.8, estimate(ctx, NewMaxEstimator(), rule, []rune("ёёё"), hs), 1e-9)
+}
+
+func TestStrategyOptionsEstimator(t *testing.T) {
+	options := DefaultStrategyOptions()
+	options.Ngrams.Estimator = "gap"
+	options.Translators.Weight = 0.5
+	options.Translators.Estimator = "topmean"
+	options.Translators.Keyboard.En2Ru = true
+	st, err := NewStrategyDefault(nil, options, docNameSearchIndexReader)
+	require.NoError(t, err)
+
+	var multi, layout Estimator
+	var find func(rule Rule)
+	find = func(rule Rule) {
+		switch r := rule.(type) {
+		case *MultiRule:
+			if strings.HasPrefix(r.Name(), "main.") {
+				multi = r.Estimator
+			}
+		case *layoutRule:
+			layout = r.Estimator
+		}
+		for _, child := range rule.Children() {
+			find(child)
+		}
+	}
+	find(st.(*strategy).Rule)
+	_, ok := multi.(*gapEstimator)
+	assert.True(t, ok)
+	_, ok = layout.(*topMeanEstimator)
+	assert.True(t, ok)
+
+	// Empty estimator is max estimator, unknown estimator is error of strategy
+	estimator, err := newNgramEstimator("")
+	require.NoError(t, err)
+	_, ok = estimator.(*maxEstimator)
+	assert.True(t, ok)
+	options.Translators.Estimator = "median"
+	_, err = NewStrategyDefault(nil, options, docNameSearchIndexReader)
+	assert.Error(t, err)
+}
+
+func TestEstimatorsGob(t *testing.T) {
+	for _, estimator := range []Estimator{
+		NewTopMeanEstimator(3),
+		NewGapEstimator(2),
+		NewCoverageEstimator(),
+	} {
+		var data bytes.Buffer
+		require.NoError(t, gob.NewEncoder(&data).Encode(&estimator))
+		var actual Estimator
+		require.NoError(t, gob.NewDecoder(&data).Decode(&actual))
+		assert.Equal(t, estimator, actual)
+	}
+}
@@ -0,0 +1,409 @@
+package parcels
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// EvalQuery is judged query of offline evaluation.
+// Relevant names are ordered by relevance DESC: the first name is the best answer.
+type EvalQuery struct {
+	Query    string   `json:"query"`
+	Relevant []string `json:"relevant"`
+}
+
+// EvalCatalog is catalog dump of offline evaluation.
+type EvalCatalog []*Doc
+
+// EvalQueryResult is result of single judged query.
+// Every metric is measured at K, names out of top K are not found.
+type EvalQueryResult struct {
+	Query  string   `json:"query"`
+	RR     float64  `json:"rr"`     // Reciprocal rank of the first relevant name at K, zero if it is out of K
+	NDCG   float64  `json:"ndcg"`   // DCG at K divided by DCG of ideal ranking at K
+	Recall float64  `json:"recall"` // Relevant names at K divided by min(K, count of relevant names)
+	Found  []string `json:"found"`  // Names at K
+}
+
+// EvalReport is summary of offline evaluation. Metrics are means of metrics of queries at K.
+type EvalReport struct {
+	K       int                `json:"k"`
+	MRR     float64            `json:"mrr"`
+	NDCG    float64            `json:"ndcg"`
+	Recall  float64            `json:"recall"`
+	Queries []*EvalQueryResult `json:"queries"`
+}
+
+// EvalQueryDiff is difference of single query between two configurations.
+type EvalQueryDiff struct {
+	Query  string           `json:"query"`
+	RR     float64          `json:"rr"`     // Other - Base
+	NDCG   float64          `json:"ndcg"`   // Other - Base
+	Recall float64          `json:"recall"` // Other - Base
+	Base   *EvalQueryResult `json:"base"`
+	Other  *EvalQueryResult `json:"other"`
+}
+
+// EvalComparison is difference between two configurations.
+// Only changed queries are listed, the most changed are the first.
+type EvalComparison struct {
+	K       int              `json:"k"`
+	MRR     float64          `json:"mrr"`    // Other - Base
+	NDCG    float64          `json:"ndcg"`   // Other - Base
+	Recall  float64          `json:"recall"` // Other - Base
+	Base    *EvalReport      `json:"base"`
+	Other   *EvalReport      `json:"other"`
+	Queries []*EvalQueryDiff `json:"queries"`
+}
+
+// ParseEvalCatalog parses catalog dump.
+// Dump is JSON array of documents or plain list of names (one name per line).
+// Documents of plain list are identified by line number, starting from 1.
+func ParseEvalCatalog(data []byte) (EvalCatalog, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) != 0 && data[0] == '[' {
+		var catalog EvalCatalog
+		err := json.Unmarshal(data, &catalog)
+		if err != nil {
+			return nil, fmt.Errorf("Unmarshal: %w", err)
+		}
+		return catalog, nil
+	}
+
+	var catalog EvalCatalog
+	for i, line := range strings.Split(string(data), "\n") {
+		name := strings.TrimSpace(line)
+		if name == "" {
+			continue
+		}
+		catalog = append(
+			catalog,
+			&Doc{
+				Id:              int64(i + 1),
+				Name:            name,
+				NameLong:        name,
+				NameSearchIndex: name,
+				NameGroupIndex:  name,
+			},
+		)
+	}
+	return catalog, nil
+}
+
+// ParseEvalQueries parses JSON array of judged queries.
+func ParseEvalQueries(data []byte) ([]*EvalQuery, error) {
+	var queries []*EvalQuery
+	err := json.Unmarshal(data, &queries)
+	if err != nil {
+		return nil, fmt.Errorf("Unmarshal: %w", err)
+	}
+	return queries, nil
+}
+
+// ReadEvalPoll reads judged queries from poll directory.
+// Name of file is query, lines of file are relevant names.
+func ReadEvalPoll(dir string) ([]*EvalQuery, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("ReadDir: %w", err)
+	}
+
+	var queries []*EvalQuery
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("ReadFile: %w", err)
+		}
+		query := &EvalQuery{Query: file.Name()}
+		for _, line := range strings.Split(string(data), "\n") {
+			if name := strings.TrimSpace(line); name != "" {
+				query.Relevant = append(query.Relevant, name)
+			}
+		}
+		queries = append(queries, query)
+	}
+	return queries, nil
+}
+
+// ParseStrategyOptions parses options of strategy.
+// Missing fields take values of DefaultStrategyOptions.
+func ParseStrategyOptions(data []byte) (*StrategyOptions, error) {
+	options := DefaultStrategyOptions()
+	err := json.Unmarshal(data, options)
+	if err != nil {
+		return nil, fmt.Errorf("Unmarshal: %w", err)
+	}
+	return options, nil
+}
+
+// ReadEvalCatalog reads catalog dump from file.
+func ReadEvalCatalog(path string) (EvalCatalog, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ReadFile: %w", err)
+	}
+	return ParseEvalCatalog(data)
+}
+
+// ReadEvalQueries reads judged queries from JSON file or poll directory.
+func ReadEvalQueries(path string) ([]*EvalQuery, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("Stat: %w", err)
+	}
+	if info.IsDir() {
+		return ReadEvalPoll(path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ReadFile: %w", err)
+	}
+	return ParseEvalQueries(data)
+}
+
+// ReadStrategyOptions reads options of strategy from JSON file.
+// Default options are returned for empty path.
+func ReadStrategyOptions(path string) (*StrategyOptions, error) {
+	if path == "" {
+		return DefaultStrategyOptions(), nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ReadFile: %w", err)
+	}
+	return ParseStrategyOptions(data)
+}
+
+// Evaluate builds strategy of options over catalog,
+// runs judged queries and measures quality of top k names.
+func Evaluate(
+	ctx context.Context,
+	catalog EvalCatalog,
+	queries []*EvalQuery,
+	options *StrategyOptions,
+	k int,
+) (*EvalReport, error) {
+	if options == nil {
+		options = DefaultStrategyOptions()
+	}
+
+	docs := &mapDocManager{}
+	strategy, err := NewStrategyByOptions(docs, options, docNameSearchIndexReader)
+	if err != nil {
+		return nil, fmt.Errorf("NewStrategyByOptions: %w", err)
+	}
+	strategy.BeginBuild(ctx)
+	for _, doc := range catalog {
+		err := docs.Append(ctx, doc)
+		if err != nil {
+			return nil, fmt.Errorf("Append: %w", err)
+		}
+		err = strategy.Append(ctx, doc)
+		if err != nil {
+			return nil, fmt.Errorf("Append: %w", err)
+		}
+	}
+	err = strategy.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Commit: %w", err)
+	}
+
+	details := &Details{
+		Band:    options.Band,
+		Exact:   0.5,
+		Weights: defaultWeights,
+	}
+	if details.Band.Capacity <= 0 {
+		details.Band.Capacity = 100
+	}
+
+	report := &EvalReport{K: k}
+	for _, query := range queries {
+		hs, err := evalSearch(ctx, strategy, query.Query, details)
+		if err != nil {
+			return nil, fmt.Errorf("search (%s): %w", query.Query, err)
+		}
+		vs, err := docs.Versions(ctx, hs, docNameSearchIndexReader)
+		if err != nil {
+			return nil, fmt.Errorf("Versions: %w", err)
+		}
+		vs, err = evalBand(vs, &details.Band)
+		if err != nil {
+			return nil, fmt.Errorf("evalBand: %w", err)
+		}
+		names := evalNames(vs)
+		report.Queries = append(report.Queries, evalQuery(query, names, k))
+	}
+
+	if n := float64(len(report.Queries)); n > 0 {
+		for _, q := range report.Queries {
+			report.MRR += q.RR / n
+			report.NDCG += q.NDCG / n
+			report.Recall += q.Recall / n
+		}
+	}
+	return report, nil
+}
+
+// CompareEvalReports finds difference between reports of the same judged queries.
+func CompareEvalReports(base, other *EvalReport) *EvalComparison {
+	res := &EvalComparison{
+		K:      base.K,
+		MRR:    other.MRR - base.MRR,
+		NDCG:   other.NDCG - base.NDCG,
+		Recall: other.Recall - base.Recall,
+		Base:   base,
+		Other:  other,
+	}
+
+	queries := make(map[string]*EvalQueryResult, len(other.Queries))
+	for _, q := range other.Queries {
+		queries[q.Query] = q
+	}
+	for _, b := range base.Queries {
+		o, ok := queries[b.Query]
+		if !ok {
+			continue
+		}
+		diff := &EvalQueryDiff{
+			Query:  b.Query,
+			RR:     o.RR - b.RR,
+			NDCG:   o.NDCG - b.NDCG,
+			Recall: o.Recall - b.Recall,
+			Base:   b,
+			Other:  o,
+		}
+		if diff.RR != 0 || diff.NDCG != 0 || diff.Recall != 0 {
+			res.Queries = append(res.Queries, diff)
+		}
+	}
+	sort.SliceStable(res.Queries, func(i, j int) bool {
+		return math.Abs(res.Queries[i].NDCG) > math.Abs(res.Queries[j].NDCG)
+	})
+	return res
+}
+
+// Search query without filter of entities
+func evalSearch(
+	ctx context.Context,
+	st Strategy,
+	query string,
+	details *Details,
+) (Hypotheses, error) {
+	s, ok := st.(*strategy)
+	if !ok {
+		return st.Search(ctx, nil, query, details)
+	}
+	res := &resolver{
+		cache: make(map[resolverKey]Hypotheses, 1024),
+	}
+	return res.Resolve(ctx, s.Rule, s.prepare(ctx, query), 1, details), nil
+}
+
+// Cut sorted versions by band
+func evalBand(vs versions, band *BandOptions) (versions, error) {
+	cutter, err := NewBandCutter(band)
+	if err != nil {
+		return nil, fmt.Errorf("NewBandCutter: %w", err)
+	}
+	relevances := make([]float64, len(vs))
+	for i, v := range vs {
+		relevances[i] = v.relevance
+	}
+	return vs[:cutter.Cut(relevances)], nil
+}
+
+// Normalize name for comparison: versions are named in lower case, judged names are verbatim
+func evalName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// Get distinct names of versions in order of ranking
+func evalNames(vs versions) []string {
+	names := make([]string, 0, len(vs))
+	seen := make(map[string]bool, len(vs))
+	for _, v := range vs {
+		name := evalName(v.name)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Measure ranked names against judged query
+func evalQuery(query *EvalQuery, names []string, k int) *EvalQueryResult {
+	// Gain of relevant name depends on its position in judged list
+	gains := make(map[string]float64, len(query.Relevant))
+	for i, name := range query.Relevant {
+		name = evalName(name)
+		if _, ok := gains[name]; !ok {
+			gains[name] = float64(len(query.Relevant) - i)
+		}
+	}
+
+	res := &EvalQueryResult{Query: query.Query}
+	normalized := make([]string, len(names))
+	for i, name := range names {
+		normalized[i] = evalName(name)
+	}
+	top := normalized
+	if k > 0 && len(top) > k {
+		top = top[:k]
+	}
+	res.Found = top
+
+	for i, name := range top {
+		if _, ok := gains[name]; ok {
+			res.RR = 1 / float64(i+1)
+			break
+		}
+	}
+
+	var dcg float64
+	var hits int
+	for i, name := range top {
+		if gain, ok := gains[name]; ok {
+			dcg += gain / math.Log2(float64(i+2))
+			hits++
+		}
+	}
+
+	ideal := make([]float64, 0, len(gains))
+	for _, gain := range gains {
+		ideal = append(ideal, gain)
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(ideal)))
+	var idcg float64
+	for i, gain := range ideal {
+		if k > 0 && i >= k {
+			break
+		}
+		idcg += gain / math.Log2(float64(i+2))
+	}
+
+	if idcg > 0 {
+		res.NDCG = dcg / idcg
+	}
+	// Top of k names can't hold more than k relevant names
+	relevant := len(gains)
+	if k > 0 && relevant > k {
+		relevant = k
+	}
+	if relevant > 0 {
+		res.Recall = float64(hits) / float64(relevant)
+	}
+	return res
+}
@@ -0,0 +1,131 @@
+package parcels
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvalQuery(t *testing.T) {
+	query := &EvalQuery{
+		Query:    "q",
+		Relevant: []string{"a", "b"},
+	}
+
+	// Ideal ranking
+	res := evalQuery(query, []string{"a", "b", "c"}, 2)
+	assert.Equal(t, 1.0, res.RR)
+	assert.InDelta(t, 1.0, res.NDCG, 1e-9)
+	assert.Equal(t, 1.0, res.Recall)
+	assert.Equal(t, []string{"a", "b"}, res.Found)
+
+	// The best name is out of k
+	res = evalQuery(query, []string{"c", "b", "a"}, 2)
+	assert.Equal(t, 0.5, res.RR)
+	idcg := 2 + 1/math.Log2(3)
+	assert.InDelta(t, (1/math.Log2(3))/idcg, res.NDCG, 1e-9)
+	assert.Equal(t, 0.5, res.Recall)
+
+	// Relevant name out of k isn't found by any metric
+	res = evalQuery(query, []string{"c", "d", "a"}, 2)
+	assert.Equal(t, 0.0, res.RR)
+	assert.Equal(t, 0.0, res.NDCG)
+	assert.Equal(t, 0.0, res.Recall)
+
+	// Recall is complete, if k is filled by relevant names
+	res = evalQuery(query, []string{"b", "c"}, 1)
+	assert.Equal(t, 1.0, res.RR)
+	assert.Equal(t, 1.0, res.Recall)
+	assert.InDelta(t, 1/2.0, res.NDCG, 1e-9)
+
+	// Nothing is found
+	res = evalQuery(query, nil, 2)
+	assert.Equal(t, 0.0, res.RR)
+	assert.Equal(t, 0.0, res.NDCG)
+	assert.Equal(t, 0.0, res.Recall)
+}
+
+func TestEvaluateMixedCase(t *testing.T) {
+	// Names of catalog and judgements are written in upper case, like in real data
+	catalog, err := ParseEvalCatalog([]byte("АСПИРИН\nАспаркам\nАНАЛЬГИН\n"))
+	require.NoError(t, err)
+	queries, err := ParseEvalQueries([]byte(`[
+		{"query": "аспирин", "relevant": [" Аспирин "]},
+		{"query": "анальгин", "relevant": ["АНАЛЬГИН"]}
+	]`))
+	require.NoError(t, err)
+
+	report, err := Evaluate(context.Background(), catalog, queries, nil, 10)
+	require.NoError(t, err)
+	require.Len(t, report.Queries, 2)
+	for _, q := range report.Queries {
+		assert.Equal(t, 1.0, q.RR, q.Query)
+		assert.Equal(t, 1.0, q.Recall, q.Query)
+		assert.InDelta(t, 1.0, q.NDCG, 1e-9, q.Query)
+	}
+}
+
+func TestEvalBand(t *testing.T) {
+	vs := versions{
+		{name: "a", relevance: 1},
+		{name: "b", relevance: 0.9},
+		{name: "c", relevance: 0.4},
+		{name: "d", relevance: 0.35},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		band     BandOptions
+		expected int
+	}{
+		{"unlimited", BandOptions{Capacity: 10}, 4},
+		{"capacity", BandOptions{Capacity: 3}, 3},
+		{"threshold", BandOptions{Capacity: 10, Threshold: 0.5}, 2},
+		// Ratio to the previous line, but not to the first one
+		{"gap", BandOptions{Capacity: 10, Diff: BandDiffOptions{Rel: 0.8}}, 2},
+		{"top", BandOptions{Capacity: 10, Diff: BandDiffOptions{Abs: 0.38}}, 3},
+	} {
+		res, err := evalBand(vs, &tc.band)
+		require.NoError(t, err, tc.name)
+		assert.Len(t, res, tc.expected, tc.name)
+	}
+
+	_, err := evalBand(vs, &BandOptions{Mode: "median"})
+	assert.Error(t, err)
+}
+
+func TestEvaluate(t *testing.T) {
+	catalog, err := ParseEvalCatalog([]byte("аспирин\nаспаркам\nанальгин\nпарацетамол\n"))
+	require.NoError(t, err)
+	require.Len(t, catalog, 4)
+	assert.Equal(t, int64(1), catalog[0].Id)
+
+	queries, err := ParseEvalQueries([]byte(`[
+		{"query": "аспирин", "relevant": ["аспирин"]},
+		{"query": "парацетомол", "relevant": ["парацетамол"]}
+	]`))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	base, err := Evaluate(ctx, catalog, queries, nil, 10)
+	require.NoError(t, err)
+	require.Len(t, base.Queries, 2)
+	assert.Equal(t, 1.0, base.Queries[0].RR)
+	assert.Equal(t, "аспирин", base.Queries[0].Found[0])
+	assert.Greater(t, base.MRR, 0.0)
+
+	options, err := ParseStrategyOptions([]byte(`{"band": {"capacity": 1, "threshold": 2}}`))
+	require.NoError(t, err)
+	assert.Equal(t, DefaultStrategyOptions().Ngrams, options.Ngrams)
+	other, err := Evaluate(ctx, catalog, queries, options, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, other.MRR)
+
+	cmp := CompareEvalReports(base, other)
+	assert.Equal(t, -base.MRR, cmp.MRR)
+	require.NotEmpty(t, cmp.Queries)
+	assert.Equal(t, "аспирин", cmp.Queries[0].Query)
+}
@@ -0,0 +1,297 @@
+package parcels
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Explainer is manager, that can explain relevance of document.
+type Explainer interface {
+	// Explain relevance of document for query of given paradigm type
+	Explain(ctx context.Context, query string, typ string, id int64) (*ExplainNode, error)
+}
+
+// ExplainNode is node of relevance breakdown of document.
+// Nodes of rules repeat the structure of strategy, which was walked by resolver.
+type ExplainNode struct {
+	Name      string         `json:"name"`             // Name of rule or paradigm type
+	Kind      string         `json:"kind"`             // Kind of node
+	Query     string         `json:"query"`            // Query after mutators and translators
+	Weight    float64        `json:"weight"`           // Weight of query, passed into rule
+	Scale     float64        `json:"scale"`            // Weight, applied by parent to result of node
+	Found     bool           `json:"found"`            // Document is found by node
+	Relevance float64        `json:"relevance"`        // Relevance of document before scaling by parent
+	Estimate  float64        `json:"estimate"`         // Estimate of scaled result, used by parent for choice of branch
+	Selected  bool           `json:"selected"`         // Result of node is used by parent
+	Mixer     string         `json:"mixer,omitempty"`  // Decision of mixer
+	Ngrams    []ExplainNgram `json:"ngrams,omitempty"` // Matched ngrams of document
+	Children  []*ExplainNode `json:"children,omitempty"`
+
+	hypotheses Hypotheses // Result of node
+	rule       Rule       // Rule of node
+}
+
+// ExplainNgram is ngram of query, matched in document.
+type ExplainNgram struct {
+	Text     string  `json:"text"`     // Literal representation
+	QueryPos int16   `json:"queryPos"` // Position in query
+	DocPos   int16   `json:"docPos"`   // Position in document
+	Weight   float64 `json:"weight"`   // Weight of document reference
+}
+
+// explainTrace collects nodes of explanation, while resolver walks strategy.
+type explainTrace struct {
+	doc   int64
+	stack []*ExplainNode
+}
+
+type explainTraceKey struct{}
+
+// Get trace of explanation from context
+func explainTraceFrom(ctx context.Context) *explainTrace {
+	trace, _ := ctx.Value(explainTraceKey{}).(*explainTrace)
+	return trace
+}
+
+func withExplainTrace(ctx context.Context, trace *explainTrace) context.Context {
+	return context.WithValue(ctx, explainTraceKey{}, trace)
+}
+
+func newExplainTrace(doc int64, root *ExplainNode) *explainTrace {
+	return &explainTrace{
+		doc:   doc,
+		stack: []*ExplainNode{root},
+	}
+}
+
+// Start node of rule as child of the current node
+func (trace *explainTrace) enter(rule Rule, query []rune, weight, scale float64) *ExplainNode {
+	node := &ExplainNode{
+		Name:   rule.Name(),
+		Kind:   ruleKind(rule),
+		Query:  string(query),
+		Weight: weight,
+		Scale:  scale,
+		rule:   rule,
+	}
+	parent := trace.stack[len(trace.stack)-1]
+	parent.Children = append(parent.Children, node)
+	trace.stack = append(trace.stack, node)
+	return node
+}
+
+// Finish the current node with result of rule
+func (trace *explainTrace) leave(ctx context.Context, rule Rule, hs Hypotheses) {
+	node := trace.stack[len(trace.stack)-1]
+	trace.stack = trace.stack[:len(trace.stack)-1]
+
+	node.hypotheses = hs
+	node.Relevance, node.Found = hs[trace.doc]
+	node.Estimate = NewMaxEstimator().Estimate(hs.scale(node.Scale))
+
+	switch r := rule.(type) {
+	case *ngramRule:
+		if node.Found {
+			node.Ngrams = r.explain(ctx, []rune(node.Query), trace.doc)
+		}
+	case *MultiRule:
+		// Decision of mixer is recorded by search of rule
+	case *layoutRule:
+		explainLayout(ctx, node, r)
+	default:
+		for _, child := range node.Children {
+			child.Selected = true
+		}
+	}
+}
+
+// Record decision of mixer about document. Branches are children of the current node in the same order.
+func (trace *explainTrace) mix(mixer Mixer, branches []branch) {
+	node := trace.stack[len(trace.stack)-1]
+	selected, decision := mixer.Explain(branches, trace.doc)
+	node.Mixer = decision
+	// Cancelled search skips branches
+	if len(node.Children) != len(branches) {
+		return
+	}
+	for i, child := range node.Children {
+		child.Estimate = branches[i].relevance
+		child.Selected = selected[i]
+	}
+}
+
+// Explain choice of layout. The first child is original query, others are translated queries.
+func explainLayout(ctx context.Context, node *ExplainNode, rule *layoutRule) {
+	var best *ExplainNode
+	for i, child := range node.Children {
+		if i != 0 {
+			child.Scale = rule.Weight
+		}
+		child.Estimate = estimate(ctx, rule.Estimator, rule.Rule, []rune(child.Query), child.hypotheses.scale(child.Scale))
+		if child.Estimate > 0 && (best == nil || child.Estimate > best.Estimate) {
+			best = child
+		}
+	}
+	if best != nil {
+		best.Selected = true
+		node.Mixer = fmt.Sprintf("layout: query %q with estimate %g", best.Query, best.Estimate)
+	}
+}
+
+func mixerName(mixer Mixer) string {
+	switch mixer.(type) {
+	case *maxMixer:
+		return "max"
+	case *sumMixer:
+		return "sum"
+	case *intersectMixer:
+		return "intersect"
+	case *weightedMinMixer:
+		return "wmin"
+	case *differenceMixer:
+		return "difference"
+	case *rrfMixer:
+		return "rrf"
+	case *minMaxMixer:
+		return "minmax"
+	case *zScoreMixer:
+		return "zscore"
+	default:
+		return fmt.Sprintf("%T", mixer)
+	}
+}
+
+func ruleKind(rule Rule) string {
+	switch rule.(type) {
+	case *ngramRule:
+		return NodeKindNgram
+	case *MultiRule:
+		return NodeKindMulti
+	case *GuardRule:
+		return NodeKindGuard
+	case *muteRule:
+		return NodeKindMute
+	case *layoutRule:
+		return NodeKindLayout
+	case *filterRule:
+		return NodeKindFilter
+	default:
+		return fmt.Sprintf("%T", rule)
+	}
+}
+
+// Find ngrams of query in document
+func (rule *ngramRule) explain(ctx context.Context, query []rune, doc int64) []ExplainNgram {
+	parser, current := rule.current()
+	ngrams, _ := parser.Parse(ctx, query, false)
+	index, ok := current.(*ngramIndex)
+	if !ok {
+		return nil
+	}
+
+	segment := index.segment()
+	var res []ExplainNgram
+	for _, ngram := range ngrams {
+		rs, ok := segment.find(ngram.Id)
+		if !ok {
+			continue
+		}
+		cursor := rs.Cursor()
+		cursor.Advance(doc)
+		if r, ok := cursor.Ref(); ok && r.Doc == doc {
+			res = append(
+				res,
+				ExplainNgram{
+					Text:     ngram.Text,
+					QueryPos: ngram.Pos,
+					DocPos:   r.Pos,
+					Weight:   r.Weight,
+				},
+			)
+		}
+	}
+	return res
+}
+
+// Explain relevance of document for query of given paradigm type.
+// Search is done in the same way as Search, but without band limit
+// and without cache of resolver, so every branch of strategy is shown.
+func (engine *advancedEngine) Explain(
+	ctx context.Context,
+	query string,
+	typ string,
+	id int64,
+) (*ExplainNode, error) {
+	details := new(Details)
+	engine.InitDetails(details)
+	engine.initDetails(details)
+	// Document can be out of band of single rule
+	details.Band.Capacity = 0
+
+	engine.RLock()
+	defer engine.RUnlock()
+
+	query = strings.TrimSpace(strings.ToLower(query))
+	root := &ExplainNode{
+		Name:  typ,
+		Kind:  "paradigm",
+		Query: query,
+		Scale: 1,
+	}
+
+	paradigm := getParadigm(typ)
+	types := paradigm.types
+	if len(types) == 0 {
+		types = []string{typ}
+	}
+
+	list := make([]Hypotheses, 0, len(types))
+	for _, t := range types {
+		p := paradigm
+		if len(paradigm.types) != 0 {
+			p = getParadigm(t)
+		}
+
+		node := &ExplainNode{
+			Name:   t,
+			Kind:   "method",
+			Query:  query,
+			Weight: 1,
+			Scale:  1,
+		}
+		root.Children = append(root.Children, node)
+
+		trace := newExplainTrace(id, node)
+		hss, err := engine.search(withExplainTrace(ctx, trace), p.method, query, details)
+		if err != nil {
+			return nil, fmt.Errorf("search (%s): %w", t, err)
+		}
+		node.Relevance, node.Found = hss[id]
+		node.hypotheses = hss
+		for _, child := range node.Children {
+			child.Selected = true
+		}
+
+		list = append(list, hss)
+	}
+
+	hs := paradigm.mix(list)
+	root.Relevance, root.Found = hs[id]
+	root.hypotheses = hs
+	if paradigm.mixer != nil {
+		selected, decision := paradigm.mixer.Explain(paradigm.branches(list), id)
+		root.Mixer = decision
+		for i, child := range root.Children {
+			child.Selected = selected[i] && root.Found
+		}
+		return root, nil
+	}
+
+	// Paradigm takes the best relevance of methods
+	for _, child := range root.Children {
+		child.Selected = child.Found && child.Relevance == root.Relevance
+	}
+
+	return root, nil
+}
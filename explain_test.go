@@ -0,0 +1,80 @@
+package parcels
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplainTrace(t *testing.T) {
+	ctx := context.Background()
+	spec, err := ParseStrategySpec([]byte(`{
+		"root": "layout",
+		"nodes": {
+			"layout": {"kind": "layout", "rule": "root", "layouts": ["keyboard.en-ru"], "weight": 0.9},
+			"root": {"kind": "multi", "mixer": "max", "entries": [
+				{"node": "ngram.3", "weight": 2},
+				{"node": "ru", "weight": 1}
+			]},
+			"ngram.3": {"kind": "ngram", "length": 3},
+			"ru": {"kind": "mute", "mutator": "ru", "rule": "ru.ngram.3"},
+			"ru.ngram.3": {"kind": "ngram", "length": 3}
+		}
+	}`))
+	require.NoError(t, err)
+	st, err := NewStrategyFromSpec(nil, nil, spec, docNameSearchIndexReader)
+	require.NoError(t, err)
+
+	for i, name := range []string{"аспирин", "аскорбинка", "анальгин"} {
+		require.NoError(t, st.Append(ctx, &Doc{Id: int64(i + 1), NameSearchIndex: name}))
+	}
+
+	root := &ExplainNode{Name: "name", Scale: 1}
+	trace := newExplainTrace(1, root)
+	res := &resolver{cache: make(map[resolverKey]Hypotheses)}
+	rule := st.(*strategy).Rule
+	hs := res.Resolve(withExplainTrace(ctx, trace), rule, []rune("аспирин"), 1, &Details{})
+
+	// Cache isn't used while explaining
+	assert.Equal(t, ResolverStatistics{}, res.Statistics())
+
+	require.Len(t, root.Children, 1)
+	layout := root.Children[0]
+	assert.Equal(t, "layout", layout.Name)
+	assert.Equal(t, NodeKindLayout, layout.Kind)
+	assert.True(t, layout.Found)
+	assert.InDelta(t, hs[1], layout.Relevance, 1e-9)
+
+	// Query isn't translated, because it isn't english
+	require.Len(t, layout.Children, 1)
+	multi := layout.Children[0]
+	assert.True(t, multi.Selected)
+	assert.Contains(t, multi.Mixer, `max: branch "ngram.3"`)
+	require.Len(t, multi.Children, 2)
+
+	var ngram, mute *ExplainNode
+	for _, child := range multi.Children {
+		switch child.Name {
+		case "ngram.3":
+			ngram = child
+		case "ru":
+			mute = child
+		}
+	}
+	require.NotNil(t, ngram)
+	require.NotNil(t, mute)
+	assert.True(t, ngram.Selected)
+	assert.False(t, mute.Selected)
+	assert.InDelta(t, 2.0/3, ngram.Scale, 1e-9)
+	assert.Equal(t, "аспирин", ngram.Query)
+	assert.NotEmpty(t, ngram.Ngrams)
+	for _, n := range ngram.Ngrams {
+		assert.Contains(t, "аспирин", n.Text)
+	}
+
+	// Mutated query is shown for nested rule
+	require.Len(t, mute.Children, 1)
+	assert.Equal(t, string(MetaphoneRu([]rune("аспирин"))), mute.Children[0].Query)
+}
@@ -0,0 +1,186 @@
+package parcels
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"spWebFront/FrontKeeper/server/app/domain/model"
+)
+
+// Fields of facets
+const (
+	FacetMaker = "maker" // Maker of document
+	FacetInn   = "inn"   // Group of active substance
+	FacetName  = "name"  // Group of name
+)
+
+var facetReaders = map[string]Reader{
+	FacetMaker: docMakerReader,
+	FacetInn:   docInnGroupIndexReader,
+	FacetName:  docNameGroupIndexReader,
+}
+
+// Default count of values per facet
+const defaultFacetTop = 10
+
+// FacetOptions is request of facets.
+type FacetOptions struct {
+	Fields []string `json:"fields"` // Fields of facets: maker, inn, name. All fields, if empty
+	Top    int      `json:"top"`    // Maximal count of values per facet. Default 10
+}
+
+// FacetCount is count of documents with value of facet.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Facets is counts of values by facet fields.
+// Values are sorted by count DESC, value ASC.
+type Facets map[string][]FacetCount
+
+// Faceter is document manager, which can count facets of hypotheses.
+type Faceter interface {
+	Facets(ctx context.Context, hs Hypotheses, options FacetOptions) (Facets, error)
+}
+
+// FacetSearcher is manager, that can search with facets.
+type FacetSearcher interface {
+	SearchFacets(ctx context.Context, query string, typ string, details *Details, options FacetOptions) (*FacetResponse, error)
+}
+
+// FacetResponse is search results with facets of the whole set of hypotheses.
+type FacetResponse struct {
+	Parcels model.Parcels `json:"parcels"`
+	Facets  Facets        `json:"facets,omitempty"`
+}
+
+// Get readers of facet fields
+func facetFields(options FacetOptions) (map[string]Reader, error) {
+	if len(options.Fields) == 0 {
+		return facetReaders, nil
+	}
+	res := make(map[string]Reader, len(options.Fields))
+	for _, field := range options.Fields {
+		reader, ok := facetReaders[field]
+		if !ok {
+			return nil, fmt.Errorf("unknown facet %q", field)
+		}
+		res[field] = reader
+	}
+	return res, nil
+}
+
+// Count facets of documents. Documents with empty value are not counted.
+func countFacets(docs []*Doc, options FacetOptions) (Facets, error) {
+	fields, err := facetFields(options)
+	if err != nil {
+		return nil, err
+	}
+	top := options.Top
+	if top <= 0 {
+		top = defaultFacetTop
+	}
+
+	res := make(Facets, len(fields))
+	for field, reader := range fields {
+		counts := make(map[string]int)
+		for _, doc := range docs {
+			if value := reader(doc); value != "" {
+				counts[value]++
+			}
+		}
+
+		list := make([]FacetCount, 0, len(counts))
+		for value, count := range counts {
+			list = append(list, FacetCount{Value: value, Count: count})
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Count != list[j].Count {
+				return list[i].Count > list[j].Count
+			}
+			return list[i].Value < list[j].Value
+		})
+		if len(list) > top {
+			list = list[:top]
+		}
+		res[field] = list
+	}
+	return res, nil
+}
+
+// Facets counts facets of all hypotheses. Entity filter is not applied.
+func (docs *mapDocManager) Facets(
+	ctx context.Context,
+	hs Hypotheses,
+	options FacetOptions,
+) (Facets, error) {
+	list := make([]*Doc, 0, len(hs))
+	for id := range hs {
+		if doc := docs.find(id); doc != nil {
+			list = append(list, doc)
+		}
+	}
+	return countFacets(list, options)
+}
+
+// facetState is request and result of facets.
+// State is passed through context from SearchFacets to Search.
+type facetState struct {
+	options FacetOptions
+	facets  Facets
+}
+
+type facetStateKey struct{}
+
+func facetStateFrom(ctx context.Context) *facetState {
+	state, _ := ctx.Value(facetStateKey{}).(*facetState)
+	return state
+}
+
+func withFacetState(ctx context.Context, state *facetState) context.Context {
+	return context.WithValue(ctx, facetStateKey{}, state)
+}
+
+// Count facets by document manager, if it supports facets
+func (engine *advancedEngine) facets(
+	ctx context.Context,
+	hs Hypotheses,
+	options FacetOptions,
+) (Facets, error) {
+	faceter, ok := engine.docs.(Faceter)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return faceter.Facets(ctx, hs, options)
+}
+
+// SearchFacets searches results with facets.
+// Facets are counted by the whole set of hypotheses, not only by band of results.
+func (engine *advancedEngine) SearchFacets(
+	ctx context.Context,
+	query string,
+	typ string,
+	details *Details,
+	options FacetOptions,
+) (*FacetResponse, error) {
+	// Unknown fields and unsupported document manager are reported before search
+	_, err := facetFields(options)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := engine.docs.(Faceter); !ok {
+		return nil, fmt.Errorf("facets: %w", ErrUnsupported)
+	}
+
+	state := &facetState{options: options}
+	ps, err := engine.Search(withFacetState(ctx, state), query, typ, details)
+	if err != nil {
+		return nil, err
+	}
+	return &FacetResponse{
+		Parcels: ps,
+		Facets:  state.facets,
+	}, nil
+}
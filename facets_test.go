@@ -0,0 +1,83 @@
+package parcels
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocManagerFacets(t *testing.T) {
+	ctx := context.Background()
+	docs := &mapDocManager{}
+	for _, doc := range []*Doc{
+		{Id: 1, Maker: "bayer", NameGroupIndex: "аспирин", InnGroupIndex: "ацетилсалициловая кислота"},
+		{Id: 2, Maker: "bayer", NameGroupIndex: "аспирин кардио", InnGroupIndex: "ацетилсалициловая кислота"},
+		{Id: 3, Maker: "дарница", NameGroupIndex: "аспирин", InnGroupIndex: "ацетилсалициловая кислота"},
+		{Id: 4, Maker: "гедеон", NameGroupIndex: "аспаркам"},
+		{Id: 5, Maker: "bayer", NameGroupIndex: "анальгин"},
+	} {
+		require.NoError(t, docs.Append(ctx, doc))
+	}
+	// Document 5 is not matched, document 6 is removed
+	hs := Hypotheses{1: 1, 2: 0.9, 3: 0.8, 4: 0.1, 6: 0.5}
+
+	facets, err := docs.Facets(ctx, hs, FacetOptions{})
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		Facets{
+			FacetMaker: {{"bayer", 2}, {"гедеон", 1}, {"дарница", 1}},
+			FacetName:  {{"аспирин", 2}, {"аспаркам", 1}, {"аспирин кардио", 1}},
+			FacetInn:   {{"ацетилсалициловая кислота", 3}},
+		},
+		facets,
+	)
+
+	facets, err = docs.Facets(ctx, hs, FacetOptions{Fields: []string{FacetMaker}, Top: 1})
+	require.NoError(t, err)
+	assert.Equal(t, Facets{FacetMaker: {{"bayer", 2}}}, facets)
+
+	_, err = docs.Facets(ctx, hs, FacetOptions{Fields: []string{"price"}})
+	assert.Error(t, err)
+}
+
+func TestAdvancedEngineFacetsTopK(t *testing.T) {
+	ctx := context.Background()
+	repo := &parcelRepositoryMock{docs: make(map[int64]string)}
+	docs := &mapDocManager{parcels: repo}
+	options := DefaultStrategyOptions()
+	options.Ngrams.TopK = true
+	engine := newEngineMock(t, docs, options)
+	for i := 1; i <= 100; i++ {
+		name := fmt.Sprintf("аскорбинка %d", i)
+		repo.docs[int64(i)] = fmt.Sprintf(`{"name": %q}`, name)
+		doc := &Doc{Id: int64(i), NameSearchIndex: name, Maker: fmt.Sprintf("maker %d", i%2)}
+		require.NoError(t, engine.strategies.Append(ctx, doc))
+	}
+
+	// Facets count all matched documents, not only top of band
+	details := &Details{
+		Band:   BandOptions{Capacity: 5},
+		Filter: &resolver{EntityFilterEx: entityFilterMock{}},
+	}
+	res, err := engine.SearchFacets(ctx, "аскорбинка", "name", details, FacetOptions{Fields: []string{FacetMaker}})
+	require.NoError(t, err)
+	assert.Len(t, res.Parcels, 5)
+	assert.Equal(t, Facets{FacetMaker: {{"maker 0", 50}, {"maker 1", 50}}}, res.Facets)
+}
+
+func TestAdvancedEngineUnsupported(t *testing.T) {
+	engine := &advancedEngine{docs: &repositoryDocManager{}}
+
+	_, err := engine.SearchFacets(context.Background(), "query", "", &Details{}, FacetOptions{})
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrUnsupported))
+
+	_, err = engine.SearchHighlights(context.Background(), "query", "", &Details{})
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrUnsupported))
+}
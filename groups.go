@@ -0,0 +1,96 @@
+package parcels
+
+import (
+	"context"
+
+	"spWebFront/FrontKeeper/server/app/domain/model"
+)
+
+// Default count of top members per group
+const defaultGroupMembers = 3
+
+// GroupOptions is request of grouped results.
+type GroupOptions struct {
+	Members int `json:"members"` // Maximal count of top members per group. Default 3
+}
+
+// Group is group of results with the same key.
+// Key is value of group Reader of paradigm.
+type Group struct {
+	Key       string        `json:"key"`
+	Relevance float64       `json:"relevance"` // The best relevance of members
+	Count     int           `json:"count"`     // Count of matched documents, including documents out of band
+	Members   model.Parcels `json:"members"`   // Top members
+}
+
+// GroupSearcher is manager, that can search grouped results.
+type GroupSearcher interface {
+	SearchGroups(ctx context.Context, query string, typ string, details *Details, options GroupOptions) ([]*Group, error)
+}
+
+// Group sorted parcels by keys. Groups follow order of their best members.
+// Counts of groups are counts of all matched documents by keys.
+func groupParcels(ps model.Parcels, keys []string, counts map[string]int, options GroupOptions) []*Group {
+	members := options.Members
+	if members <= 0 {
+		members = defaultGroupMembers
+	}
+
+	index := make(map[string]*Group)
+	var res []*Group
+	for i, p := range ps {
+		g, ok := index[keys[i]]
+		if !ok {
+			g = &Group{
+				Key:       keys[i],
+				Relevance: p.Relevance,
+				Count:     counts[keys[i]],
+			}
+			index[keys[i]] = g
+			res = append(res, g)
+		}
+		if p.Relevance > g.Relevance {
+			g.Relevance = p.Relevance
+		}
+		if len(g.Members) < members {
+			g.Members = append(g.Members, p)
+		}
+	}
+	return res
+}
+
+// groupState is request and result of grouped search.
+// State is passed through context from SearchGroups to document manager.
+type groupState struct {
+	options GroupOptions
+	groups  []*Group
+}
+
+type groupStateKey struct{}
+
+func groupStateFrom(ctx context.Context) *groupState {
+	state, _ := ctx.Value(groupStateKey{}).(*groupState)
+	return state
+}
+
+func withGroupState(ctx context.Context, state *groupState) context.Context {
+	return context.WithValue(ctx, groupStateKey{}, state)
+}
+
+// SearchGroups searches results, grouped by group Reader of paradigm.
+// Band limits members of groups, but count of group is count of all its matched documents.
+// Document manager, which can't read groups of documents, returns no groups.
+func (engine *advancedEngine) SearchGroups(
+	ctx context.Context,
+	query string,
+	typ string,
+	details *Details,
+	options GroupOptions,
+) ([]*Group, error) {
+	state := &groupState{options: options}
+	_, err := engine.Search(withGroupState(ctx, state), query, typ, details)
+	if err != nil {
+		return nil, err
+	}
+	return state.groups, nil
+}
@@ -0,0 +1,62 @@
+package parcels
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"spWebFront/FrontKeeper/server/app/domain/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupParcels(t *testing.T) {
+	ps := model.Parcels{
+		{Relevance: 0.9},
+		{Relevance: 0.8},
+		{Relevance: 0.7},
+		{Relevance: 0.6},
+		{Relevance: 0.5},
+	}
+	counts := map[string]int{"a": 5, "b": 1, "c": 2}
+	groups := groupParcels(ps, []string{"a", "b", "a", "a", "c"}, counts, GroupOptions{Members: 2})
+	require.Len(t, groups, 3)
+
+	assert.Equal(t, "a", groups[0].Key)
+	assert.Equal(t, 0.9, groups[0].Relevance)
+	assert.Equal(t, 5, groups[0].Count)
+	assert.Equal(t, model.Parcels{ps[0], ps[2]}, groups[0].Members)
+
+	assert.Equal(t, "b", groups[1].Key)
+	assert.Equal(t, 1, groups[1].Count)
+	assert.Equal(t, "c", groups[2].Key)
+	assert.Equal(t, 2, groups[2].Count)
+}
+
+func TestDocManagerResolveGroups(t *testing.T) {
+	ctx := context.Background()
+	details := &Details{
+		Band:   BandOptions{Capacity: 9},
+		Filter: &resolver{EntityFilterEx: entityFilterMock{}},
+	}
+
+	docs, _, hs := newDocManagerMock(30, 0)
+	for id := range hs {
+		docs.find(id).NameGroupIndex = fmt.Sprintf("group %d", id%3)
+	}
+
+	state := &groupState{}
+	ps, err := docs.Resolve(withGroupState(ctx, state), hs, details, docNameGroupIndexReader)
+	require.NoError(t, err)
+	require.Len(t, ps, 9)
+
+	// Even documents 2..18 are accepted, but groups count all 10 matched documents
+	require.Len(t, state.groups, 3)
+	assert.Equal(t, "group 2", state.groups[0].Key)
+	assert.Equal(t, 10, state.groups[0].Count)
+	assert.Equal(t, hs[2], state.groups[0].Relevance)
+	assert.Equal(t, "group 1", state.groups[1].Key)
+	assert.Equal(t, "group 0", state.groups[2].Key)
+	assert.Equal(t, model.Parcels{ps[0], ps[3], ps[6]}, state.groups[0].Members)
+}
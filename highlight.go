@@ -0,0 +1,187 @@
+package parcels
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"spWebFront/FrontKeeper/server/app/domain/model"
+)
+
+// Span is range of characters [Start, End) in text.
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Highlight is text of matched field with ranges of matched ngrams.
+// Ranges are offsets of runes in text.
+type Highlight struct {
+	Text  string `json:"text"`
+	Spans []Span `json:"spans"`
+}
+
+// HighlightResponse is search results with highlights.
+// Highlights[i] are highlights of Parcels[i].
+type HighlightResponse struct {
+	Parcels    model.Parcels `json:"parcels"`
+	Highlights [][]Highlight `json:"highlights"`
+}
+
+// Highlighter is manager, that can search with highlights.
+type Highlighter interface {
+	SearchHighlights(ctx context.Context, query string, typ string, details *Details) (*HighlightResponse, error)
+}
+
+// ngramLocator is parser, that can locate ngram by its position.
+type ngramLocator interface {
+	// Get range of runes of ngram at position pos
+	locate(runes []rune, pos int16) (int, int, bool)
+}
+
+// highlightSource is field of documents, indexed by strategy.
+type highlightSource struct {
+	reader  Reader
+	mutator Mutator
+}
+
+// highlightMatch is ngram rule, searched for query.
+type highlightMatch struct {
+	rule   *ngramRule
+	query  []rune
+	source *highlightSource
+}
+
+// highlightState collects ngram rules, while resolver walks strategy, and documents of results.
+// State is passed through context from SearchHighlights to resolver and document manager.
+type highlightState struct {
+	source  *highlightSource // Source of the current strategy
+	muted   int              // Depth of mute rules: positions of muted text don't match the source
+	matches []*highlightMatch
+	visited map[resolverKey]bool
+	docs    []*Doc // Documents of results
+}
+
+type highlightStateKey struct{}
+
+func highlightStateFrom(ctx context.Context) *highlightState {
+	state, _ := ctx.Value(highlightStateKey{}).(*highlightState)
+	return state
+}
+
+func withHighlightState(ctx context.Context, state *highlightState) context.Context {
+	return context.WithValue(ctx, highlightStateKey{}, state)
+}
+
+// Remember ngram rule, searched for query
+func (state *highlightState) visit(rule Rule, query []rune) {
+	r, ok := rule.(*ngramRule)
+	if !ok || state.muted != 0 || state.source == nil {
+		return
+	}
+	key := resolverKey{rule: r, query: string(query)}
+	if state.visited[key] {
+		return
+	}
+	state.visited[key] = true
+	state.matches = append(
+		state.matches,
+		&highlightMatch{
+			rule:   r,
+			query:  query,
+			source: state.source,
+		},
+	)
+}
+
+// Get highlights of document by matched ngrams
+func (state *highlightState) highlight(ctx context.Context, doc *Doc) []Highlight {
+	var res []Highlight
+	index := make(map[string]int)
+	for _, m := range state.matches {
+		parser, _ := m.rule.current()
+		locator, ok := parser.(ngramLocator)
+		if !ok {
+			continue
+		}
+		text := m.source.reader(doc)
+		runes := m.source.mutator.Mute(ctx, []rune(text))
+
+		var spans []Span
+		for _, ngram := range m.rule.explain(ctx, m.query, doc.Id) {
+			if start, end, ok := locator.locate(runes, ngram.DocPos); ok {
+				spans = append(spans, Span{Start: start, End: end})
+			}
+		}
+		if len(spans) == 0 {
+			continue
+		}
+
+		i, ok := index[text]
+		if !ok {
+			i = len(res)
+			index[text] = i
+			res = append(res, Highlight{Text: text})
+		}
+		res[i].Spans = append(res[i].Spans, spans...)
+	}
+
+	for i := range res {
+		res[i].Spans = mergeSpans(res[i].Spans)
+	}
+	return res
+}
+
+// Merge overlapping and adjacent spans
+func mergeSpans(spans []Span) []Span {
+	if len(spans) == 0 {
+		return spans
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+	res := spans[:1]
+	for _, span := range spans[1:] {
+		last := &res[len(res)-1]
+		if span.Start <= last.End {
+			if span.End > last.End {
+				last.End = span.End
+			}
+			continue
+		}
+		res = append(res, span)
+	}
+	return res
+}
+
+// SearchHighlights searches results with ranges of matched ngrams.
+// Ngrams of mute rules (metaphones) are not highlighted, because their positions refer to muted text.
+func (engine *advancedEngine) SearchHighlights(
+	ctx context.Context,
+	query string,
+	typ string,
+	details *Details,
+) (*HighlightResponse, error) {
+	// Documents of results are known only by in-memory document manager
+	if _, ok := engine.docs.(*mapDocManager); !ok {
+		return nil, fmt.Errorf("highlights: %w", ErrUnsupported)
+	}
+
+	state := &highlightState{
+		visited: make(map[resolverKey]bool),
+	}
+	ps, err := engine.Search(withHighlightState(ctx, state), query, typ, details)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &HighlightResponse{
+		Parcels:    ps,
+		Highlights: make([][]Highlight, len(ps)),
+	}
+	// Positions of ngrams are read from indexes
+	engine.RLock()
+	defer engine.RUnlock()
+	for i, doc := range state.docs {
+		res.Highlights[i] = state.highlight(ctx, doc)
+	}
+	return res, nil
+}
@@ -0,0 +1,107 @@
+package parcels
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNgramParserLocate(t *testing.T) {
+	ctx := context.Background()
+	text := []rune("аспирин, кардио  100мг")
+
+	primary := NewNgramParserPrimary(3, NewParserEstimatorPrimary(0.5))
+	ngrams, err := primary.Parse(ctx, text, true)
+	require.NoError(t, err)
+	require.NotEmpty(t, ngrams)
+	for _, ngram := range ngrams {
+		start, end, ok := primary.(ngramLocator).locate(text, ngram.Pos)
+		require.True(t, ok, ngram.Text)
+		assert.Equal(t, ngram.Text, string(text[start:end]))
+	}
+
+	secondary := NewNgramParserSecondary(4, NewParserEstimatorSecondary())
+	ngrams, err = secondary.Parse(ctx, text, true)
+	require.NoError(t, err)
+	require.NotEmpty(t, ngrams)
+	for _, ngram := range ngrams {
+		start, end, ok := secondary.(ngramLocator).locate(text, ngram.Pos)
+		require.True(t, ok, ngram.Text)
+		// Ngram is located with spaces inside
+		assert.Equal(t, ngram.Text, strings.ReplaceAll(string(text[start:end]), " ", ""))
+	}
+
+	_, _, ok := primary.(ngramLocator).locate(text, 100)
+	assert.False(t, ok)
+	_, _, ok = secondary.(ngramLocator).locate(text, 100)
+	assert.False(t, ok)
+}
+
+func TestMergeSpans(t *testing.T) {
+	assert.Empty(t, mergeSpans(nil))
+	assert.Equal(
+		t,
+		[]Span{{0, 5}, {6, 9}},
+		mergeSpans([]Span{{6, 9}, {2, 5}, {0, 3}, {1, 2}, {7, 8}}),
+	)
+	// Adjacent spans are merged
+	assert.Equal(t, []Span{{0, 6}}, mergeSpans([]Span{{3, 6}, {0, 3}}))
+}
+
+func TestHighlightState(t *testing.T) {
+	ctx := context.Background()
+	spec, err := ParseStrategySpec([]byte(`{
+		"root": "root",
+		"nodes": {
+			"root": {"kind": "multi", "mixer": "max", "entries": [
+				{"node": "ngram.3", "weight": 1},
+				{"node": "ru", "weight": 1}
+			]},
+			"ngram.3": {"kind": "ngram", "length": 3},
+			"ru": {"kind": "mute", "mutator": "ru", "rule": "ru.ngram.3"},
+			"ru.ngram.3": {"kind": "ngram", "length": 3}
+		}
+	}`))
+	require.NoError(t, err)
+	st, err := NewStrategyFromSpec(nil, nil, spec, docNameSearchIndexReader)
+	require.NoError(t, err)
+
+	docs := []*Doc{
+		{Id: 1, NameSearchIndex: "аспирин кардио"},
+		{Id: 2, NameSearchIndex: "кардиомагнил"},
+		{Id: 3, NameSearchIndex: "анальгин"},
+	}
+	for _, doc := range docs {
+		require.NoError(t, st.Append(ctx, doc))
+	}
+
+	state := &highlightState{visited: make(map[resolverKey]bool)}
+	ctx = withHighlightState(ctx, state)
+	state.source = &highlightSource{
+		reader:  docNameSearchIndexReader,
+		mutator: st.(*strategy).Mutator,
+	}
+	res := &resolver{cache: make(map[resolverKey]Hypotheses)}
+	hs := res.Resolve(ctx, st.(*strategy).Rule, []rune("кардио"), 1, &Details{})
+	require.True(t, hs[1] > 0)
+	require.True(t, hs[2] > 0)
+
+	// Ngrams of metaphones are not highlighted
+	require.Len(t, state.matches, 1)
+	assert.Equal(t, 0, state.muted)
+
+	assert.Equal(
+		t,
+		[]Highlight{{Text: "аспирин кардио", Spans: []Span{{8, 14}}}},
+		state.highlight(ctx, docs[0]),
+	)
+	assert.Equal(
+		t,
+		[]Highlight{{Text: "кардиомагнил", Spans: []Span{{0, 6}}}},
+		state.highlight(ctx, docs[1]),
+	)
+	assert.Empty(t, state.highlight(ctx, docs[2]))
+}
@@ -5,6 +5,7 @@ import "sort"
 type Ref struct {
 	Doc    int64   // Document identifier
 	Pos    int16   // Position index
+	Len    int16   // Count of distinct ngrams in document. Packed with Pos, so Ref takes 24 bytes
 	Weight float64 // Weight of the ngram
 }
 
@@ -23,7 +24,8 @@ func refsContains(rs []Ref, r Ref) bool {
 	return rs[i] == r
 }
 
-// Append id to the list
+// Append id to the list.
+// Source list is never modified, because it can be shared with readers.
 func refsInclude(rs []Ref, r Ref) []Ref {
 	l := len(rs)
 	if l == 0 {
@@ -32,7 +34,7 @@ func refsInclude(rs []Ref, r Ref) []Ref {
 
 	i := sort.Search(l, func(i int) bool { return rs[i].Doc >= r.Doc })
 	if i == l {
-		return append(rs, r)
+		return append(rs[:l:l], r)
 	}
 
 	if rs[i].Doc == r.Doc {
@@ -48,6 +50,8 @@ func refsInclude(rs []Ref, r Ref) []Ref {
 	return lst
 }
 
+// Remove id from the list.
+// Source list is never modified, because it can be shared with readers.
 func refsExclude(rs []Ref, id int64) []Ref {
 	l := len(rs)
 	if l == 0 {
@@ -63,7 +67,9 @@ func refsExclude(rs []Ref, id int64) []Ref {
 		if l == 1 {
 			return nil
 		}
-		return append(rs[:i], rs[i+1:]...)
+		lst := make([]Ref, 0, l-1)
+		lst = append(lst, rs[:i]...)
+		return append(lst, rs[i+1:]...)
 	}
 
 	return rs
@@ -1,9 +1,11 @@
 package parcels
 
 import (
+	"bytes"
 	"context"
 	"encoding/gob"
 	"fmt"
+	"math"
 	"sort"
 	"spWebFront/FrontKeeper/infrastructure/core"
 	"spWebFront/FrontKeeper/infrastructure/log"
@@ -27,13 +29,17 @@ type Strategy interface {
 	// Purge index
 	Purge(ctx context.Context) error
 	// Replace document (or append)
-	Append(ctx context.Context, doc *Doc)
+	Append(ctx context.Context, doc *Doc) error
 	// Remove existing document
 	Remove(ctx context.Context, id int64)
-	// Load data from reader
-	//Load(r io.Reader) error
-	// Save data to writer
-	//Save(w io.Writer) error
+	// Load data from decoder
+	Load(dec *gob.Decoder) error
+	// Save data to encoder
+	Save(enc *gob.Encoder) error
+	// Begin batch build of the index
+	BeginBuild(ctx context.Context)
+	// Commit batch build of the index
+	Commit(ctx context.Context) error
 }
 
 // Rule is single rule of search strategy.
@@ -44,15 +50,30 @@ type Rule interface {
 	// Purge all data
 	Purge(ctx context.Context) error
 	// Append new document
-	Append(ctx context.Context, id int64, name []rune, weight float64)
+	Append(ctx context.Context, id int64, name []rune, weight float64) error
 	// Remove old document
 	Remove(ctx context.Context, id int64)
 	// Make hypotheses
 	Search(ctx context.Context, resolver Resolver, query []rune, weight float64, details *Details) Hypotheses
+	// Get nested rules
+	Children() []Rule
+	// Begin batch build. Documents, appended after this call, become visible after commit.
+	BeginBuild(ctx context.Context)
+	// Commit batch build
+	Commit(ctx context.Context) error
 	// Log info
 	Log()
 }
 
+// PersistentRule is rule, that holds own data and can be saved into snapshot.
+type PersistentRule interface {
+	Rule
+	// Load data from decoder
+	Load(dec *gob.Decoder) error
+	// Save data to encoder
+	Save(enc *gob.Encoder) error
+}
+
 // Entry for single rule
 type Entry struct {
 	Rule           // Rule
@@ -116,24 +137,52 @@ type Predicate interface {
 	Test(ctx context.Context, rs []rune) bool
 }
 
+// Strategies is set of search strategies over the shared document manager.
+// Documents are appended before indexing and removed after unindexing,
+// so concurrent searches never get hypotheses for unknown documents.
 type Strategies struct {
 	sync.RWMutex
 	docs   DocManager
 	Names  Strategy
 	Inns   Strategy
 	Makers Strategy
+	// Prefix tree of group names, built alongside strategies
+	suggests suggestIndex
+	// Vocabulary of document names for spelling corrections
+	spells spellIndex
+}
+
+// Strategy with its name for error messages
+type namedStrategy struct {
+	name string
+	Strategy
+}
+
+// Get all strategies in order of loading
+func (strategies *Strategies) all() []namedStrategy {
+	return []namedStrategy{
+		{"Names", strategies.Names},
+		{"Inns", strategies.Inns},
+		{"Makers", strategies.Makers},
+	}
 }
 
 func (strategies *Strategies) Purge(ctx context.Context) error {
-	err := strategies.Names.Purge(ctx)
-	if err != nil {
-		return fmt.Errorf("Purge: %w", err)
+	for _, strategy := range strategies.all() {
+		err := strategy.Purge(ctx)
+		if err != nil {
+			return fmt.Errorf("%s.Purge: %w", strategy.name, err)
+		}
 	}
+	strategies.suggests.purge()
+	strategies.spells.purge()
 	return strategies.docs.Purge(ctx)
 }
 
 func (strategies *Strategies) Log(ctx context.Context) error {
-	strategies.Names.Log(ctx)
+	for _, strategy := range strategies.all() {
+		strategy.Log(ctx)
+	}
 	return nil
 }
 
@@ -142,15 +191,44 @@ func (strategies *Strategies) Append(ctx context.Context, doc *Doc) error {
 	if err != nil {
 		return fmt.Errorf("Append: %w", err)
 	}
-	strategies.Names.Append(ctx, doc)
+	for _, strategy := range strategies.all() {
+		err = strategy.Append(ctx, doc)
+		if err != nil {
+			return fmt.Errorf("%s.Append: %w", strategy.name, err)
+		}
+	}
+	strategies.suggests.append(doc)
+	strategies.spells.append(doc)
 	return nil
 }
 
 func (strategies *Strategies) Remove(ctx context.Context, id int64) error {
-	strategies.Names.Remove(ctx, id)
+	for _, strategy := range strategies.all() {
+		strategy.Remove(ctx, id)
+	}
+	strategies.suggests.delete(id)
+	strategies.spells.delete(id)
 	return strategies.docs.Remove(ctx, id)
 }
 
+// BeginBuild switches strategies into batch build mode, used for loading complete set of documents.
+func (strategies *Strategies) BeginBuild(ctx context.Context) {
+	for _, strategy := range strategies.all() {
+		strategy.BeginBuild(ctx)
+	}
+}
+
+// Commit publishes all documents, appended since BeginBuild.
+func (strategies *Strategies) Commit(ctx context.Context) error {
+	for _, strategy := range strategies.all() {
+		err := strategy.Commit(ctx)
+		if err != nil {
+			return fmt.Errorf("%s.Commit: %w", strategy.name, err)
+		}
+	}
+	return nil
+}
+
 func (strategies *Strategies) getNames() Strategy {
 	strategies.RLock()
 	p := strategies.Names
@@ -163,6 +241,7 @@ type advancedEngine struct {
 	baseEngine
 	strategies *Strategies
 	docs       DocManager
+	binary     BinaryStorage
 }
 
 func (engine *advancedEngine) Search(
@@ -175,24 +254,35 @@ func (engine *advancedEngine) Search(
 		details = new(Details)
 		engine.InitDetails(details)
 	}
+	engine.initDetails(details)
 
-	if details.Exact == 0 {
-		details.Exact = 0.5
-	}
+	engine.RLock()
+	defer engine.RUnlock()
 
-	if details.Band.Capacity == 0 {
-		details.Band.Capacity = 100
+	state := topKStateFrom(ctx)
+	if state == nil {
+		state = &topKState{}
+		ctx = withTopKState(ctx, state)
 	}
-
-	if details.Weights == nil {
-		details.Weights = defaultWeights
+	res, err = engine.searchParcels(ctx, query, typ, details)
+	if err != nil || len(res) >= details.Band.Capacity || !state.truncated || !state.rejected || state.full {
+		return res, err
 	}
 
-	engine.RLock()
-	defer engine.RUnlock()
+	// Entity filter emptied candidates of band, though truncated top had more documents
+	state.full = true
+	return engine.searchParcels(ctx, query, typ, details)
+}
 
+// Search parcels under read lock of engine
+func (engine *advancedEngine) searchParcels(
+	ctx context.Context,
+	query string,
+	typ string,
+	details *Details,
+) (res model.Parcels, err error) {
 	query = strings.TrimSpace(strings.ToLower(query))
-	hs := make(Hypotheses)
+	var hs Hypotheses
 	paradigm := getParadigm(typ)
 	if len(paradigm.types) == 0 {
 		hs, err = engine.search(ctx, paradigm.method, query, details)
@@ -200,6 +290,7 @@ func (engine *advancedEngine) Search(
 			return nil, fmt.Errorf("search (%s): %w", typ, err)
 		}
 	} else {
+		list := make([]Hypotheses, 0, len(paradigm.types))
 		for _, typ := range paradigm.types {
 			p := getParadigm(typ)
 
@@ -208,12 +299,21 @@ func (engine *advancedEngine) Search(
 				return nil, fmt.Errorf("search (%s): %w", typ, err)
 			}
 
-			hs.extends(hss)
+			list = append(list, hss)
 
 			if details.IsCancel() {
 				return nil, nil
 			}
 		}
+		hs = paradigm.mix(list)
+	}
+
+	// Facets are counted by all hypotheses, before band cutoff
+	if state := facetStateFrom(ctx); state != nil {
+		state.facets, err = engine.facets(ctx, hs, state.options)
+		if err != nil {
+			return nil, fmt.Errorf("facets: %w", err)
+		}
 	}
 
 	ps, err := engine.docs.Resolve(ctx, hs, details, paradigm.group)
@@ -227,6 +327,21 @@ func (engine *advancedEngine) Search(
 	return ps, nil
 }
 
+// Set default values of search details
+func (engine *advancedEngine) initDetails(details *Details) {
+	if details.Exact == 0 {
+		details.Exact = 0.5
+	}
+
+	if details.Band.Capacity == 0 {
+		details.Band.Capacity = 100
+	}
+
+	if details.Weights == nil {
+		details.Weights = defaultWeights
+	}
+}
+
 func (engine *advancedEngine) search(
 	ctx context.Context,
 	method method,
@@ -256,22 +371,24 @@ func (engine *advancedEngine) search(
 	return
 }
 
-/*
-func (engine *engine) Load(ctx context.Context) error {
+// Load is restoring snapshot of search index from the storage.
+// Returns false, if snapshot is absent or incompatible with current options.
+func (engine *advancedEngine) Load(ctx context.Context) (bool, error) {
 	engine.Lock()
 	defer engine.Unlock()
 
-	err := engine.load(ctx)
+	ok, err := engine.load(ctx)
 	if err != nil {
-		return fmt.Errorf("load: %w", err)
+		return false, fmt.Errorf("load: %w", err)
 	}
 
-	return nil
+	return ok, nil
 }
 
-func (engine *engine) Save(ctx context.Context) error {
-	engine.Lock()
-	defer engine.Unlock()
+// Save is storing snapshot of search index into the storage.
+func (engine *advancedEngine) Save(ctx context.Context) error {
+	engine.RLock()
+	defer engine.RUnlock()
 
 	err := engine.save(ctx)
 	if err != nil {
@@ -280,141 +397,88 @@ func (engine *engine) Save(ctx context.Context) error {
 
 	return nil
 }
-*/
 
-/*
-func (engine *engine) save(
+func (engine *advancedEngine) save(
 	ctx context.Context,
 ) error {
-	// Save documents
-	{
-		var documents bytes.Buffer
-		if err := engine.docs.Save(&documents); err != nil {
-			return fmt.Errorf("save documents: %w", err)
-		}
-		if err := engine.binary.Put(ctx, namespace, keyDocuments, documents.Bytes()); err != nil {
-			return fmt.Errorf("Put documents: %w", err)
-		}
-	}
-
-	// Save name index
-	{
-		var names bytes.Buffer
-		if err := engine.names.Save(&names); err != nil {
-			return fmt.Errorf("save names: %w", err)
-		}
-		if err := engine.binary.Put(ctx, namespace, keyNameIndex, names.Bytes()); err != nil {
-			return fmt.Errorf("Put names", err)
-		}
-	}
-
-	return nil
-}
-
-func (engine *engine) load(
-	ctx context.Context,
-) error {
-	var names Strategy
-
-	dataDocuments, err := engine.binary.Get(ctx, namespace, keyDocuments)
-	if err != nil {
-		return fmt.Errorf("Get documents: %w", err)
-	}
-	if dataDocuments == nil {
+	if engine.binary == nil {
 		return nil
 	}
 
-	dataNameIndex, err := engine.binary.Get(ctx, namespace, keyNameIndex)
+	header, err := newSnapshotHeader(&engine.options.Search)
 	if err != nil {
-		return fmt.Errorf("Get names: %w", err)
-	}
-	if dataNameIndex == nil {
-		return nil
+		return fmt.Errorf("newSnapshotHeader: %w", err)
 	}
 
-	// Load name index
-	names, err = engine.factory.New()
+	var data bytes.Buffer
+	enc := gob.NewEncoder(&data)
+	err = enc.Encode(header)
 	if err != nil {
-		return fmt.Errorf("create names: %w", err)
+		return fmt.Errorf("Encode header: %w", err)
 	}
 
-	err = names.Load(bytes.NewBuffer(dataNameIndex))
+	err = engine.strategies.Save(enc)
 	if err != nil {
-		return fmt.Errorf("load name index: %w", err)
+		return fmt.Errorf("save strategies: %w", err)
 	}
 
-	// Load documents
-	err = engine.docs.Load(bytes.NewBuffer(dataDocuments))
+	err = engine.binary.Put(ctx, namespace, keyNameIndex, data.Bytes())
 	if err != nil {
-		return fmt.Errorf("decode documents: %w", err)
+		return fmt.Errorf("Put names: %w", err)
 	}
 
-	engine.names = names
-
 	return nil
 }
 
-func (engine *engine) Migrate(
+func (engine *advancedEngine) load(
 	ctx context.Context,
-	factory StrategyFactory,
-) error {
-	engine.Lock()
-	defer engine.Unlock()
-
-	if engine.factory == factory {
-		return nil
+) (bool, error) {
+	if engine.binary == nil {
+		return false, nil
 	}
-	// ctx, err := engine.newContext()
-	// if err != nil {
-	// 	return err
-	// }
-
-	return engine.db.Update(
-		func(tx bolt.Tx) error {
-			names, err := factory.New()
-			if err != nil {
-				return fmt.Errorf("create names", err)
-			}
-
-			access := engine.documents.Capture()
-			defer access.Release()
 
-			src := access.Items()
-			dst := document.New()
-			for _, doc := range src {
-				id := dst.Append(doc)
-				names.Append(ctx, id, doc.NameSearchIndex)
-			}
-
-			b, err := tx.CreateBucketIfNotExists(bucketNameBinary)
-			if err != nil {
-				return fmt.Errorf("create bucket %q: %w", string(bucketNameBinary), err)
-			}
+	data, err := engine.binary.Get(ctx, namespace, keyNameIndex)
+	if err != nil {
+		return false, fmt.Errorf("Get names: %w", err)
+	}
+	if data == nil {
+		return false, nil
+	}
 
-			// Update name index
-			err = b.Delete(keyNameIndex)
-			if err != nil {
-				return fmt.Errorf("remove name index: %w", err)
-			}
+	expected, err := newSnapshotHeader(&engine.options.Search)
+	if err != nil {
+		return false, fmt.Errorf("newSnapshotHeader: %w", err)
+	}
 
-			var dataName bytes.Buffer
-			err = names.Save(&dataName)
-			if err != nil {
-				return fmt.Errorf("save names: %w", err)
-			}
+	dec := gob.NewDecoder(bytes.NewBuffer(data))
+	var actual snapshotHeader
+	err = dec.Decode(&actual)
+	if err != nil {
+		return false, fmt.Errorf("Decode header: %w", err)
+	}
+	if !actual.isCompatible(expected) {
+		log.Printf(
+			"SNAPSHOT IS OUTDATED: version=%d/%d, hash=%s/%s",
+			actual.Version,
+			expected.Version,
+			actual.Hash,
+			expected.Hash,
+		)
+		return false, nil
+	}
 
-			err = b.Put(keyNameIndex, dataName.Bytes())
-			if err != nil {
-				return fmt.Errorf("put name index: %w", err)
-			}
+	err = engine.strategies.Load(dec)
+	if err != nil {
+		return false, fmt.Errorf("load strategies: %w", err)
+	}
 
-			engine.documents = dst
-			engine.names = names
+	return true, nil
+}
 
-			return nil
-		},
-	)
-}*/
+// Periodic persistence of the search index
+func (engine *advancedEngine) snapshot(ctx context.Context) error {
+	return engine.Save(ctx)
+}
 
 func (engine *advancedEngine) FindByParcelCode(
 	ctx context.Context,
@@ -473,20 +537,25 @@ func NewAdvancedManager(
 		docs:       docs,
 	}
 
-	// initialize engine
-	raw, err := parcels.FindAllRaw(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("Find parcels: %w", err)
+	if binary, ok := storage.(BinaryStorage); ok {
+		e.binary = binary
 	}
 
-	defs, err := makeDocDefs(raw)
+	// initialize engine
+	loaded, err := e.Load(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("makeDocDefs: %w", err)
+		log.Printf("SNAPSHOT IS BROKEN: %v", err)
+		err = strategies.Purge(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Purge: %w", err)
+		}
 	}
 
-	err = e.Refresh(ctx, defs)
-	if err != nil {
-		return nil, fmt.Errorf("Load parcels: %w", err)
+	if !loaded {
+		err = e.rebuild(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("rebuild: %w", err)
+		}
 	}
 
 	e.initialized = true
@@ -503,9 +572,49 @@ func NewAdvancedManager(
 		core.NewErrorHandler(ctx, core.ErrorStocks, "parcels.updater"),
 	)
 
+	if e.binary != nil {
+		threads.Periodic(
+			ctx,
+			thread.Identity{Name: ManagerEntityId + ".snapshot"},
+			e.snapshot,
+			snapshotInterval,
+			core.NewErrorHandler(ctx, core.ErrorStocks, "parcels.snapshot"),
+		)
+	}
+
 	return e, nil
 }
 
+// Full rebuild of the search index from the repository.
+func (engine *advancedEngine) rebuild(ctx context.Context) error {
+	raw, err := engine.parcels.FindAllRaw(ctx)
+	if err != nil {
+		return fmt.Errorf("Find parcels: %w", err)
+	}
+
+	defs, err := makeDocDefs(raw)
+	if err != nil {
+		return fmt.Errorf("makeDocDefs: %w", err)
+	}
+
+	engine.strategies.BeginBuild(ctx)
+	err = engine.Refresh(ctx, defs)
+	errCommit := engine.strategies.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("Load parcels: %w", err)
+	}
+	if errCommit != nil {
+		return fmt.Errorf("Commit: %w", errCommit)
+	}
+
+	err = engine.Save(ctx)
+	if err != nil {
+		return fmt.Errorf("Save: %w", err)
+	}
+
+	return nil
+}
+
 func makeDocDefs(parcels []*model.Raw) (res []*DocDef, err error) {
 	res = make([]*DocDef, 0, len(parcels))
 	for _, p := range parcels {
@@ -537,6 +646,41 @@ type paradigm struct {
 	types  []string
 	group  Reader
 	method method
+	mixer  Mixer // Mixer of results of types. Results are united, if nil
+}
+
+// Mixers of complex paradigms, chosen by word of paradigm type, e.g. "name inn intersect"
+var paradigmMixers = map[string]func() Mixer{
+	"intersect": NewIntersectMixer,
+	"wmin":      NewWeightedMinMixer,
+}
+
+// Mix results of types of paradigm
+func (p *paradigm) mix(list []Hypotheses) Hypotheses {
+	if p.mixer == nil {
+		hs := newHypotheses()
+		for _, hss := range list {
+			hs.extends(hss)
+		}
+		return hs
+	}
+
+	return p.mixer.Mix(p.branches(list))
+}
+
+// Make branches of mixer by hypotheses of types
+func (p *paradigm) branches(list []Hypotheses) []branch {
+	estimator := NewMaxEstimator()
+	bs := make([]branch, len(list))
+	for i, hss := range list {
+		bs[i] = branch{
+			hypotheses: hss,
+			relevance:  estimator.Estimate(hss),
+			name:       p.types[i],
+			weight:     1,
+		}
+	}
+	return bs
 }
 
 var paradigms = map[string]paradigm{
@@ -568,6 +712,11 @@ var paradigms = map[string]paradigm{
 		group:  docNameGroupIndexReader,
 		method: doMultiSearch,
 	},
+	// Structured query with field prefixes and exclusions
+	"query": {
+		group:  docNameGroupIndexReader,
+		method: doQuerySearch,
+	},
 	"default": {
 		group:  docNameGroupIndexReader,
 		method: doMultiSearch,
@@ -589,6 +738,9 @@ func getParadigm(typ string) paradigm {
 		if _, ok := paradigms[s]; ok {
 			res.types = append(res.types, s)
 		}
+		if newMixer, ok := paradigmMixers[s]; ok {
+			res.mixer = newMixer()
+		}
 	}
 	if len(res.types) != 0 {
 		return res
@@ -714,12 +866,16 @@ func makeHypotheses(
 
 // Mixer is abstract interface for mix set of hypotheses.
 type branch struct {
-	hypotheses Hypotheses
+	hypotheses Hypotheses // Hypotheses, scaled by weight
 	relevance  float64
+	name       string  // Name of entry
+	weight     float64 // Weight of entry
 }
 
 type Mixer interface {
 	Mix(branches []branch) Hypotheses
+	// Explain decision of Mix about document: branches, which make its relevance, and description
+	Explain(branches []branch, doc int64) ([]bool, string)
 }
 
 // Estimator is abstract interface for estimate multiple hypotheses/
@@ -740,6 +896,52 @@ func (hs Hypotheses) extends(hss Hypotheses) {
 	}
 }
 
+// Intersect hypotheses with new hypotheses. Relevance is sum of relevances, like in intersect mixer.
+func (hs Hypotheses) intersect(hss Hypotheses) Hypotheses {
+	a, b := hs, hss
+	if len(b) < len(a) {
+		a, b = b, a
+	}
+	rs := make(Hypotheses, len(a))
+	for id, v1 := range a {
+		if v2, ok := b[id]; ok {
+			rs[id] = v1 + v2
+		}
+	}
+	return rs
+}
+
+// Documents are excluded by difference mixer and negative terms of query,
+// if their relevance is not less than this share of the best relevance of excluded hypotheses.
+const excludeRatio = 0.75
+
+// Exclude hypotheses, which strongly match excluded hypotheses.
+// Match is strong, if relevance is not less than excludeRatio of the best relevance of excluded hypotheses
+// and not less than floor, so weak fuzzy matches don't exclude documents,
+// even if all matches of excluded hypotheses are weak.
+func (hs Hypotheses) exclude(hss Hypotheses, floor float64) Hypotheses {
+	limit := hss.exclusion(floor)
+	rs := make(Hypotheses, len(hs))
+	for id, v := range hs {
+		if x, ok := hss[id]; ok && x >= limit {
+			continue
+		}
+		rs[id] = v
+	}
+	return rs
+}
+
+// Get the least relevance of hypotheses, which excludes document
+func (hs Hypotheses) exclusion(floor float64) float64 {
+	var best float64
+	for _, v := range hs {
+		if v > best {
+			best = v
+		}
+	}
+	return math.Max(best*excludeRatio, floor)
+}
+
 // Scale set of hypotheses
 func (hs Hypotheses) scale(ratio float64) Hypotheses {
 	rs := make(Hypotheses, len(hs))
@@ -907,11 +1109,29 @@ func init() {
 type Resolver interface {
 	Close(ctx context.Context)
 	Resolve(ctx context.Context, ruley Rule, query []rune, weight float64, details *Details) Hypotheses
+	// Get statistics of memoization cache
+	Statistics() ResolverStatistics
+}
+
+// ResolverStatistics is statistics of resolver cache
+type ResolverStatistics struct {
+	Hits   int // Count of searches, taken from cache
+	Misses int // Count of executed searches
+}
+
+// resolverKey is key of memoized search
+type resolverKey struct {
+	rule   Rule
+	query  string
+	weight float64
 }
 
-// Implementation of resolver
+// Implementation of resolver.
+// Every rule is searched once per query, so shared rules of strategy DAG are cheap.
+// Cached hypotheses are shared between parent rules and must not be modified.
 type resolver struct {
-	// cache   map[Rule]map[string]Hypotheses
+	cache   map[resolverKey]Hypotheses
+	stats   ResolverStatistics
 	manager Manager
 	model.EntityFilterEx
 	docs map[int64]*Doc
@@ -923,6 +1143,10 @@ func (res *resolver) Close(
 	res.EntityFilterEx.Release(ctx)
 }
 
+func (res *resolver) Statistics() ResolverStatistics {
+	return res.stats
+}
+
 func (res *resolver) Acquire(
 	ctx context.Context,
 ) (model.EntityFilterEx, error) {
@@ -1008,18 +1232,45 @@ func (res *resolver) resolve(
 		return nil
 	}
 
-	// if qs, ok := res.cache[rule]; ok {
-	// 	if hs, ok := qs[string(query)]; ok {
-	// 		return hs
-	// 	}
-	// }
+	// Entries of different rules share the same rule. Weight of entry is applied by parent.
+	scale := float64(1)
+	if e, ok := rule.(*Entry); ok {
+		scale = details.getWeight(e.Name(), e.Weight)
+		rule = e.Rule
+	}
+
+	if state := highlightStateFrom(ctx); state != nil {
+		state.visit(rule, query)
+		if _, ok := rule.(*muteRule); ok {
+			state.muted++
+			defer func() { state.muted-- }()
+		}
+	}
+
+	// Explanation shows every branch, so cache is not used
+	if trace := explainTraceFrom(ctx); trace != nil {
+		trace.enter(rule, query, weight, scale)
+		hs := rule.Search(ctx, res, query, weight, details)
+		trace.leave(ctx, rule, hs)
+		return hs
+	}
+
+	key := resolverKey{
+		rule:   rule,
+		query:  string(query),
+		weight: weight,
+	}
+	if hs, ok := res.cache[key]; ok {
+		res.stats.Hits++
+		return hs
+	}
 
+	res.stats.Misses++
 	hs := rule.Search(ctx, res, query, weight, details)
-	// if qs, ok := res.cache[rule]; ok {
-	// 	qs[string(query)] = hs
-	// } else {
-	// 	res.cache[rule] = map[string]Hypotheses{string(query): hs}
-	// }
+	// Result of cancelled search is incomplete
+	if !details.IsCancel() {
+		res.cache[key] = hs
+	}
 
 	return hs
 }
@@ -1045,18 +1296,35 @@ type maxMixer struct {
 }
 
 func (mixer *maxMixer) Mix(branches []branch) Hypotheses {
-	var best branch
-	for _, b := range branches {
-		if b.relevance > best.relevance {
-			best = b
-		}
+	i := mixer.best(branches)
+	if i == -1 || branches[i].hypotheses == nil {
+		return newHypotheses()
 	}
 
-	if best.hypotheses == nil {
-		return newHypotheses()
+	return branches[i].hypotheses
+}
+
+// Get index of the first branch with the best positive estimate, -1 if there is no such branch
+func (mixer *maxMixer) best(branches []branch) int {
+	best := -1
+	var relevance float64
+	for i, b := range branches {
+		if b.relevance > relevance {
+			best = i
+			relevance = b.relevance
+		}
 	}
+	return best
+}
 
-	return best.hypotheses
+func (mixer *maxMixer) Explain(branches []branch, doc int64) ([]bool, string) {
+	selected := make([]bool, len(branches))
+	i := mixer.best(branches)
+	if i == -1 {
+		return selected, "max: no branches"
+	}
+	selected[i] = true
+	return selected, fmt.Sprintf("max: branch %q with estimate %g", branches[i].name, branches[i].relevance)
 }
 
 func NewMaxMixer() Mixer {
@@ -1081,6 +1349,10 @@ func (mixer *sumMixer) Mix(branches []branch) Hypotheses {
 	return res
 }
 
+func (mixer *sumMixer) Explain(branches []branch, doc int64) ([]bool, string) {
+	return explainFound(mixerName(mixer), branches, doc)
+}
+
 func NewSumMixer() Mixer {
 	return &sumMixer{}
 }
@@ -1096,7 +1368,7 @@ func newResolver(
 	}
 
 	return &resolver{
-		// cache:   make(map[Rule]map[string]Hypotheses, 1024),
+		cache:          make(map[resolverKey]Hypotheses, 1024),
 		manager:        manager,
 		EntityFilterEx: f,
 	}, nil
@@ -0,0 +1,342 @@
+package parcels
+
+import (
+	"encoding/gob"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Get relevance of branch without weight
+func (b *branch) unscaled(v float64) float64 {
+	if b.weight <= 0 {
+		return v
+	}
+	return v / b.weight
+}
+
+// Explain mixer, which combines every branch with document
+func explainFound(name string, branches []branch, doc int64) ([]bool, string) {
+	selected := make([]bool, len(branches))
+	var names []string
+	for i, b := range branches {
+		if _, ok := b.hypotheses[doc]; ok {
+			selected[i] = true
+			names = append(names, b.name)
+		}
+	}
+	return selected, fmt.Sprintf("%s: branches %s", name, strings.Join(names, ", "))
+}
+
+type intersectMixer struct {
+}
+
+// Mix keeps documents, found by every branch.
+// Relevance is sum of weighted relevances, like in sumMixer.
+func (mixer *intersectMixer) Mix(branches []branch) Hypotheses {
+	if len(branches) == 0 {
+		return newHypotheses()
+	}
+	res := make(Hypotheses, len(branches[0].hypotheses))
+	res.extends(branches[0].hypotheses)
+	for _, b := range branches[1:] {
+		res = res.intersect(b.hypotheses)
+	}
+	return res
+}
+
+func (mixer *intersectMixer) Explain(branches []branch, doc int64) ([]bool, string) {
+	selected, _ := explainFound(mixerName(mixer), branches, doc)
+	found := len(branches) != 0
+	for _, ok := range selected {
+		found = found && ok
+	}
+	if !found {
+		return make([]bool, len(branches)), "intersect: document is not found by every branch"
+	}
+	names := make([]string, len(branches))
+	for i, b := range branches {
+		names[i] = b.name
+	}
+	return selected, fmt.Sprintf("intersect: branches %s", strings.Join(names, ", "))
+}
+
+// NewIntersectMixer is constructor of mixer, which intersects branches.
+func NewIntersectMixer() Mixer {
+	return &intersectMixer{}
+}
+
+type differenceMixer struct {
+	Base string // Name of base branch
+}
+
+// Mix keeps documents of base branch, which are not strongly matched by other branches.
+// Relevance of base branch is kept without weight, weights of other branches are ignored.
+func (mixer *differenceMixer) Mix(branches []branch) Hypotheses {
+	var base *branch
+	for i := range branches {
+		if branches[i].name == mixer.Base {
+			base = &branches[i]
+			break
+		}
+	}
+	if base == nil {
+		return newHypotheses()
+	}
+
+	res := make(Hypotheses, len(base.hypotheses))
+	for id, v := range base.hypotheses {
+		res[id] = base.unscaled(v)
+	}
+	for _, b := range branches {
+		if b.name != mixer.Base {
+			res = res.exclude(b.hypotheses, 0)
+		}
+	}
+	return res
+}
+
+func (mixer *differenceMixer) Explain(branches []branch, doc int64) ([]bool, string) {
+	selected := make([]bool, len(branches))
+	var excluded []string
+	for i, b := range branches {
+		v, ok := b.hypotheses[doc]
+		if b.name == mixer.Base {
+			selected[i] = ok
+		} else if ok && v >= b.hypotheses.exclusion(0) {
+			excluded = append(excluded, b.name)
+		}
+	}
+	res := fmt.Sprintf("difference: base %q", mixer.Base)
+	if len(excluded) != 0 {
+		res += fmt.Sprintf(", document is excluded by %s", strings.Join(excluded, ", "))
+		for i := range selected {
+			selected[i] = false
+		}
+	}
+	return selected, res
+}
+
+// NewDifferenceMixer is constructor of mixer, which subtracts other branches from base branch.
+func NewDifferenceMixer(base string) Mixer {
+	return &differenceMixer{Base: base}
+}
+
+type weightedMinMixer struct {
+}
+
+// Mix takes weighted minimum of branches: min(max(1 - w/wmax, v)).
+// Branches of the greatest weight are required, branches of lower weight
+// can lower relevance only down to 1 - w/wmax, so they are optional.
+func (mixer *weightedMinMixer) Mix(branches []branch) Hypotheses {
+	var wmax float64
+	for _, b := range branches {
+		wmax = math.Max(wmax, b.weight)
+	}
+	if wmax <= 0 {
+		return newHypotheses()
+	}
+
+	res := newHypotheses()
+	for _, b := range branches {
+		for id := range b.hypotheses {
+			if _, ok := res[id]; ok {
+				continue
+			}
+			relevance := math.Inf(1)
+			for _, b := range branches {
+				v := b.unscaled(b.hypotheses[id])
+				relevance = math.Min(relevance, math.Max(1-b.weight/wmax, v))
+			}
+			res[id] = relevance
+		}
+	}
+	for id, v := range res {
+		if v <= 0 {
+			delete(res, id)
+		}
+	}
+	return res
+}
+
+func (mixer *weightedMinMixer) Explain(branches []branch, doc int64) ([]bool, string) {
+	return explainFound(mixerName(mixer), branches, doc)
+}
+
+// NewWeightedMinMixer is constructor of mixer, which takes weighted minimum of branches.
+func NewWeightedMinMixer() Mixer {
+	return &weightedMinMixer{}
+}
+
+// Default constant of reciprocal rank fusion
+const defaultRRFK = 60
+
+// Rank documents of branch by relevance DESC, id ASC
+func (b *branch) ranks() map[int64]int {
+	ids := make([]int64, 0, len(b.hypotheses))
+	for id := range b.hypotheses {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		vi, vj := b.hypotheses[ids[i]], b.hypotheses[ids[j]]
+		if vi != vj {
+			return vi > vj
+		}
+		return ids[i] < ids[j]
+	})
+	res := make(map[int64]int, len(ids))
+	for i, id := range ids {
+		res[id] = i + 1
+	}
+	return res
+}
+
+// Sum of weights of branches
+func branchesWeight(branches []branch) float64 {
+	var res float64
+	for _, b := range branches {
+		res += b.weight
+	}
+	return res
+}
+
+type rrfMixer struct {
+	K float64 // Constant of fusion, which smooths difference of top ranks
+}
+
+// Mix sums weighted reciprocal ranks w/(K + rank) of branches.
+// Scores are scaled, so document of the first rank in every branch has relevance 1.
+func (mixer *rrfMixer) Mix(branches []branch) Hypotheses {
+	k := mixer.K
+	if k <= 0 {
+		k = defaultRRFK
+	}
+	total := branchesWeight(branches) / (k + 1)
+	res := newHypotheses()
+	if total <= 0 {
+		return res
+	}
+	for _, b := range branches {
+		for id, rank := range b.ranks() {
+			res[id] += b.weight / (k + float64(rank)) / total
+		}
+	}
+	return res
+}
+
+func (mixer *rrfMixer) Explain(branches []branch, doc int64) ([]bool, string) {
+	return explainFound(mixerName(mixer), branches, doc)
+}
+
+// NewRRFMixer is constructor of reciprocal rank fusion mixer.
+// Constant k is 60, if it isn't positive.
+func NewRRFMixer(k float64) Mixer {
+	return &rrfMixer{K: k}
+}
+
+type minMaxMixer struct {
+}
+
+// Mix takes weighted mean of relevances, normalized into [0, 1] by minimum and maximum of branch.
+// Normalization doesn't depend on scale of branch, so weights are applied once.
+func (mixer *minMaxMixer) Mix(branches []branch) Hypotheses {
+	total := branchesWeight(branches)
+	res := newHypotheses()
+	if total <= 0 {
+		return res
+	}
+	for _, b := range branches {
+		if len(b.hypotheses) == 0 {
+			continue
+		}
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, v := range b.hypotheses {
+			lo = math.Min(lo, v)
+			hi = math.Max(hi, v)
+		}
+		for id, v := range b.hypotheses {
+			norm := float64(1)
+			if hi > lo {
+				norm = (v - lo) / (hi - lo)
+			}
+			res[id] += b.weight * norm / total
+		}
+	}
+	return res
+}
+
+func (mixer *minMaxMixer) Explain(branches []branch, doc int64) ([]bool, string) {
+	return explainFound(mixerName(mixer), branches, doc)
+}
+
+// NewMinMaxMixer is constructor of min-max normalized weighted sum mixer.
+func NewMinMaxMixer() Mixer {
+	return &minMaxMixer{}
+}
+
+type zScoreMixer struct {
+}
+
+// Mix takes weighted mean of z-scores of branches and maps it into (0, 1) by logistic function.
+// Document, missing in branch, has zero relevance in it.
+func (mixer *zScoreMixer) Mix(branches []branch) Hypotheses {
+	total := branchesWeight(branches)
+	res := newHypotheses()
+	if total <= 0 {
+		return res
+	}
+
+	type moments struct {
+		mean, std float64
+	}
+	stats := make([]moments, len(branches))
+	for i, b := range branches {
+		n := float64(len(b.hypotheses))
+		if n == 0 {
+			continue
+		}
+		var sum, sum2 float64
+		for _, v := range b.hypotheses {
+			sum += v
+			sum2 += v * v
+		}
+		mean := sum / n
+		stats[i] = moments{
+			mean: mean,
+			std:  math.Sqrt(math.Max(sum2/n-mean*mean, 0)),
+		}
+		for id := range b.hypotheses {
+			res[id] = 0
+		}
+	}
+
+	for id := range res {
+		var z float64
+		for i, b := range branches {
+			if stats[i].std > 0 {
+				z += b.weight * (b.hypotheses[id] - stats[i].mean) / stats[i].std
+			}
+		}
+		res[id] = 1 / (1 + math.Exp(-z/total))
+	}
+	return res
+}
+
+func (mixer *zScoreMixer) Explain(branches []branch, doc int64) ([]bool, string) {
+	return explainFound(mixerName(mixer), branches, doc)
+}
+
+// NewZScoreMixer is constructor of z-score fusion mixer.
+func NewZScoreMixer() Mixer {
+	return &zScoreMixer{}
+}
+
+func init() {
+	gob.Register(&intersectMixer{})
+	gob.Register(&differenceMixer{})
+	gob.Register(&weightedMinMixer{})
+	gob.Register(&rrfMixer{})
+	gob.Register(&minMaxMixer{})
+	gob.Register(&zScoreMixer{})
+}
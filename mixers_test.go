@@ -0,0 +1,267 @@
+package parcels
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHypothesesIntersect(t *testing.T) {
+	hs := Hypotheses{1: 0.9, 2: 0.5, 3: 0.25}.intersect(Hypotheses{2: 0.25, 3: 0.5, 4: 1})
+	assert.Equal(t, Hypotheses{2: 0.75, 3: 0.75}, hs)
+}
+
+func TestHypothesesExclude(t *testing.T) {
+	hs := Hypotheses{1: 0.9, 2: 0.5, 3: 0.7}.exclude(
+		// Weak match of document 3 doesn't exclude it
+		Hypotheses{1: 0.8, 3: 0.4, 4: 1},
+		0,
+	)
+	assert.Equal(t, Hypotheses{2: 0.5, 3: 0.7}, hs)
+
+	// All matches are weak, if they are below floor
+	hs = Hypotheses{1: 0.9, 2: 0.5, 3: 0.7}.exclude(Hypotheses{1: 0.2, 3: 0.3}, 0.25)
+	assert.Equal(t, Hypotheses{1: 0.9, 2: 0.5}, hs)
+}
+
+func TestMixers(t *testing.T) {
+	branches := []branch{
+		{name: "a", weight: 0.5, hypotheses: Hypotheses{1: 0.5, 2: 0.4, 3: 0.1}},
+		{name: "b", weight: 0.25, hypotheses: Hypotheses{2: 0.15, 3: 0.25, 4: 0.25}},
+	}
+
+	hs := NewIntersectMixer().Mix(branches)
+	assertHypotheses(t, Hypotheses{2: 0.55, 3: 0.35}, hs)
+	assert.Empty(t, NewIntersectMixer().Mix(append(branches, branch{name: "c", weight: 0.25})))
+
+	// Document 3 is excluded, document 2 is weakly matched by "b"
+	hs = NewDifferenceMixer("a").Mix(branches)
+	assertHypotheses(t, Hypotheses{1: 1, 2: 0.8}, hs)
+	assert.Empty(t, NewDifferenceMixer("x").Mix(branches))
+
+	// Branch "a" is required, branch "b" lowers relevance down to 0.5
+	hs = NewWeightedMinMixer().Mix(branches)
+	assertHypotheses(t, Hypotheses{1: 0.5, 2: 0.6, 3: 0.2}, hs)
+}
+
+func TestMultiRuleIntersect(t *testing.T) {
+	ctx := context.Background()
+	spec, err := ParseStrategySpec([]byte(`{
+		"root": "root",
+		"nodes": {
+			"root": {"kind": "multi", "mixer": "intersect", "entries": [
+				{"node": "primary", "weight": 1},
+				{"node": "secondary", "weight": 1}
+			]},
+			"primary": {"kind": "ngram", "length": 3},
+			"secondary": {"kind": "ngram", "parser": "secondary", "length": 4}
+		}
+	}`))
+	require.NoError(t, err)
+	st, err := NewStrategyFromSpec(nil, nil, spec, docNameSearchIndexReader)
+	require.NoError(t, err)
+	for i, name := range []string{"аспирин", "аспаркам", "анальгин"} {
+		require.NoError(t, st.Append(ctx, &Doc{Id: int64(i + 1), NameSearchIndex: name}))
+	}
+
+	// "асп" has no ngrams of length 4, so intersection is empty
+	res := &resolver{cache: make(map[resolverKey]Hypotheses)}
+	hs := res.Resolve(ctx, st.(*strategy).Rule, []rune("асп"), 1, &Details{})
+	assert.Empty(t, hs)
+
+	hs = res.Resolve(ctx, st.(*strategy).Rule, []rune("аспирин"), 1, &Details{})
+	assert.True(t, hs[1] > 0)
+}
+
+func TestStrategySpecDifference(t *testing.T) {
+	text := `{
+		"root": "root",
+		"nodes": {
+			"root": {"kind": "multi", "mixer": "difference", "base": "%s", "entries": [
+				{"node": "a", "weight": 1},
+				{"node": "b", "weight": 1}
+			]},
+			"a": {"kind": "ngram", "length": 3},
+			"b": {"kind": "ngram", "parser": "secondary", "length": 3}
+		}
+	}`
+
+	spec, err := ParseStrategySpec([]byte(fmt.Sprintf(text, "a")))
+	require.NoError(t, err)
+	st, err := NewStrategyFromSpec(nil, nil, spec, nil)
+	require.NoError(t, err)
+	mixer := st.(*strategy).Rule.(*MultiRule).Mixer
+	assert.Equal(t, "difference", mixerName(mixer))
+	assert.Equal(t, "a", mixer.(*differenceMixer).Base)
+
+	spec, err = ParseStrategySpec([]byte(fmt.Sprintf(text, "c")))
+	require.NoError(t, err)
+	_, err = NewStrategyFromSpec(nil, nil, spec, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `base "c" is not entry of difference`)
+}
+
+func TestMixerExplain(t *testing.T) {
+	// Tie of estimates is explained by the branch, which is taken by max mixer
+	branches := []branch{
+		{hypotheses: Hypotheses{1: 0.5}, relevance: 0.5, name: "a"},
+		{hypotheses: Hypotheses{1: 0.4, 2: 0.5}, relevance: 0.5, name: "b"},
+	}
+	mixer := NewMaxMixer()
+	selected, decision := mixer.Explain(branches, 1)
+	assert.Equal(t, []bool{true, false}, selected)
+	assert.Equal(t, `max: branch "a" with estimate 0.5`, decision)
+	assert.Equal(t, branches[0].hypotheses, mixer.Mix(branches))
+
+	// Weak match of other branch doesn't exclude document
+	branches = []branch{
+		{hypotheses: Hypotheses{1: 1, 2: 1}, name: "base"},
+		{hypotheses: Hypotheses{1: 0.2, 2: 1}, name: "other"},
+	}
+	mixer = NewDifferenceMixer("base")
+	hs := mixer.Mix(branches)
+	for doc, expected := range map[int64]bool{1: true, 2: false} {
+		selected, decision = mixer.Explain(branches, doc)
+		_, found := hs[doc]
+		assert.Equal(t, expected, found, doc)
+		assert.Equal(t, []bool{expected, false}, selected, doc)
+		assert.Equal(t, !expected, strings.Contains(decision, `excluded by other`), decision)
+	}
+}
+
+func TestParadigmMix(t *testing.T) {
+	p := getParadigm("name inn intersect")
+	assert.Equal(t, []string{"name", "inn"}, p.types)
+	require.NotNil(t, p.mixer)
+	hs := p.mix([]Hypotheses{{1: 0.5, 2: 1}, {2: 0.5, 3: 1}})
+	assert.Equal(t, Hypotheses{2: 1.5}, hs)
+
+	// Results are united by default
+	p = getParadigm("name inn")
+	assert.Nil(t, p.mixer)
+	hs = p.mix([]Hypotheses{{1: 0.5, 2: 1}, {2: 0.5, 3: 1}})
+	assert.Equal(t, Hypotheses{1: 0.5, 2: 1, 3: 1}, hs)
+}
+
+func assertHypotheses(t *testing.T, expected, actual Hypotheses) {
+	require.Len(t, actual, len(expected))
+	for id, v := range expected {
+		assert.InDelta(t, v, actual[id], 1e-9, fmt.Sprint("document ", id))
+	}
+}
+
+func TestFusionMixers(t *testing.T) {
+	branches := []branch{
+		{name: "a", weight: 0.75, hypotheses: Hypotheses{1: 3, 2: 2, 3: 1}},
+		// Branch of other scale
+		{name: "b", weight: 0.25, hypotheses: Hypotheses{2: 0.3, 3: 0.2, 4: 0.1}},
+	}
+
+	hs := NewRRFMixer(1).Mix(branches)
+	assertHypotheses(
+		t,
+		Hypotheses{
+			1: (0.75 / 2) / 0.5,
+			2: (0.75/3 + 0.25/2) / 0.5,
+			3: (0.75/4 + 0.25/3) / 0.5,
+			4: (0.25 / 4) / 0.5,
+		},
+		hs,
+	)
+	// Document of the first rank in every branch has relevance 1
+	hs = NewRRFMixer(0).Mix([]branch{branches[0], {weight: 0.5, hypotheses: Hypotheses{1: 1}}})
+	assert.InDelta(t, 1, hs[1], 1e-9)
+
+	hs = NewMinMaxMixer().Mix(branches)
+	assertHypotheses(t, Hypotheses{1: 0.75, 2: 0.375 + 0.25, 3: 0.125, 4: 0}, hs)
+
+	// Document 2 isn't expected above document 1: with branches above both documents get
+	// exactly equal relevance, because z-score of document 1 in branch "a" weighted by 0.75
+	// matches z-score of document 2 in branch "b" of the same shape weighted by 0.25
+	// plus their scores in the other branches. Skewed branch "b" breaks the tie.
+	hs = NewZScoreMixer().Mix([]branch{
+		branches[0],
+		{name: "b", weight: 0.25, hypotheses: Hypotheses{2: 0.4, 3: 0.2, 4: 0.1}},
+	})
+	require.Len(t, hs, 4)
+	assert.True(t, hs[1] > hs[2])
+	assert.True(t, hs[2] > hs[3])
+	assert.True(t, hs[3] > hs[4])
+	for _, v := range hs {
+		assert.True(t, v > 0 && v < 1)
+	}
+
+	for _, mixer := range []Mixer{NewRRFMixer(1), NewMinMaxMixer(), NewZScoreMixer()} {
+		assert.Empty(t, mixer.Mix(nil))
+	}
+}
+
+func TestNgramOptionsMixer(t *testing.T) {
+	options := DefaultStrategyOptions().Ngrams
+	mixer := func() string {
+		name, err := options.mixer()
+		require.NoError(t, err)
+		return name
+	}
+	assert.Equal(t, "max", mixer())
+	options.Mix = true
+	assert.Equal(t, "sum", mixer())
+	options.Mixer = "rrf"
+	assert.Equal(t, "rrf", mixer())
+	options.Mix = false
+	assert.True(t, options.mixing())
+
+	// Unknown mixer is error of strategy
+	options.Mixer = "median"
+	_, err := options.mixer()
+	assert.Error(t, err)
+	invalid := DefaultStrategyOptions()
+	invalid.Ngrams.Mixer = "median"
+	_, err = NewStrategyDefault(nil, invalid, docNameSearchIndexReader)
+	assert.Error(t, err)
+	_, err = NewStrategyByOptions(nil, invalid, docNameSearchIndexReader)
+	assert.Error(t, err)
+
+	for name := range ngramMixers {
+		all := DefaultStrategyOptions()
+		all.Ngrams.Mixer = name
+		st, err := NewStrategyDefault(nil, all, docNameSearchIndexReader)
+		require.NoError(t, err, name)
+		var mixer Mixer
+		var find func(rule Rule)
+		find = func(rule Rule) {
+			if m, ok := rule.(*MultiRule); ok && strings.HasPrefix(m.Name(), "main.") {
+				mixer = m.Mixer
+			}
+			for _, child := range rule.Children() {
+				find(child)
+			}
+		}
+		find(st.(*strategy).Rule)
+		require.NotNil(t, mixer, name)
+		assert.Equal(t, name, mixerName(mixer))
+	}
+}
+
+func TestMixersGob(t *testing.T) {
+	for _, mixer := range []Mixer{
+		NewIntersectMixer(),
+		NewDifferenceMixer("a"),
+		NewWeightedMinMixer(),
+		NewRRFMixer(10),
+		NewMinMaxMixer(),
+		NewZScoreMixer(),
+	} {
+		var data bytes.Buffer
+		require.NoError(t, gob.NewEncoder(&data).Encode(&mixer))
+		var actual Mixer
+		require.NoError(t, gob.NewDecoder(&data).Decode(&actual))
+		assert.Equal(t, mixer, actual)
+	}
+}
@@ -0,0 +1,220 @@
+package parcels
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"spWebFront/FrontKeeper/infrastructure/core"
+	"spWebFront/FrontKeeper/server/app/domain/model"
+)
+
+// ErrPageCursor is error of cursor, which doesn't belong to the search.
+var ErrPageCursor = errors.New("invalid page cursor")
+
+// Pager is manager, that can search by pages.
+type Pager interface {
+	// Search page of results. Cursor of the next page is empty on the last page.
+	SearchPage(ctx context.Context, query string, typ string, details *Details, page PageOptions) (*Page, error)
+}
+
+// PageOptions is request of page.
+type PageOptions struct {
+	Size   int    `json:"size"`   // Maximal count of results in page
+	Cursor string `json:"cursor"` // Cursor of the previous page, empty for the first page
+}
+
+// Page is page of search results.
+type Page struct {
+	Parcels model.Parcels `json:"parcels"`
+	Next    string        `json:"next"` // Cursor of the next page
+}
+
+// pageCursor is position of the last result of page.
+// Position follows ordering of versionSorter, so the next page continues
+// after the same document, even if hypotheses are recomputed.
+type pageCursor struct {
+	Query     string  `json:"q"`
+	Type      string  `json:"t"`
+	Version   string  `json:"v"` // Hash of strategy options
+	Relevance float64 `json:"r"`
+	Name      string  `json:"n"`
+	Id        int64   `json:"i"`
+	Epsilon   float64 `json:"e"` // Tolerance of relevance, used by sorter of the first page
+}
+
+func (cursor *pageCursor) encode() (string, error) {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return "", fmt.Errorf("Marshal: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodePageCursor(s string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPageCursor, err)
+	}
+	cursor := new(pageCursor)
+	err = core.JsonUnmarshal(data, cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPageCursor, err)
+	}
+	return cursor, nil
+}
+
+// Check, that version goes after cursor in order of versionSorter
+func (cursor *pageCursor) precedes(v *version) bool {
+	if d := math.Abs(v.relevance - cursor.Relevance); d != 0 && d >= cursor.Epsilon {
+		return v.relevance < cursor.Relevance
+	}
+	if c := core.Compare(v.name, cursor.Name); c != 0 {
+		return c > 0
+	}
+	return v.doc.Id > cursor.Id
+}
+
+// pageState is state of paginated search.
+// State is passed through context from engine to document manager.
+type pageState struct {
+	size  int
+	after *pageCursor // Position of the last result of the previous page
+	next  *pageCursor // Position of the last result of the page, if page is not the last one
+}
+
+type pageStateKey struct{}
+
+func pageStateFrom(ctx context.Context) *pageState {
+	state, _ := ctx.Value(pageStateKey{}).(*pageState)
+	return state
+}
+
+func withPageState(ctx context.Context, state *pageState) context.Context {
+	return context.WithValue(ctx, pageStateKey{}, state)
+}
+
+// Create state of page. Cursor must belong to the same query, type and strategy version.
+func newPageState(query, typ, version string, options PageOptions) (*pageState, error) {
+	state := &pageState{
+		size: options.Size,
+		after: &pageCursor{
+			Query:     query,
+			Type:      typ,
+			Version:   version,
+			Relevance: math.Inf(1),
+		},
+	}
+	if options.Cursor == "" {
+		return state, nil
+	}
+
+	cursor, err := decodePageCursor(options.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case cursor.Query != query:
+		return nil, fmt.Errorf("%w: cursor of query %q", ErrPageCursor, cursor.Query)
+	case cursor.Type != typ:
+		return nil, fmt.Errorf("%w: cursor of type %q", ErrPageCursor, cursor.Type)
+	case cursor.Version != version:
+		return nil, fmt.Errorf("%w: strategy is changed", ErrPageCursor)
+	}
+	state.after = cursor
+	return state, nil
+}
+
+// Remember tolerance of relevance, used by sorter of versions.
+// Tolerance is computed by all versions, like versionSorter does, and is kept by cursors of the next pages.
+func (state *pageState) sorted(vs versions) {
+	if state.after.Epsilon == 0 {
+		state.after.Epsilon = versionEpsilon(vs)
+	}
+}
+
+// Band of fetched documents. Capacity is unlimited, because band is cut by accepted documents
+// of the whole list, like Search does, so documents of the previous pages are fetched again.
+// Fetch stops after the first document of the next page, unless band is cut by knee.
+func (state *pageState) band(band *BandOptions) *BandOptions {
+	options := *band
+	options.Capacity = 0
+	return &options
+}
+
+// Cut band of accepted documents, select results after cursor and remember position of the next page.
+// Capacity of band is replaced by size of page.
+func (state *pageState) cut(
+	ps model.Parcels,
+	vs versions,
+	band *BandOptions,
+) (model.Parcels, versions, error) {
+	cutter, err := NewBandCutter(state.band(band))
+	if err != nil {
+		return nil, nil, fmt.Errorf("NewBandCutter: %w", err)
+	}
+	n := cutter.Cut(parcelRelevances(ps))
+
+	res := make(model.Parcels, 0, state.size)
+	accepted := make(versions, 0, state.size)
+	for i, v := range vs[:n] {
+		if !state.after.precedes(v) {
+			continue
+		}
+		if len(res) == state.size {
+			last := accepted[len(accepted)-1]
+			next := *state.after
+			next.Relevance = last.relevance
+			next.Name = last.name
+			next.Id = last.doc.Id
+			state.next = &next
+			break
+		}
+		res = append(res, ps[i])
+		accepted = append(accepted, v)
+	}
+	return res, accepted, nil
+}
+
+// SearchPage searches page of results.
+// Results of all pages are the same as results of Search with unlimited capacity,
+// band cutoff is applied to the whole list of accepted results.
+func (engine *advancedEngine) SearchPage(
+	ctx context.Context,
+	query string,
+	typ string,
+	details *Details,
+	options PageOptions,
+) (*Page, error) {
+	if options.Size <= 0 {
+		return nil, fmt.Errorf("invalid page size %d", options.Size)
+	}
+	header, err := newSnapshotHeader(&engine.options.Search)
+	if err != nil {
+		return nil, fmt.Errorf("newSnapshotHeader: %w", err)
+	}
+
+	query = strings.TrimSpace(strings.ToLower(query))
+	state, err := newPageState(query, typ, header.Hash, options)
+	if err != nil {
+		return nil, err
+	}
+
+	ps, err := engine.Search(withPageState(ctx, state), query, typ, details)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{Parcels: ps}
+	if state.next != nil {
+		page.Next, err = state.next.encode()
+		if err != nil {
+			return nil, fmt.Errorf("encode: %w", err)
+		}
+	}
+	return page, nil
+}
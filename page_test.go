@@ -0,0 +1,170 @@
+package parcels
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"spWebFront/FrontKeeper/server/app/domain/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPageCursor(t *testing.T) {
+	cursor := &pageCursor{
+		Query:     "аспирин",
+		Type:      "name",
+		Version:   "v1",
+		Relevance: 0.5,
+		Name:      "drug",
+		Id:        7,
+		Epsilon:   0.001,
+	}
+	s, err := cursor.encode()
+	require.NoError(t, err)
+
+	state, err := newPageState("аспирин", "name", "v1", PageOptions{Size: 10, Cursor: s})
+	require.NoError(t, err)
+	assert.Equal(t, cursor, state.after)
+
+	for _, tc := range []struct {
+		query, typ, version, cursor string
+	}{
+		{"аспаркам", "name", "v1", s},
+		{"аспирин", "inn", "v1", s},
+		{"аспирин", "name", "v2", s},
+		{"аспирин", "name", "v1", "???"},
+	} {
+		_, err := newPageState(tc.query, tc.typ, tc.version, PageOptions{Size: 10, Cursor: tc.cursor})
+		assert.True(t, errors.Is(err, ErrPageCursor), tc)
+	}
+
+	doc := func(id int64) *Doc { return &Doc{Id: id} }
+	assert.True(t, cursor.precedes(&version{doc: doc(1), relevance: 0.4, name: "a"}))
+	assert.False(t, cursor.precedes(&version{doc: doc(1), relevance: 0.6, name: "z"}))
+	// Equal relevance is ordered by name and identifier
+	assert.True(t, cursor.precedes(&version{doc: doc(1), relevance: 0.5005, name: "drugs"}))
+	assert.False(t, cursor.precedes(&version{doc: doc(1), relevance: 0.4995, name: "a"}))
+	assert.True(t, cursor.precedes(&version{doc: doc(8), relevance: 0.5, name: "drug"}))
+	assert.False(t, cursor.precedes(&version{doc: doc(7), relevance: 0.5, name: "drug"}))
+}
+
+func TestDocManagerResolvePages(t *testing.T) {
+	ctx := context.Background()
+	details := &Details{
+		Band:   BandOptions{Capacity: 100, Threshold: 0.2},
+		Filter: &resolver{EntityFilterEx: entityFilterMock{}},
+	}
+
+	docs, repo, hs := newDocManagerMock(30, 0)
+	// Equal relevances are ordered by name
+	hs[4] = hs[6]
+	full, err := docs.Resolve(ctx, hs, details, docNameGroupIndexReader)
+	require.NoError(t, err)
+	require.Len(t, full, 12)
+
+	original := hs[2]
+	for _, batch := range []bool{false, true} {
+		hs[2] = original
+		if batch {
+			docs.parcels = batchRepositoryMock{repo}
+		}
+
+		var pages []int
+		var all []string
+		state, err := newPageState("q", "name", "v1", PageOptions{Size: 5})
+		require.NoError(t, err)
+		for {
+			ps, err := docs.Resolve(withPageState(ctx, state), hs, details, docNameGroupIndexReader)
+			require.NoError(t, err)
+			pages = append(pages, len(ps))
+			for _, p := range ps {
+				all = append(all, p.Document)
+			}
+			if state.next == nil {
+				break
+			}
+			s, err := state.next.encode()
+			require.NoError(t, err)
+			state, err = newPageState("q", "name", "v1", PageOptions{Size: 5, Cursor: s})
+			require.NoError(t, err)
+
+			// Hypotheses are recomputed: new document appears before cursor
+			hs[2] = hs[1] + 0.0001
+		}
+
+		assert.Equal(t, []int{5, 5, 2}, pages, batch)
+		var expected []string
+		for _, p := range full {
+			expected = append(expected, p.Document)
+		}
+		assert.Equal(t, expected, all, batch)
+	}
+}
+
+func TestDocManagerResolvePagesFiltered(t *testing.T) {
+	ctx := context.Background()
+	details := &Details{
+		Band:   BandOptions{Mode: "top", Capacity: 100, Diff: BandDiffOptions{Abs: 0.7}},
+		Filter: &resolver{EntityFilterEx: entityFilterMock{}},
+	}
+
+	// The best candidate is rejected by filter, so ratio to the top is measured by the next one
+	docs, _, _ := newDocManagerMock(10, 0)
+	hs := Hypotheses{1: 1, 2: 0.6, 4: 0.58, 6: 0.56, 8: 0.3}
+	full, err := docs.Resolve(ctx, hs, details, docNameGroupIndexReader)
+	require.NoError(t, err)
+	require.Len(t, full, 3)
+
+	var all model.Parcels
+	state, err := newPageState("q", "name", "v1", PageOptions{Size: 2})
+	require.NoError(t, err)
+	for {
+		ps, err := docs.Resolve(withPageState(ctx, state), hs, details, docNameGroupIndexReader)
+		require.NoError(t, err)
+		all = append(all, ps...)
+		if state.next == nil {
+			break
+		}
+		// Tolerance is computed by all candidates, like sorter does
+		assert.InDelta(t, 0.01*0.3/5, state.next.Epsilon, 1e-12)
+		state = &pageState{size: 2, after: state.next}
+	}
+	assert.Equal(t, full, all)
+}
+
+func TestDocManagerResolvePageFetch(t *testing.T) {
+	ctx := context.Background()
+	details := &Details{
+		Band:   BandOptions{Capacity: 100},
+		Filter: &resolver{EntityFilterEx: entityFilterMock{}},
+	}
+	docs, repo, hs := newDocManagerMock(100, 0)
+
+	// Fetch stops after the first accepted document of the next page
+	state, err := newPageState("q", "name", "v1", PageOptions{Size: 5})
+	require.NoError(t, err)
+	ps, err := docs.Resolve(withPageState(ctx, state), hs, details, docNameGroupIndexReader)
+	require.NoError(t, err)
+	assert.Len(t, ps, 5)
+	assert.NotNil(t, state.next)
+	assert.Equal(t, 12, repo.calls)
+
+	// The next page fetches documents of the previous page again
+	repo.calls = 0
+	state = &pageState{size: 5, after: state.next}
+	ps, err = docs.Resolve(withPageState(ctx, state), hs, details, docNameGroupIndexReader)
+	require.NoError(t, err)
+	assert.Len(t, ps, 5)
+	assert.Equal(t, 22, repo.calls)
+
+	// Knee depends on all documents, so all of them are fetched
+	repo.calls = 0
+	details.Band.Mode = "knee"
+	state, err = newPageState("q", "name", "v1", PageOptions{Size: 5})
+	require.NoError(t, err)
+	_, err = docs.Resolve(withPageState(ctx, state), hs, details, docNameGroupIndexReader)
+	require.NoError(t, err)
+	assert.Equal(t, 100, repo.calls)
+}
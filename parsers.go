@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/gob"
 	"strings"
+	"unicode/utf8"
 )
 
 type NgramEntry struct {
@@ -74,35 +75,53 @@ type NgramParser interface {
 	// Purge dictionary
 	Purge()
 	// Parse runes
-	Parse(ctx context.Context, runes []rune, allowNew bool) []NgramEntry
+	Parse(ctx context.Context, runes []rune, allowNew bool) ([]NgramEntry, error)
 	// Find ngram text
 	Find(id NgramId) string
 	// Get ngram count
 	Count() int
+	// Load dictionary from decoder
+	Load(dec *gob.Decoder) error
+	// Save dictionary to encoder
+	Save(enc *gob.Encoder) error
+}
+
+// ngramRebuilder is parser, that can fill new dictionary while the current one is in use.
+type ngramRebuilder interface {
+	// Get dictionary of parser
+	dictionary() *NgramDictionary
+	// Copy parser with other dictionary
+	withDictionary(dict *NgramDictionary) NgramParser
 }
 
 type NgramParserBase struct {
-	Counter   NgramId            // counter for ngrams
-	Len       int                // length of ngrams
-	Items     map[string]NgramId // dictionary: ngramText -> ngramId
-	Estimator ParserEstimator
+	Dictionary *NgramDictionary // dictionary: ngramText -> ngramId
+	Len        int              // length of ngrams
+	Estimator  ParserEstimator
 }
 
 func (parser *NgramParserBase) Find(id NgramId) string {
-	for s, v := range parser.Items {
-		if v == id {
-			return s
-		}
-	}
-	return ""
+	return parser.Dictionary.Find(id)
+}
+
+func (parser *NgramParserBase) dictionary() *NgramDictionary {
+	return parser.Dictionary
 }
 
 func (parser *NgramParserBase) Purge() {
-	parser.Items = map[string]NgramId{}
+	parser.Dictionary.Purge()
 }
 
 func (parser *NgramParserBase) Count() int {
-	return len(parser.Items)
+	return parser.Dictionary.Count()
+}
+
+func (parser *NgramParserBase) Load(dec *gob.Decoder) error {
+	return parser.Dictionary.Load(dec)
+}
+
+func (parser *NgramParserBase) Save(enc *gob.Encoder) error {
+	return parser.Dictionary.Save(enc)
 }
 
 func (parser *NgramParserBase) extends(
@@ -110,19 +129,17 @@ func (parser *NgramParserBase) extends(
 	text string,
 	ngram NgramEntry,
 	allowNew bool,
-) []NgramEntry {
-	n, ok := parser.Items[text]
+) ([]NgramEntry, error) {
+	n, ok, err := parser.Dictionary.Accept(text, allowNew)
+	if err != nil {
+		return nil, err
+	}
 	if !ok {
-		if !allowNew {
-			return ngrams
-		}
-		parser.Counter++
-		n = parser.Counter
-		parser.Items[text] = n
+		return ngrams, nil
 	}
 
 	ngram.Id = n
-	return append(ngrams, ngram)
+	return append(ngrams, ngram), nil
 }
 
 // Формирование списка нграм с дроблениме на лексемы
@@ -157,11 +174,11 @@ func (parser *NgramParserPrimary) Parse(
 	ctx context.Context,
 	runes []rune,
 	allowNew bool,
-) []NgramEntry {
+) ([]NgramEntry, error) {
 	runes = SkipPunct(ctx, runes)
 	length := len(runes) - parser.Len + 1
 	if length <= 0 {
-		return nil
+		return nil, nil
 	}
 
 	sum := sumN(length)
@@ -183,7 +200,8 @@ func (parser *NgramParserPrimary) Parse(
 				ngram := src[p : p+parser.Len]
 				info.Abs.Pos = float32(length - pos - p)
 				info.Rel.Pos = float32(mlen - p)
-				ngrams = parser.extends(
+				var err error
+				ngrams, err = parser.extends(
 					ngrams,
 					string(ngram),
 					NgramEntry{
@@ -193,10 +211,51 @@ func (parser *NgramParserPrimary) Parse(
 					},
 					allowNew,
 				)
+				if err != nil {
+					return nil, err
+				}
 			}
 		}
 	}
-	return ngrams
+	return ngrams, nil
+}
+
+// Locate ngram at position pos in runes, passed to Parse.
+// Position of ngram is byte offset of token plus rune offset in token.
+func (parser *NgramParserPrimary) locate(runes []rune, pos int16) (int, int, bool) {
+	runes = SkipPunct(context.Background(), runes)
+	source := string(runes)
+	start := 0 // Rune offset of token
+	last := 0  // Byte offset of the previous token
+	for _, ch := range split(source) {
+		start += utf8.RuneCountInString(source[last:ch.src])
+		last = ch.src
+		mlen := utf8.RuneCountInString(source[ch.src:ch.dst])
+		p := int(pos) - ch.src
+		if p >= 0 && p <= mlen-parser.Len {
+			return start + p, start + p + parser.Len, true
+		}
+	}
+	return 0, 0, false
+}
+
+// Count ngrams of runes, including ngrams, which are absent in dictionary.
+func (parser *NgramParserPrimary) windows(ctx context.Context, runes []rune) int {
+	source := string(SkipPunct(ctx, runes))
+	var res int
+	for _, ch := range split(source) {
+		if n := utf8.RuneCountInString(source[ch.src:ch.dst]) - parser.Len + 1; n > 0 {
+			res += n
+		}
+	}
+	return res
+}
+
+// Copy parser with other dictionary
+func (parser *NgramParserPrimary) withDictionary(dict *NgramDictionary) NgramParser {
+	res := *parser
+	res.Dictionary = dict
+	return &res
 }
 
 func NewNgramParserPrimary(
@@ -205,9 +264,9 @@ func NewNgramParserPrimary(
 ) NgramParser {
 	return &NgramParserPrimary{
 		NgramParserBase: NgramParserBase{
-			Len:       len,
-			Items:     make(map[string]NgramId, 32768),
-			Estimator: estimator,
+			Dictionary: NewNgramDictionary(MaxNgramId),
+			Len:        len,
+			Estimator:  estimator,
 		},
 	}
 }
@@ -222,7 +281,7 @@ func (parser *NgramParserSecondary) Parse(
 	ctx context.Context,
 	runes []rune,
 	allowNew bool,
-) []NgramEntry {
+) ([]NgramEntry, error) {
 	txt := string(runes)
 	txt = strings.Replace(txt, " ", "", -1)
 	txt = strings.Replace(txt, "\t", "", -1)
@@ -230,7 +289,7 @@ func (parser *NgramParserSecondary) Parse(
 
 	length := len(text) - parser.Len + 1
 	if length <= 0 {
-		return nil
+		return nil, nil
 	}
 
 	sum := sumN(length)
@@ -248,7 +307,8 @@ func (parser *NgramParserSecondary) Parse(
 		info.Abs.Pos = float32(length - p)
 		info.Rel.Pos = info.Abs.Pos
 		score := parser.Estimator.Estimate(&info)
-		ngrams = parser.extends(
+		var err error
+		ngrams, err = parser.extends(
 			ngrams,
 			string(ngram),
 			NgramEntry{
@@ -258,9 +318,49 @@ func (parser *NgramParserSecondary) Parse(
 			},
 			allowNew,
 		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ngrams, nil
+}
+
+// Locate ngram at position pos in runes, passed to Parse.
+// Position of ngram is rune offset in text without spaces.
+func (parser *NgramParserSecondary) locate(runes []rune, pos int16) (int, int, bool) {
+	var index []int // Offsets of runes, which are not spaces
+	for i, r := range runes {
+		if r != ' ' && r != '\t' {
+			index = append(index, i)
+		}
+	}
+	end := int(pos) + parser.Len
+	if pos < 0 || end > len(index) {
+		return 0, 0, false
 	}
+	return index[pos], index[end-1] + 1, true
+}
+
+// Count ngrams of runes, including ngrams, which are absent in dictionary.
+func (parser *NgramParserSecondary) windows(ctx context.Context, runes []rune) int {
+	var n int
+	for _, r := range runes {
+		if r != ' ' && r != '\t' {
+			n++
+		}
+	}
+	if n < parser.Len {
+		return 0
+	}
+	return n - parser.Len + 1
+}
 
-	return ngrams
+// Copy parser with other dictionary
+func (parser *NgramParserSecondary) withDictionary(dict *NgramDictionary) NgramParser {
+	res := *parser
+	res.Dictionary = dict
+	return &res
 }
 
 func NewNgramParserSecondary(
@@ -269,14 +369,15 @@ func NewNgramParserSecondary(
 ) NgramParser {
 	return &NgramParserSecondary{
 		NgramParserBase: NgramParserBase{
-			Len:       len,
-			Items:     make(map[string]NgramId, 32768),
-			Estimator: estimator,
+			Dictionary: NewNgramDictionary(MaxNgramId),
+			Len:        len,
+			Estimator:  estimator,
 		},
 	}
 }
 
 func init() {
+	gob.Register(&NgramDictionary{})
 	gob.Register(&NgramParserBase{})
 	gob.Register(&NgramParserPrimary{})
 	gob.Register(&NgramParserSecondary{})
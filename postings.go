@@ -0,0 +1,209 @@
+package parcels
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"unsafe"
+)
+
+// Size of single uncompressed reference in bytes.
+const refSize = int(unsafe.Sizeof(Ref{}))
+
+// postingList is immutable list of references to documents, ordered by document.
+type postingList interface {
+	// Count of references
+	Len() int
+	// Occupied memory in bytes
+	Size() int
+	// Decode all references
+	Refs() []Ref
+	// Iterate over references without full decoding
+	ForEach(action func(r Ref))
+	// Get cursor for document-at-a-time traversal
+	Cursor() refCursor
+}
+
+// refCursor is forward-only position in posting list.
+type refCursor interface {
+	// Current reference. False, when list is over.
+	Ref() (Ref, bool)
+	// Move to the next reference
+	Next()
+	// Move to the first reference with document not less than doc
+	Advance(doc int64)
+}
+
+// postingCodec makes posting lists of the same representation.
+type postingCodec func(refs []Ref) postingList
+
+// refList is uncompressed posting list.
+type refList []Ref
+
+func (list refList) Len() int {
+	return len(list)
+}
+
+func (list refList) Size() int {
+	return len(list) * refSize
+}
+
+func (list refList) Refs() []Ref {
+	return list
+}
+
+func (list refList) ForEach(action func(r Ref)) {
+	for _, r := range list {
+		action(r)
+	}
+}
+
+func (list refList) Cursor() refCursor {
+	return &refListCursor{list: list}
+}
+
+type refListCursor struct {
+	list refList
+	pos  int
+}
+
+func (cursor *refListCursor) Ref() (Ref, bool) {
+	if cursor.pos >= len(cursor.list) {
+		return Ref{}, false
+	}
+	return cursor.list[cursor.pos], true
+}
+
+func (cursor *refListCursor) Next() {
+	cursor.pos++
+}
+
+func (cursor *refListCursor) Advance(doc int64) {
+	rest := cursor.list[cursor.pos:]
+	cursor.pos += sort.Search(len(rest), func(i int) bool { return rest[i].Doc >= doc })
+}
+
+func newRefList(refs []Ref) postingList {
+	return refList(refs)
+}
+
+// packedRefs is compressed posting list.
+// Every reference is encoded as delta of document identifier (uvarint),
+// position (varint), document length (uvarint) and weight, quantized to 16 bits.
+type packedRefs struct {
+	count int
+	data  []byte
+}
+
+func (list *packedRefs) Len() int {
+	return list.count
+}
+
+func (list *packedRefs) Size() int {
+	return len(list.data) + int(unsafe.Sizeof(*list))
+}
+
+func (list *packedRefs) Refs() []Ref {
+	res := make([]Ref, 0, list.count)
+	list.ForEach(func(r Ref) {
+		res = append(res, r)
+	})
+	return res
+}
+
+func (list *packedRefs) ForEach(action func(r Ref)) {
+	var r Ref
+	data := list.data
+	for len(data) != 0 {
+		data = decodePackedRef(data, &r)
+		action(r)
+	}
+}
+
+func (list *packedRefs) Cursor() refCursor {
+	cursor := &packedRefsCursor{data: list.data}
+	cursor.Next()
+	return cursor
+}
+
+// Decode reference from data. Document identifier of r must contain the previous one.
+func decodePackedRef(data []byte, r *Ref) []byte {
+	delta, n := binary.Uvarint(data)
+	data = data[n:]
+	pos, n := binary.Varint(data)
+	data = data[n:]
+	length, n := binary.Uvarint(data)
+	data = data[n:]
+	weight := binary.LittleEndian.Uint16(data)
+	data = data[2:]
+
+	r.Doc += int64(delta)
+	r.Pos = int16(pos)
+	r.Len = int16(length)
+	r.Weight = dequantizeWeight(weight)
+	return data
+}
+
+type packedRefsCursor struct {
+	data []byte // Rest of encoded references
+	ref  Ref    // Current reference
+	ok   bool   // Current reference is valid
+}
+
+func (cursor *packedRefsCursor) Ref() (Ref, bool) {
+	return cursor.ref, cursor.ok
+}
+
+func (cursor *packedRefsCursor) Next() {
+	if len(cursor.data) == 0 {
+		cursor.ok = false
+		return
+	}
+	cursor.data = decodePackedRef(cursor.data, &cursor.ref)
+	cursor.ok = true
+}
+
+func (cursor *packedRefsCursor) Advance(doc int64) {
+	for cursor.ok && cursor.ref.Doc < doc {
+		cursor.Next()
+	}
+}
+
+func newPackedRefs(refs []Ref) postingList {
+	var buf [3*binary.MaxVarintLen64 + 2]byte
+	data := make([]byte, 0, 5*len(refs))
+	var prev int64
+	for _, r := range refs {
+		n := binary.PutUvarint(buf[:], uint64(r.Doc-prev))
+		n += binary.PutVarint(buf[n:], int64(r.Pos))
+		n += binary.PutUvarint(buf[n:], uint64(r.Len))
+		binary.LittleEndian.PutUint16(buf[n:], quantizeWeight(r.Weight))
+		data = append(data, buf[:n+2]...)
+		prev = r.Doc
+	}
+
+	// Release unused capacity
+	packed := make([]byte, len(data))
+	copy(packed, data)
+
+	return &packedRefs{
+		count: len(refs),
+		data:  packed,
+	}
+}
+
+// Convert weight [0..1] to fixed point value
+func quantizeWeight(weight float64) uint16 {
+	if weight <= 0 {
+		return 0
+	}
+	if weight >= 1 {
+		return math.MaxUint16
+	}
+	return uint16(math.Round(weight * math.MaxUint16))
+}
+
+// Convert fixed point value to weight [0..1]
+func dequantizeWeight(weight uint16) float64 {
+	return float64(weight) / math.MaxUint16
+}
@@ -0,0 +1,82 @@
+package parcels
+
+import (
+	"context"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Ref is element of every uncompressed posting list, so padding costs a lot of memory
+func TestRefSize(t *testing.T) {
+	assert.Equal(t, uintptr(24), unsafe.Sizeof(Ref{}))
+}
+
+func TestPackedRefs(t *testing.T) {
+	refs := []Ref{
+		{Doc: 1, Pos: 0, Weight: 1},
+		{Doc: 2, Pos: -3, Weight: 0.5},
+		{Doc: 300, Pos: 12, Weight: 0.25},
+		{Doc: 1 << 40, Pos: 32767, Weight: 0},
+	}
+
+	list := newPackedRefs(refs)
+	assert.Equal(t, len(refs), list.Len())
+	assert.Less(t, list.Size(), refList(refs).Size())
+
+	decoded := list.Refs()
+	require.Len(t, decoded, len(refs))
+	for i, r := range refs {
+		assert.Equal(t, r.Doc, decoded[i].Doc)
+		assert.Equal(t, r.Pos, decoded[i].Pos)
+		assert.InDelta(t, r.Weight, decoded[i].Weight, 1e-4)
+	}
+
+	assert.Equal(t, 0, newPackedRefs(nil).Len())
+	assert.Empty(t, newPackedRefs(nil).Refs())
+}
+
+func TestCompressedNgramIndex(t *testing.T) {
+	ctx := context.Background()
+	names := newSyntheticNames(1000)
+	newRule := func(compressed bool) *ngramRule {
+		return NewNgramRule(
+			"ngram.3",
+			NewNgramIndex(nil, NgramIndexPositions{Weight: 0.3, Inflation: 0.3, Compressed: compressed}),
+			NewNgramParserPrimary(3, NewParserEstimatorPrimary(1)),
+		).(*ngramRule)
+	}
+
+	plain := newRule(false)
+	compressed := newRule(true)
+	for i, name := range names {
+		require.NoError(t, plain.Append(ctx, int64(i+1), []rune(name), 0.7))
+		require.NoError(t, compressed.Append(ctx, int64(i+1), []rune(name), 0.7))
+	}
+	plain.Remove(ctx, 5)
+	compressed.Remove(ctx, 5)
+
+	for _, query := range []string{"аспирин", "ибупрофен таблетки", names[10]} {
+		ngrams, err := plain.Parser.Parse(ctx, []rune(query), false)
+		require.NoError(t, err)
+		a := plain.Index.Search(ngrams, 1)
+		ngrams, err = compressed.Parser.Parse(ctx, []rune(query), false)
+		require.NoError(t, err)
+		b := compressed.Index.Search(ngrams, 1)
+
+		require.Equal(t, len(a), len(b), query)
+		for doc, rel := range a {
+			assert.InDelta(t, rel, b[doc], 1e-4, query)
+		}
+	}
+
+	sa := plain.Index.Statistics()
+	sb := compressed.Index.Statistics()
+	assert.Equal(t, sa.Count, sb.Count)
+	assert.Equal(t, sa.Refs, sb.Refs)
+	assert.Equal(t, sa.Size, sa.RawSize)
+	assert.Equal(t, sa.RawSize, sb.RawSize)
+	assert.Less(t, 3*sb.SizePerDoc(), sa.SizePerDoc())
+}
@@ -0,0 +1,215 @@
+package parcels
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ErrQuerySyntax is error of structured query.
+var ErrQuerySyntax = errors.New("invalid query syntax")
+
+// QueryError is error of structured query at position.
+type QueryError struct {
+	Pos    int    // Offset of rune in query
+	Reason string // Description of error
+}
+
+func (err *QueryError) Error() string {
+	return fmt.Sprintf("%v at %d: %s", ErrQuerySyntax, err.Pos, err.Reason)
+}
+
+func (err *QueryError) Unwrap() error {
+	return ErrQuerySyntax
+}
+
+// Methods of fields of structured query. Free text is searched by default method.
+var queryFields = map[string]method{
+	"":        doMultiSearch,
+	"name":    doNameSearch,
+	"inn":     doInnSearch,
+	"maker":   doMakerSearch,
+	"barcode": doBarCodeSearch,
+	"code":    doParcelCodeExSearch,
+}
+
+// QueryTerm is term of structured query.
+type QueryTerm struct {
+	Field    string `json:"field"` // Field of term, empty for free text
+	Value    string `json:"value"`
+	Negative bool   `json:"negative"` // Term excludes documents
+	Pos      int    `json:"pos"`      // Offset of term in query
+}
+
+// StructuredQuery is parsed query.
+// Syntax: words of free text, field:value, field:"quoted value", "quoted text",
+// and exclusions -word, -field:value.
+// All positive words of free text are searched together as one term.
+type StructuredQuery struct {
+	Terms []QueryTerm `json:"terms"`
+}
+
+// queryScanner is scanner of runes of query
+type queryScanner struct {
+	runes []rune
+	pos   int
+}
+
+func (s *queryScanner) done() bool {
+	return s.pos >= len(s.runes)
+}
+
+func (s *queryScanner) space() bool {
+	return !s.done() && unicode.IsSpace(s.runes[s.pos])
+}
+
+func (s *queryScanner) skipSpaces() {
+	for s.space() {
+		s.pos++
+	}
+}
+
+// Scan field name, if word is followed by colon
+func (s *queryScanner) field() (string, bool) {
+	end := s.pos
+	for end < len(s.runes) && unicode.IsLetter(s.runes[end]) {
+		end++
+	}
+	if end == s.pos || end >= len(s.runes) || s.runes[end] != ':' {
+		return "", false
+	}
+	field := strings.ToLower(string(s.runes[s.pos:end]))
+	s.pos = end + 1
+	return field, true
+}
+
+// Scan quoted or plain value
+func (s *queryScanner) value() (string, error) {
+	if !s.done() && s.runes[s.pos] == '"' {
+		start := s.pos
+		s.pos++
+		for !s.done() && s.runes[s.pos] != '"' {
+			s.pos++
+		}
+		if s.done() {
+			return "", &QueryError{Pos: start, Reason: "unterminated quote"}
+		}
+		s.pos++
+		return strings.TrimSpace(string(s.runes[start+1 : s.pos-1])), nil
+	}
+
+	start := s.pos
+	for !s.done() && !s.space() {
+		s.pos++
+	}
+	return string(s.runes[start:s.pos]), nil
+}
+
+// ParseQuery parses structured query.
+// Errors are *QueryError, which wrap ErrQuerySyntax.
+func ParseQuery(query string) (*StructuredQuery, error) {
+	s := &queryScanner{runes: []rune(query)}
+	res := new(StructuredQuery)
+	var free []string
+	freePos := -1
+	for {
+		s.skipSpaces()
+		if s.done() {
+			break
+		}
+
+		term := QueryTerm{Pos: s.pos}
+		if s.runes[s.pos] == '-' {
+			term.Negative = true
+			s.pos++
+			if s.done() || s.space() {
+				return nil, &QueryError{Pos: term.Pos, Reason: "empty exclusion"}
+			}
+		}
+
+		fieldPos := s.pos
+		if field, ok := s.field(); ok {
+			if _, ok := queryFields[field]; !ok || field == "" {
+				return nil, &QueryError{Pos: fieldPos, Reason: fmt.Sprintf("unknown field %q", field)}
+			}
+			term.Field = field
+		}
+
+		value, err := s.value()
+		if err != nil {
+			return nil, err
+		}
+		if value == "" {
+			if term.Field != "" {
+				return nil, &QueryError{Pos: fieldPos, Reason: fmt.Sprintf("empty value of field %q", term.Field)}
+			}
+			return nil, &QueryError{Pos: term.Pos, Reason: "empty term"}
+		}
+		term.Value = value
+
+		if term.Field == "" && !term.Negative {
+			if freePos < 0 {
+				freePos = term.Pos
+			}
+			free = append(free, value)
+			continue
+		}
+		res.Terms = append(res.Terms, term)
+	}
+
+	if len(free) != 0 {
+		term := QueryTerm{Value: strings.Join(free, " "), Pos: freePos}
+		res.Terms = append([]QueryTerm{term}, res.Terms...)
+	}
+	for _, term := range res.Terms {
+		if !term.Negative {
+			return res, nil
+		}
+	}
+	return nil, &QueryError{Pos: 0, Reason: "query has no positive terms"}
+}
+
+// Search by structured query.
+// Positive terms are intersected, then documents of negative terms are excluded.
+func doQuerySearch(
+	ctx context.Context,
+	engine *advancedEngine,
+	query string,
+	details *Details,
+) (Hypotheses, error) {
+	sq, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var hs Hypotheses
+	var excluded []Hypotheses
+	first := true
+	for _, term := range sq.Terms {
+		hss, err := queryFields[term.Field](ctx, engine, term.Value, details)
+		if err != nil {
+			return nil, fmt.Errorf("search (%s:%s): %w", term.Field, term.Value, err)
+		}
+		switch {
+		case term.Negative:
+			excluded = append(excluded, hss)
+		case first:
+			hs = hss
+			first = false
+		default:
+			hs = hs.intersect(hss)
+		}
+		if details.IsCancel() {
+			return nil, nil
+		}
+	}
+
+	// Every negative term is compared with its own best relevance.
+	// Matches below threshold of band are weak for any term.
+	for _, hss := range excluded {
+		hs = hs.exclude(hss, details.Band.Threshold)
+	}
+	return hs, nil
+}
@@ -0,0 +1,128 @@
+package parcels
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuery(t *testing.T) {
+	q, err := ParseQuery(`ибупрофен  400 maker:фармак -детский`)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		[]QueryTerm{
+			{Value: "ибупрофен 400", Pos: 0},
+			{Field: "maker", Value: "фармак", Pos: 15},
+			{Value: "детский", Negative: true, Pos: 28},
+		},
+		q.Terms,
+	)
+
+	q, err = ParseQuery(`INN:парацетамол barcode:4820000000000 -maker:"дарница фф" "таб 500"`)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		[]QueryTerm{
+			{Value: "таб 500", Pos: 58},
+			{Field: "inn", Value: "парацетамол", Pos: 0},
+			{Field: "barcode", Value: "4820000000000", Pos: 16},
+			{Field: "maker", Value: "дарница фф", Negative: true, Pos: 38},
+		},
+		q.Terms,
+	)
+
+	// Colon after non-letters is part of value
+	q, err = ParseQuery(`1:1`)
+	require.NoError(t, err)
+	assert.Equal(t, []QueryTerm{{Value: "1:1"}}, q.Terms)
+
+	for query, pos := range map[string]int{
+		`аспирин color:red`: 8,
+		`аспирин maker:`:    8,
+		`аспирин - кардио`:  8,
+		`"аспирин`:          0,
+		`name:"аспирин`:     5,
+		`-аспирин`:          0,
+		``:                  0,
+	} {
+		_, err := ParseQuery(query)
+		require.Error(t, err, query)
+		assert.True(t, errors.Is(err, ErrQuerySyntax), query)
+		var qerr *QueryError
+		require.True(t, errors.As(err, &qerr), query)
+		assert.Equal(t, pos, qerr.Pos, query)
+	}
+}
+
+func TestQuerySearchFirstTermEmpty(t *testing.T) {
+	ctx := context.Background()
+	docs := &mapDocManager{parcels: &parcelRepositoryMock{}}
+	engine := newEngineMock(t, docs, DefaultStrategyOptions())
+	require.NoError(t, engine.strategies.Append(ctx, &Doc{Id: 1, NameSearchIndex: "аспирин", Maker: "bayer"}))
+
+	details := &Details{
+		Band:   BandOptions{Capacity: 10},
+		Filter: &resolver{EntityFilterEx: entityFilterMock{}},
+	}
+	hs, err := doQuerySearch(ctx, engine, "name:аспирин", details)
+	require.NoError(t, err)
+	assert.Len(t, hs, 1)
+
+	// The first term finds nothing, so intersection is empty, even if method returns nil
+	barcode := queryFields["barcode"]
+	defer func() { queryFields["barcode"] = barcode }()
+	queryFields["barcode"] = func(ctx context.Context, engine *advancedEngine, query string, details *Details) (Hypotheses, error) {
+		return nil, nil
+	}
+	hs, err = doQuerySearch(ctx, engine, "barcode:4820000000000 name:аспирин", details)
+	require.NoError(t, err)
+	assert.Empty(t, hs)
+}
+
+// Get sorted identifiers of hypotheses
+func hypothesesIds(hs Hypotheses) []int64 {
+	res := make([]int64, 0, len(hs))
+	for id := range hs {
+		res = append(res, id)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+func TestQuerySearchIntersectExclude(t *testing.T) {
+	ctx := context.Background()
+	docs := &mapDocManager{parcels: &parcelRepositoryMock{}}
+	engine := newEngineMock(t, docs, DefaultStrategyOptions())
+	for _, doc := range []*Doc{
+		{Id: 2, NameSearchIndex: "аспирин кардио", Maker: "bayer"},
+		{Id: 4, NameSearchIndex: "аспирин", Maker: "teva"},
+		{Id: 8, NameSearchIndex: "аспаркам", Maker: "bayer"},
+	} {
+		require.NoError(t, engine.strategies.Append(ctx, doc))
+	}
+	search := func(query string, threshold float64) Hypotheses {
+		details := &Details{
+			Band:   BandOptions{Capacity: 10, Threshold: threshold},
+			Filter: &resolver{EntityFilterEx: entityFilterMock{}},
+		}
+		hs, err := doQuerySearch(ctx, engine, query, details)
+		require.NoError(t, err)
+		return hs
+	}
+
+	assert.Equal(t, []int64{2, 4}, hypothesesIds(search("аспирин", 0)))
+	assert.Equal(t, []int64{2, 8}, hypothesesIds(search("maker:bayer", 0)))
+	assert.Equal(t, []int64{2}, hypothesesIds(search("аспирин maker:bayer", 0)))
+	assert.Equal(t, []int64{4}, hypothesesIds(search("аспирин -кардио", 0)))
+	assert.Equal(t, []int64{4}, hypothesesIds(search("-кардио аспирин", 0)))
+
+	// The only match of misspelled negative term is the best one, but it is weak for threshold
+	assert.Equal(t, []int64{4}, hypothesesIds(search("аспирин -кардиа", 0)))
+	assert.Equal(t, []int64{2, 4}, hypothesesIds(search("аспирин -кардиа", 0.3)))
+	assert.Equal(t, []int64{4}, hypothesesIds(search("аспирин -кардио", 0.3)))
+}
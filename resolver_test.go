@@ -0,0 +1,64 @@
+package parcels
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingRule counts searches of nested rule
+type countingRule struct {
+	Derivative
+	count int
+}
+
+func (rule *countingRule) Search(
+	ctx context.Context,
+	resolver Resolver,
+	query []rune,
+	weight float64,
+	details *Details,
+) Hypotheses {
+	rule.count++
+	return Hypotheses{int64(len(query)): weight}
+}
+
+func TestResolverCache(t *testing.T) {
+	ctx := context.Background()
+	shared := &countingRule{
+		Derivative: Derivative{
+			Identifier: Identifier{NameVal: "shared"},
+			Rule:       newBuilderTestRule(),
+		},
+	}
+	root := NewMultiRule(
+		"root",
+		Entries{
+			"a": &Entry{Rule: shared, Weight: 1},
+			"b": &Entry{Rule: NewFilterRule("b", shared), Weight: 0.5},
+			"c": &Entry{Rule: NewMuteRule("c", nil, nil, shared), Weight: 0.5},
+		},
+		NewMaxEstimator(),
+		NewSumMixer(),
+	)
+
+	res := &resolver{cache: make(map[resolverKey]Hypotheses)}
+	details := &Details{}
+	hs := res.Resolve(ctx, root, []rune("abc"), 1, details)
+	assert.Equal(t, Hypotheses{3: 2}, hs)
+	assert.Equal(t, 1, shared.count)
+	assert.Equal(t, ResolverStatistics{Hits: 2, Misses: 4}, res.Statistics())
+
+	// Weight is the part of key
+	hs = res.Resolve(ctx, shared, []rune("abc"), 0.5, details)
+	assert.Equal(t, Hypotheses{3: 0.5}, hs)
+	assert.Equal(t, 2, shared.count)
+
+	// Query is the part of key
+	_ = res.Resolve(ctx, shared, []rune("ab"), 1, details)
+	assert.Equal(t, 3, shared.count)
+	_ = res.Resolve(ctx, shared, []rune("abc"), 1, details)
+	assert.Equal(t, 3, shared.count)
+	assert.Equal(t, ResolverStatistics{Hits: 3, Misses: 6}, res.Statistics())
+}
@@ -5,9 +5,10 @@ import (
 	"encoding/gob"
 	"fmt"
 	"math"
-	"sort"
 	"spWebFront/FrontKeeper/infrastructure/log"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // https://habr.com/en/post/114997/
@@ -37,6 +38,11 @@ func (d *Derivative) Name() string {
 	return d.NameVal
 }
 
+// Children is getter for reading nested rule.
+func (d *Derivative) Children() []Rule {
+	return []Rule{d.Rule}
+}
+
 // MultiRule is abstract rule for combine multiple entries.
 type MultiRule struct {
 	Identifier
@@ -61,25 +67,52 @@ func (rule *MultiRule) Search(
 	weight float64,
 	details *Details,
 ) Hypotheses {
-	var bs []branch
-	for _, e := range rule.Entries {
+	// Empty branches are passed to mixer too, so intersection of branches is empty
+	bs := make([]branch, 0, len(rule.Entries))
+	for name, e := range rule.Entries {
 		h := resolver.Resolve(ctx, e, query, weight, details)
-		if len(h) == 0 {
-			continue
-		}
 		w := details.getWeight(e.Name(), e.Weight)
 		h1 := h.scale(w)
 		bs = append(
 			bs,
 			branch{
 				hypotheses: h1,
-				relevance:  rule.Estimator.Estimate(h1),
+				relevance:  estimate(ctx, rule.Estimator, e, query, h1),
+				name:       name,
+				weight:     w,
 			},
 		)
 	}
+	if trace := explainTraceFrom(ctx); trace != nil {
+		trace.mix(rule.Mixer, bs)
+	}
 	return rule.Mixer.Mix(bs)
 }
 
+func (rule *MultiRule) Children() []Rule {
+	res := make([]Rule, 0, len(rule.Entries))
+	for _, e := range rule.Entries {
+		res = append(res, e.Rule)
+	}
+	return res
+}
+
+func (rule *MultiRule) BeginBuild(ctx context.Context) {
+	for _, e := range rule.Entries {
+		e.BeginBuild(ctx)
+	}
+}
+
+func (rule *MultiRule) Commit(ctx context.Context) error {
+	for _, e := range rule.Entries {
+		err := e.Commit(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (rule *MultiRule) Log() {
 	for _, e := range rule.Entries {
 		e.Log()
@@ -103,10 +136,14 @@ func (rule *MultiRule) Append(
 	id int64,
 	name []rune,
 	weight float64,
-) {
+) error {
 	for _, e := range rule.Entries {
-		e.Append(ctx, id, name, weight)
+		err := e.Append(ctx, id, name, weight)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 func (rule *MultiRule) Remove(
@@ -156,10 +193,11 @@ func (rule *GuardRule) Append(
 	id int64,
 	name []rune,
 	weight float64,
-) {
+) error {
 	if rule.PredicateBuild.Test(ctx, name) {
-		rule.Rule.Append(ctx, id, name, weight)
+		return rule.Rule.Append(ctx, id, name, weight)
 	}
+	return nil
 }
 
 func (rule *GuardRule) Remove(
@@ -230,10 +268,10 @@ func (rule *muteRule) Append(
 	id int64,
 	name []rune,
 	weight float64,
-) {
+) error {
 	name2 := rule.MutatorBuild.Mute(ctx, name)
 	ww := calcWeight(string(name), string(name2))
-	rule.Rule.Append(ctx, id, name2, weight*ww)
+	return rule.Rule.Append(ctx, id, name2, weight*ww)
 }
 
 func (rule *muteRule) Remove(
@@ -281,15 +319,30 @@ func NewMuteRule(
 	}
 }
 
-// NgramId is internal ngram identifier
-type NgramId uint16
-
 // NgramIndexStatisctics is statistics for ngram index
 type NgramIndexStatisctics struct {
-	Count int     // Document count
-	Refs  int     // Summary references count
-	MaxId NgramId // Identifier with max V
-	MaxV  int     // Max value
+	Count   int     // Document count
+	Refs    int     // Summary references count
+	MaxId   NgramId // Identifier with max V
+	MaxV    int     // Max value
+	Size    int     // Memory of posting lists in bytes
+	RawSize int     // Memory of the same posting lists without compression
+}
+
+// Memory of posting lists per document in bytes
+func (stats NgramIndexStatisctics) SizePerDoc() float64 {
+	if stats.Count == 0 {
+		return 0
+	}
+	return float64(stats.Size) / float64(stats.Count)
+}
+
+// Memory of uncompressed posting lists per document in bytes
+func (stats NgramIndexStatisctics) RawSizePerDoc() float64 {
+	if stats.Count == 0 {
+		return 0
+	}
+	return float64(stats.RawSize) / float64(stats.Count)
 }
 
 // NgramIndex is abstract ngram index
@@ -305,20 +358,44 @@ type NgramIndex interface {
 	Statistics() NgramIndexStatisctics
 	// Search and append new hypotheses
 	Search(query []NgramEntry, weight float64) Hypotheses
+	// Search only top documents. Documents out of top are not returned.
+	SearchTop(query []NgramEntry, weight float64, limit int) Hypotheses
+	// Load index from decoder
+	Load(dec *gob.Decoder) error
+	// Save index to encoder
+	Save(enc *gob.Encoder) error
+	// Begin batch build. Index is rebuilt from scratch and appended documents become visible after commit.
+	BeginBuild()
+	// Commit batch build
+	Commit()
 }
 
 // NgramIndexPositions is position infor for ngram index
 type NgramIndexPositions struct {
-	Weight    float64 // Summary weight [0..1]
-	Query     float64 // Weight of query [0..1]
-	Pattern   float64 // Weight of pattern [0..1]
-	Inflation float64 // Speed of inflation [0..1]
-}
-
+	Weight    float64           // Summary weight [0..1]
+	Query     float64           // Weight of query [0..1]
+	Pattern   float64           // Weight of pattern [0..1]
+	Inflation float64           // Speed of inflation [0..1]
+	Scoring   NgramIndexScoring // Weights of query ngrams
+	// Search only documents, that can enter the band. It is exact for max mixing
+	// of rules, but can miss documents, when results of rules are summed.
+	TopK bool
+	// Store posting lists compressed. Weights are quantized to 16 bits,
+	// so relevance may differ from uncompressed index in the last digits.
+	Compressed bool
+}
+
+// ngramIndex is copy-on-write ngram index.
+// Readers always work with immutable published segment,
+// writers prepare new segment and publish it atomically.
 type ngramIndex struct {
-	Items    map[NgramId][]Ref
-	Position NgramIndexPositions
-	docs     DocManager
+	sync.Mutex                     // Writers lock
+	head       atomic.Value        // Published segment (*ngramSegment)
+	forward    map[int64][]NgramId // Forward index: document -> ngrams (guarded by writers lock)
+	builder    *ngramBuilder       // Batch builder (guarded by writers lock)
+	Position   NgramIndexPositions // Position info
+	codec      postingCodec        // Representation of posting lists
+	docs       DocManager
 }
 
 func (index *ngramIndex) Clone() NgramIndex {
@@ -326,21 +403,50 @@ func (index *ngramIndex) Clone() NgramIndex {
 }
 
 func (index *ngramIndex) Purge() {
-	index.Items = make(map[NgramId][]Ref)
+	index.Lock()
+	defer index.Unlock()
+	if index.builder != nil {
+		index.builder = newNgramBuilder()
+		return
+	}
+	index.head.Store(newNgramSegment())
+	index.forward = make(map[int64][]NgramId, 1024)
+}
+
+func (index *ngramIndex) BeginBuild() {
+	index.Lock()
+	defer index.Unlock()
+	if index.builder == nil {
+		index.builder = newNgramBuilder()
+	}
+}
+
+func (index *ngramIndex) Commit() {
+	index.Lock()
+	defer index.Unlock()
+	if index.builder == nil {
+		return
+	}
+	index.head.Store(index.builder.segment(index.codec))
+	index.forward = index.builder.forward
+	index.builder = nil
 }
 
 func (index *ngramIndex) Statistics() (res NgramIndexStatisctics) {
 	docs := make(map[int64]bool, 16384)
-	for n, item := range index.Items {
-		res.Refs += len(item)
-		if len(item) > res.MaxV {
-			res.MaxV = len(item)
+	index.segment().forEach(func(n NgramId, item postingList) {
+		count := item.Len()
+		res.Refs += count
+		res.Size += item.Size()
+		res.RawSize += count * refSize
+		if count > res.MaxV {
+			res.MaxV = count
 			res.MaxId = n
 		}
-		for _, doc := range item {
-			docs[doc.Doc] = true
-		}
-	}
+		item.ForEach(func(r Ref) {
+			docs[r.Doc] = true
+		})
+	})
 	res.Count = len(docs)
 	return
 }
@@ -350,45 +456,129 @@ func (index *ngramIndex) Append(
 	ngrams []NgramEntry,
 	weight float64,
 ) {
-	for _, n := range ngrams {
-		index.append(
-			n.Id,
-			Ref{
-				Doc:    id,
-				Pos:    n.Pos,
-				Weight: weight,
-			},
-		)
+	index.Lock()
+	defer index.Unlock()
+
+	if index.builder != nil {
+		index.builder.add(id, ngrams, weight)
+		return
 	}
+
+	index.update(func(draft *ngramDraft) {
+		// Replace existing document
+		index.unlink(draft, id)
+
+		entries := distinctNgrams(ngrams)
+		ids := make([]NgramId, len(entries))
+		for i, n := range entries {
+			ids[i] = n.Id
+			index.append(
+				draft,
+				n.Id,
+				Ref{
+					Doc:    id,
+					Pos:    n.Pos,
+					Weight: weight,
+					Len:    refLen(len(entries)),
+				},
+			)
+		}
+
+		if len(ids) != 0 {
+			index.forward[id] = ids
+			draft.docs++
+			draft.refs += len(ids)
+		}
+	})
 }
 
 func (index *ngramIndex) Remove(id int64) {
-	res := make(map[NgramId][]Ref, len(index.Items))
-	for key, refs := range index.Items {
-		res[key] = refsExclude(refs, id)
+	index.Lock()
+	defer index.Unlock()
+
+	if index.builder != nil {
+		index.builder.remove(id)
+		return
+	}
+
+	index.update(func(draft *ngramDraft) {
+		index.unlink(draft, id)
+	})
+}
+
+func (index *ngramIndex) Load(dec *gob.Decoder) error {
+	items := make(map[NgramId][]Ref)
+	err := dec.Decode(&items)
+	if err != nil {
+		return fmt.Errorf("Decode: %w", err)
+	}
+	forward := make(map[int64][]NgramId, 1024)
+	for n, refs := range items {
+		for _, r := range refs {
+			forward[r.Doc] = append(forward[r.Doc], n)
+		}
+	}
+	// Snapshots before version 3 have no length of document
+	for _, refs := range items {
+		for i := range refs {
+			if refs[i].Len == 0 {
+				refs[i].Len = refLen(len(forward[refs[i].Doc]))
+			}
+		}
+	}
+
+	index.Lock()
+	defer index.Unlock()
+	index.head.Store(newNgramSegmentFromItems(items, forward, index.codec))
+	index.forward = forward
+	return nil
+}
+
+func (index *ngramIndex) Save(enc *gob.Encoder) error {
+	err := enc.Encode(index.segment().items())
+	if err != nil {
+		return fmt.Errorf("Encode: %w", err)
 	}
-	index.Items = res
+	return nil
+}
+
+// Get published segment
+func (index *ngramIndex) segment() *ngramSegment {
+	return index.head.Load().(*ngramSegment)
+}
+
+// Modify index and publish new segment. Must be called under writers lock.
+func (index *ngramIndex) update(action func(draft *ngramDraft)) {
+	draft := newNgramDraft(index.segment(), index.codec)
+	action(draft)
+	index.head.Store(draft.commit())
 }
 
-func (index *ngramIndex) append(ngram NgramId, ref Ref) {
-	if rs, ok := index.Items[ngram]; ok {
-		index.Items[ngram] = refsInclude(rs, ref)
+func (index *ngramIndex) append(draft *ngramDraft, ngram NgramId, ref Ref) {
+	if rs, ok := draft.find(ngram); ok {
+		draft.set(ngram, refsInclude(rs.Refs(), ref))
 	} else {
-		index.Items[ngram] = []Ref{ref}
+		draft.set(ngram, []Ref{ref})
 	}
 }
 
-func (index *ngramIndex) remove(ngram NgramId, doc int64) {
-	if rs, ok := index.Items[ngram]; ok {
-		l := len(rs)
-		i := sort.Search(l, func(i int) bool { return rs[i].Doc >= doc })
-		if i < l {
-			if len(rs) == 1 {
-				delete(index.Items, ngram)
-			} else {
-				index.Items[ngram] = append(rs[:i], rs[i+1:]...)
-			}
-		}
+// Remove document from all posting lists, that contain it.
+func (index *ngramIndex) unlink(draft *ngramDraft, doc int64) {
+	ids, ok := index.forward[doc]
+	if !ok {
+		return
+	}
+	for _, n := range ids {
+		index.remove(draft, n, doc)
+	}
+	delete(index.forward, doc)
+	draft.docs--
+	draft.refs -= len(ids)
+}
+
+func (index *ngramIndex) remove(draft *ngramDraft, ngram NgramId, doc int64) {
+	if rs, ok := draft.find(ngram); ok {
+		draft.set(ngram, refsExclude(rs.Refs(), doc))
 	}
 }
 
@@ -405,12 +595,17 @@ func (index *ngramIndex) Search(
 		rel float64
 	}
 	raw := make(map[int64]*Raw, 8192)
-	rel := 1 / float64(len(query))
-	for _, ngram := range query {
-		if rs, ok := index.Items[ngram.Id]; ok {
-			for _, r := range rs {
+	segment := index.segment()
+	scoring := index.Position.Scoring
+	weights := scoring.weights(segment, query)
+	avgLen := segment.avgLen()
+	for i, ngram := range query {
+		rel := weights[i]
+		if rs, ok := segment.find(ngram.Id); ok {
+			// Posting list is decoded lazily, reference by reference
+			rs.ForEach(func(r Ref) {
 				pos := index.Position.Pattern*float64(r.Pos) + index.Position.Query*float64(ngram.Pos)
-				rl := rel * (0.5*r.Weight + 0.5*weight)
+				rl := rel * scoring.norm(r.Len, avgLen) * (0.5*r.Weight + 0.5*weight)
 				if rr, ok := raw[r.Doc]; ok {
 					rr.rel += rl
 					rr.pos += pos
@@ -420,86 +615,204 @@ func (index *ngramIndex) Search(
 						rel: rl,
 					}
 				}
-			}
+			})
 		}
 	}
 
 	hs := newHypotheses()
-	posWeight := index.Position.Weight
 	for doc, r := range raw {
-		sss := math.Pow(10, float64(index.Position.Inflation))
-		pos := 1 / math.Pow(sss, float64(r.pos))
-		rel := r.rel*(1-posWeight) + pos*posWeight
-		hs[doc] = rel
+		hs[doc] = index.relevance(r.rel, r.pos)
 	}
 
 	return hs
 }
 
+// Mix relevance of ngrams with summary position of ngrams
+func (index *ngramIndex) relevance(rel, pos float64) float64 {
+	posWeight := index.Position.Weight
+	sss := math.Pow(10, float64(index.Position.Inflation))
+	p := 1 / math.Pow(sss, float64(pos))
+	return rel*(1-posWeight) + p*posWeight
+}
+
 // NewNgramIndex is constructor for creating instance of ngram index.
 func NewNgramIndex(
 	docs DocManager,
 	positions NgramIndexPositions,
 ) NgramIndex {
 	positions.Pattern = 1 - positions.Query
-	return &ngramIndex{
-		Items:    make(map[NgramId][]Ref, 32768),
+	codec := newRefList
+	if positions.Compressed {
+		codec = newPackedRefs
+	}
+	index := &ngramIndex{
+		forward:  make(map[int64][]NgramId, 1024),
 		Position: positions,
+		codec:    codec,
+		docs:     docs,
 	}
+	index.head.Store(newNgramSegment())
+	return index
 }
 
 // NgramRule is rule for ngram search.
 type ngramRule struct {
 	Identifier
-	Parser NgramParser // ngrams
-	Index  NgramIndex  // backward index
+	Parser NgramParser  // ngrams
+	Index  NgramIndex   // backward index
+	mx     sync.RWMutex // guards Parser, Index and build
+	build  *ngramBuild  // batch build, nil if inactive
+}
+
+// Batch build of ngram rule. Parser and index are published together on commit.
+type ngramBuild struct {
+	parser NgramParser // parser, which fills new dictionary if parser supports it
+	index  NgramIndex  // index of batch build
+}
+
+// Get published parser and index, which are consistent with each other.
+func (rule *ngramRule) current() (NgramParser, NgramIndex) {
+	rule.mx.RLock()
+	defer rule.mx.RUnlock()
+	return rule.Parser, rule.Index
 }
 
 func (rule *ngramRule) Clone() Rule {
+	parser, index := rule.current()
 	return NewNgramRule(
 		rule.Identifier.NameVal,
-		rule.Index.Clone(),
-		rule.Parser,
+		index.Clone(),
+		parser,
 	)
 }
 
+func (rule *ngramRule) Children() []Rule {
+	return nil
+}
+
+func (rule *ngramRule) Load(dec *gob.Decoder) error {
+	rule.mx.Lock()
+	defer rule.mx.Unlock()
+	err := rule.Parser.Load(dec)
+	if err != nil {
+		return fmt.Errorf("Parser.Load: %w", err)
+	}
+	err = rule.Index.Load(dec)
+	if err != nil {
+		return fmt.Errorf("Index.Load: %w", err)
+	}
+	return nil
+}
+
+func (rule *ngramRule) Save(enc *gob.Encoder) error {
+	rule.mx.RLock()
+	defer rule.mx.RUnlock()
+	err := rule.Parser.Save(enc)
+	if err != nil {
+		return fmt.Errorf("Parser.Save: %w", err)
+	}
+	err = rule.Index.Save(enc)
+	if err != nil {
+		return fmt.Errorf("Index.Save: %w", err)
+	}
+	return nil
+}
+
 func (rule *ngramRule) Log() {
 	log.DebugFunc(func() {
-		stats := rule.Index.Statistics()
-		s := rule.Parser.Find(stats.MaxId)
+		parser, index := rule.current()
+		stats := index.Statistics()
+		s := parser.Find(stats.MaxId)
 		log.Printf(
-			"STATISTICS FOR NGRAM RULE %s: dictionary size = %d, document count = %d, entry count = %d, Ngram max = (%s, %d)",
+			"STATISTICS FOR NGRAM RULE %s: dictionary size = %d, document count = %d, entry count = %d, Ngram max = (%s, %d), bytes per document = %.1f (raw %.1f)",
 			rule.NameVal,
-			rule.Parser.Count(),
+			parser.Count(),
 			stats.Count,
 			stats.Refs,
 			s,
 			stats.MaxV,
+			stats.SizePerDoc(),
+			stats.RawSizePerDoc(),
 		)
 	})
 }
 
 func (rule *ngramRule) Purge(ctx context.Context) error {
+	rule.mx.Lock()
+	defer rule.mx.Unlock()
+	if rule.build != nil {
+		// Published index still refers to its dictionary during batch build
+		if rule.build.parser != rule.Parser {
+			rule.build.parser.Purge()
+		}
+		rule.build.index.Purge()
+		return nil
+	}
 	rule.Parser.Purge()
 	rule.Index.Purge()
 	return nil
 }
 
+// BeginBuild starts batch build into new index. Documents are parsed into new dictionary,
+// so ngrams of removed documents don't survive rebuild.
+func (rule *ngramRule) BeginBuild(ctx context.Context) {
+	rule.mx.Lock()
+	defer rule.mx.Unlock()
+	if rule.build != nil {
+		return
+	}
+	parser := rule.Parser
+	if p, ok := parser.(ngramRebuilder); ok {
+		parser = p.withDictionary(NewNgramDictionary(p.dictionary().Limit))
+	}
+	index := rule.Index.Clone()
+	index.BeginBuild()
+	rule.build = &ngramBuild{parser: parser, index: index}
+}
+
+// Commit publishes parser and index of batch build at once.
+func (rule *ngramRule) Commit(ctx context.Context) error {
+	rule.mx.Lock()
+	defer rule.mx.Unlock()
+	if rule.build == nil {
+		return nil
+	}
+	rule.build.index.Commit()
+	rule.Parser, rule.Index = rule.build.parser, rule.build.index
+	rule.build = nil
+	return nil
+}
+
 func (rule *ngramRule) Append(
 	ctx context.Context,
 	id int64,
 	name []rune,
 	weight float64,
-) {
-	ngrams := rule.Parser.Parse(ctx, name, true)
-	rule.Index.Append(id, ngrams, weight)
+) error {
+	rule.mx.Lock()
+	defer rule.mx.Unlock()
+	parser, index := rule.Parser, rule.Index
+	if rule.build != nil {
+		parser, index = rule.build.parser, rule.build.index
+	}
+	ngrams, err := parser.Parse(ctx, name, true)
+	if err != nil {
+		return fmt.Errorf("rule %q: %w", rule.NameVal, err)
+	}
+	index.Append(id, ngrams, weight)
+	return nil
 }
 
 func (rule *ngramRule) Remove(
 	ctx context.Context,
 	id int64,
 ) {
+	rule.mx.Lock()
+	defer rule.mx.Unlock()
 	rule.Index.Remove(id)
+	if rule.build != nil {
+		rule.build.index.Remove(id)
+	}
 }
 
 func (rule *ngramRule) Search(
@@ -509,7 +822,8 @@ func (rule *ngramRule) Search(
 	weight float64,
 	details *Details,
 ) Hypotheses {
-	ngrams := rule.Parser.Parse(ctx, query, false)
+	parser, index := rule.current()
+	ngrams, _ := parser.Parse(ctx, query, false)
 	if debug {
 		var lst []string
 		for _, n := range ngrams {
@@ -518,7 +832,33 @@ func (rule *ngramRule) Search(
 		log.Debugf("SEARCH BY NGRAM RULE %q FOR QUERY %q HAS NGRAMS=%d {%s}", rule.NameVal, string(query), len(ngrams), strings.Join(lst, ", "))
 	}
 
-	return rule.Index.Search(ngrams, weight)
+	// Pages continue beyond capacity of band and facets count all matched documents, so they need all documents
+	if details != nil && pageStateFrom(ctx) == nil && facetStateFrom(ctx) == nil {
+		return searchTop(ctx, index, ngrams, weight, details.Band.Capacity)
+	}
+	return index.Search(ngrams, weight)
+}
+
+// Search documents, that can enter band of capacity.
+// Top is over-fetched for entity filter, and its truncation is reported into top-k state.
+func searchTop(
+	ctx context.Context,
+	index NgramIndex,
+	ngrams []NgramEntry,
+	weight float64,
+	capacity int,
+) Hypotheses {
+	state := topKStateFrom(ctx)
+	if state != nil && state.full {
+		return index.Search(ngrams, weight)
+	}
+	limit := capacity * topKOverfetch
+	hs := index.SearchTop(ngrams, weight, limit)
+	if index, ok := index.(*ngramIndex); ok && state != nil &&
+		index.Position.TopK && limit > 0 && len(hs) >= limit {
+		state.truncated = true
+	}
+	return hs
 }
 
 // NewNgramRule is constructor for creating instance of NgramRule
@@ -536,6 +876,28 @@ func NewNgramRule(
 	}
 }
 
+// Get distinct ngrams of document. First occurrence of ngram wins.
+func distinctNgrams(ngrams []NgramEntry) []NgramEntry {
+	res := make([]NgramEntry, 0, len(ngrams))
+	ids := make([]NgramId, 0, len(ngrams))
+	for _, n := range ngrams {
+		if indexOfNgram(ids, n.Id) != -1 {
+			continue
+		}
+		ids = append(ids, n.Id)
+		res = append(res, n)
+	}
+	return res
+}
+
+// Convert count of ngrams to reference length
+func refLen(n int) int16 {
+	if n > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	return int16(n)
+}
+
 func indexOfNgram(ns []NgramId, n NgramId) int {
 	for i, v := range ns {
 		if v == n {
@@ -636,11 +998,15 @@ func (rule *layoutRule) Search(
 	weight float64,
 	details *Details,
 ) Hypotheses {
-	hs := make([]Hypotheses, 0, len(rule.Layouts)+1)
+	type candidate struct {
+		query []rune
+		hs    Hypotheses
+	}
+	cs := make([]candidate, 0, len(rule.Layouts)+1)
 
 	// Выполняем поиск без преобразования символов
 	if h := resolver.Resolve(ctx, rule.Rule, query, weight, details); h != nil {
-		hs = append(hs, h)
+		cs = append(cs, candidate{query, h})
 	}
 
 	// Выполняем поиск для каждой из схем преобразования символов
@@ -652,18 +1018,18 @@ func (rule *layoutRule) Search(
 		w := calcWeight(string(query), string(q))
 		if h := resolver.Resolve(ctx, rule.Rule, q, weight*w, details); h != nil {
 			h = h.scale(rule.Weight)
-			hs = append(hs, h)
+			cs = append(cs, candidate{q, h})
 		}
 	}
 
 	// Выполняем поиск лучшего набора гипотез
 	br := float64(0)
 	var bh Hypotheses
-	for _, h := range hs {
-		r := rule.Estimator.Estimate(h)
+	for _, c := range cs {
+		r := estimate(ctx, rule.Estimator, rule.Rule, c.query, c.hs)
 		if br < r {
 			br = r
-			bh = h
+			bh = c.hs
 		}
 	}
 
@@ -0,0 +1,82 @@
+package parcels
+
+import (
+	"fmt"
+	"math"
+)
+
+// NgramScoring is model of ngram contribution to relevance of document
+type NgramScoring int
+
+const (
+	NgramScoringUniform NgramScoring = iota // Every query ngram has equal weight
+	NgramScoringIdf                         // Query ngrams are weighted by inverse document frequency
+	NgramScoringBM25                        // IDF with saturation by document length
+)
+
+var ngramScoringNames = map[string]NgramScoring{
+	"":        NgramScoringUniform,
+	"uniform": NgramScoringUniform,
+	"idf":     NgramScoringIdf,
+	"bm25":    NgramScoringBM25,
+}
+
+// ParseNgramScoring converts name of scoring model to value
+func ParseNgramScoring(name string) (NgramScoring, error) {
+	if scoring, ok := ngramScoringNames[name]; ok {
+		return scoring, nil
+	}
+	return NgramScoringUniform, fmt.Errorf("unknown ngram scoring %q", name)
+}
+
+// NgramIndexScoring is scoring info for ngram index
+type NgramIndexScoring struct {
+	Model NgramScoring // Scoring model
+	K1    float64      // BM25 saturation [0..]
+	B     float64      // BM25 length normalization [0..1]
+}
+
+// Get weights of query ngrams. Sum of weights is 1, so relevance stays in [0..1].
+func (scoring NgramIndexScoring) weights(seg *ngramSegment, query []NgramEntry) []float64 {
+	res := make([]float64, len(query))
+	if scoring.Model == NgramScoringUniform {
+		for i := range res {
+			res[i] = 1 / float64(len(query))
+		}
+		return res
+	}
+
+	var sum float64
+	for i, ngram := range query {
+		var df int
+		if rs, ok := seg.find(ngram.Id); ok {
+			df = rs.Len()
+		}
+		res[i] = ngramIdf(seg.docs, df)
+		sum += res[i]
+	}
+	for i := range res {
+		res[i] /= sum
+	}
+	return res
+}
+
+// Get factor of document length [0..1]. Shortest documents have factor 1.
+func (scoring NgramIndexScoring) norm(length int16, avgLen float64) float64 {
+	if scoring.Model != NgramScoringBM25 || avgLen == 0 {
+		return 1
+	}
+
+	// Length is unknown for snapshots of previous versions
+	dl := avgLen
+	if length > 0 {
+		dl = float64(length)
+	}
+	k1, b := scoring.K1, scoring.B
+	return (1 + k1*(1-b)) / (1 + k1*(1-b+b*dl/avgLen))
+}
+
+// BM25 inverse document frequency. It is always positive, even for ngrams of most documents.
+func ngramIdf(docs, df int) float64 {
+	return math.Log(1 + (float64(docs-df)+0.5)/(float64(df)+0.5))
+}
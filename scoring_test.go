@@ -0,0 +1,69 @@
+package parcels
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNgramScoring(t *testing.T) {
+	for name, expected := range map[string]NgramScoring{
+		"":        NgramScoringUniform,
+		"uniform": NgramScoringUniform,
+		"idf":     NgramScoringIdf,
+		"bm25":    NgramScoringBM25,
+	} {
+		actual, err := ParseNgramScoring(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, expected, actual, name)
+	}
+
+	_, err := ParseNgramScoring("tfidf")
+	assert.Error(t, err)
+
+	// Unknown scoring is error of strategy
+	invalid := DefaultStrategyOptions()
+	invalid.Ngrams.Scoring.Model = "tfidf"
+	_, err = NewStrategyDefault(nil, invalid, docNameSearchIndexReader)
+	assert.Error(t, err)
+	_, err = NewStrategyByOptions(nil, invalid, docNameSearchIndexReader)
+	assert.Error(t, err)
+}
+
+func TestNgramIndexScoring(t *testing.T) {
+	newIndex := func(model NgramScoring) *ngramIndex {
+		index := NewNgramIndex(
+			nil,
+			NgramIndexPositions{
+				Scoring: NgramIndexScoring{Model: model, K1: 1.2, B: 0.75},
+			},
+		).(*ngramIndex)
+
+		// Ngram 1 is common, ngram 2 is rare
+		index.Append(1, []NgramEntry{{Id: 1}}, 1)
+		index.Append(2, []NgramEntry{{Id: 2}}, 1)
+		index.Append(3, []NgramEntry{{Id: 1}}, 1)
+		index.Append(4, []NgramEntry{{Id: 1}, {Id: 3, Pos: 1}, {Id: 4, Pos: 2}}, 1)
+		return index
+	}
+	query := []NgramEntry{{Id: 1}, {Id: 2, Pos: 1}}
+
+	hs := newIndex(NgramScoringUniform).Search(query, 1)
+	assert.InDelta(t, hs[1], hs[2], 1e-9)
+	assert.InDelta(t, 0.5, hs[2], 1e-9)
+
+	hs = newIndex(NgramScoringIdf).Search(query, 1)
+	assert.Greater(t, hs[2], hs[1])
+	assert.InDelta(t, hs[1], hs[4], 1e-9)
+	assert.InDelta(t, 1, hs[1]+hs[2], 1e-9)
+
+	// Long document is penalized
+	hs = newIndex(NgramScoringBM25).Search(query, 1)
+	assert.Greater(t, hs[2], hs[1])
+	assert.Greater(t, hs[1], hs[4])
+
+	seg := newIndex(NgramScoringUniform).segment()
+	assert.Equal(t, 4, seg.docs)
+	assert.Equal(t, 6, seg.refs)
+}
@@ -0,0 +1,206 @@
+package parcels
+
+// Count of shards in ngram segment.
+const ngramShardCount = 256
+
+// Changes of posting lists are kept in layers over shards, so write copies only changed lists.
+// Layers are merged, when their count exceeds ngramLayerLimit,
+// and merged layer is folded into shards, when it exceeds ngramOverlayLimit lists.
+const (
+	ngramLayerLimit   = 8
+	ngramOverlayLimit = 1 << 16
+)
+
+// ngramLayer is posting lists, changed by single draft. Nil list is removed list.
+type ngramLayer map[NgramId]postingList
+
+// ngramSegment is immutable version of ngram index.
+// Once published, segment and its posting lists are never modified,
+// so readers can use it without any locks.
+type ngramSegment struct {
+	shards [ngramShardCount]map[NgramId]postingList
+	layers []ngramLayer // Changes over shards, the newest layer is the last
+	docs   int          // Count of documents
+	refs   int          // Count of references
+}
+
+// Average count of distinct ngrams in document
+func (seg *ngramSegment) avgLen() float64 {
+	if seg.docs == 0 {
+		return 0
+	}
+	return float64(seg.refs) / float64(seg.docs)
+}
+
+// Find posting list of ngram
+func (seg *ngramSegment) find(id NgramId) (postingList, bool) {
+	for i := len(seg.layers) - 1; i >= 0; i-- {
+		if rs, ok := seg.layers[i][id]; ok {
+			return rs, rs != nil
+		}
+	}
+	rs, ok := seg.shards[id%ngramShardCount][id]
+	return rs, ok
+}
+
+// Iterate over all posting lists
+func (seg *ngramSegment) forEach(action func(id NgramId, refs postingList)) {
+	overlay := seg.overlay()
+	for _, shard := range seg.shards {
+		for id, refs := range shard {
+			if _, ok := overlay[id]; !ok {
+				action(id, refs)
+			}
+		}
+	}
+	for id, refs := range overlay {
+		if refs != nil {
+			action(id, refs)
+		}
+	}
+}
+
+// Merge layers into single layer
+func (seg *ngramSegment) overlay() ngramLayer {
+	if len(seg.layers) == 1 {
+		return seg.layers[0]
+	}
+	var count int
+	for _, layer := range seg.layers {
+		count += len(layer)
+	}
+	res := make(ngramLayer, count)
+	for _, layer := range seg.layers {
+		for id, refs := range layer {
+			res[id] = refs
+		}
+	}
+	return res
+}
+
+// Merge all shards into single map
+func (seg *ngramSegment) items() map[NgramId][]Ref {
+	var count int
+	for _, shard := range seg.shards {
+		count += len(shard)
+	}
+
+	res := make(map[NgramId][]Ref, count)
+	seg.forEach(func(id NgramId, refs postingList) {
+		res[id] = refs.Refs()
+	})
+	return res
+}
+
+func newNgramSegment() *ngramSegment {
+	seg := new(ngramSegment)
+	for i := range seg.shards {
+		seg.shards[i] = make(map[NgramId]postingList)
+	}
+	return seg
+}
+
+func newNgramSegmentFromItems(
+	items map[NgramId][]Ref,
+	forward map[int64][]NgramId,
+	codec postingCodec,
+) *ngramSegment {
+	seg := newNgramSegment()
+	seg.docs = len(forward)
+	for _, ids := range forward {
+		seg.refs += len(ids)
+	}
+	for id, refs := range items {
+		if len(refs) != 0 {
+			seg.shards[id%ngramShardCount][id] = codec(refs)
+		}
+	}
+	return seg
+}
+
+// ngramDraft is writable version of segment.
+// Draft holds only changed posting lists, so cost of the draft
+// depends only on the count of affected ngrams.
+type ngramDraft struct {
+	base    *ngramSegment
+	changes ngramLayer
+	docs    int
+	refs    int
+	codec   postingCodec
+}
+
+// Find posting list of ngram, including changes of the draft
+func (draft *ngramDraft) find(id NgramId) (postingList, bool) {
+	if rs, ok := draft.changes[id]; ok {
+		return rs, rs != nil
+	}
+	return draft.base.find(id)
+}
+
+// Replace posting list of ngram. Empty lists are dropped.
+func (draft *ngramDraft) set(id NgramId, refs []Ref) {
+	if len(refs) == 0 {
+		draft.changes[id] = nil
+	} else {
+		draft.changes[id] = draft.codec(refs)
+	}
+}
+
+// Make immutable segment from the draft. Draft must not be used after commit.
+func (draft *ngramDraft) commit() *ngramSegment {
+	base := draft.base
+	seg := &ngramSegment{
+		shards: base.shards,
+		docs:   draft.docs,
+		refs:   draft.refs,
+	}
+	if len(draft.changes) == 0 {
+		seg.layers = base.layers
+		return seg
+	}
+
+	seg.layers = make([]ngramLayer, len(base.layers), len(base.layers)+1)
+	copy(seg.layers, base.layers)
+	seg.layers = append(seg.layers, draft.changes)
+	if len(seg.layers) <= ngramLayerLimit {
+		return seg
+	}
+
+	overlay := seg.overlay()
+	if len(overlay) <= ngramOverlayLimit {
+		seg.layers = []ngramLayer{overlay}
+		return seg
+	}
+
+	// Fold changes into copies of affected shards
+	seg.layers = nil
+	var dirty [ngramShardCount]bool
+	for id, refs := range overlay {
+		i := id % ngramShardCount
+		if !dirty[i] {
+			src := seg.shards[i]
+			dst := make(map[NgramId]postingList, len(src))
+			for k, v := range src {
+				dst[k] = v
+			}
+			seg.shards[i] = dst
+			dirty[i] = true
+		}
+		if refs == nil {
+			delete(seg.shards[i], id)
+		} else {
+			seg.shards[i][id] = refs
+		}
+	}
+	return seg
+}
+
+func newNgramDraft(base *ngramSegment, codec postingCodec) *ngramDraft {
+	return &ngramDraft{
+		base:    base,
+		changes: make(ngramLayer),
+		docs:    base.docs,
+		refs:    base.refs,
+		codec:   codec,
+	}
+}
@@ -0,0 +1,263 @@
+package parcels
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Run with -race flag for detecting of data races.
+func TestNgramRuleSearchWhileRefresh(t *testing.T) {
+	ctx := context.Background()
+	docs := NewMapDocManager(nil)
+	rule := NewNgramRule(
+		"ngram.3",
+		NewNgramIndex(docs, NgramIndexPositions{}),
+		NewNgramParserPrimary(3, NewParserEstimatorPrimary(1)),
+	).(*ngramRule)
+
+	names := []string{"аспирин", "анальгин", "аскорбинка", "аскорбиновая кислота", "нокспрей"}
+
+	// Called from goroutines, so failures are reported by assert, not by require
+	refresh := func() {
+		assert.NoError(t, docs.Purge(ctx))
+		rule.Index.Purge()
+		for i := 0; i < 100; i++ {
+			doc := &Doc{Id: int64(i + 1)}
+			assert.NoError(t, docs.Append(ctx, doc))
+			name := fmt.Sprintf("%s %d", names[i%len(names)], i)
+			assert.NoError(t, rule.Append(ctx, doc.Id, []rune(name), 1))
+		}
+		for i := 0; i < 100; i += 3 {
+			rule.Remove(ctx, int64(i+1))
+			assert.NoError(t, docs.Remove(ctx, int64(i+1)))
+		}
+	}
+
+	refresh()
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			refresh()
+		}
+	}()
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				ngrams, err := rule.Parser.Parse(ctx, []rune("аскорб"), false)
+				assert.NoError(t, err)
+				hs := rule.Index.Search(ngrams, 1)
+				for _, rel := range hs {
+					assert.True(t, rel > 0)
+				}
+
+				_ = rule.Index.Statistics()
+				_ = docs.ForEach(ctx, func(ctx context.Context, doc *Doc) error {
+					return nil
+				})
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// Run with -race flag for detecting of data races.
+func TestAdvancedEngineSearchWhileRefresh(t *testing.T) {
+	ctx := context.Background()
+	names := []string{"аспирин", "анальгин", "аскорбинка", "аскорбиновая кислота", "нокспрей"}
+
+	repo := &parcelRepositoryMock{docs: make(map[int64]string)}
+	defs := make([]*DocDef, 0, 50)
+	for i := 0; i < 50; i++ {
+		doc := Doc{
+			Id:              int64(i + 1),
+			NameSearchIndex: fmt.Sprintf("%s %d", names[i%len(names)], i),
+		}
+		repo.docs[doc.Id] = fmt.Sprintf(`{"name": %q}`, doc.NameSearchIndex)
+		defs = append(defs, &DocDef{Head: doc, Body: repo.docs[doc.Id]})
+	}
+
+	docs := &mapDocManager{parcels: repo}
+	engine := newEngineMock(t, docs, nil)
+	strategies := engine.strategies
+
+	// Refresh of engine and incremental updates, that it is made of
+	refresh := func() {
+		assert.NoError(t, engine.Refresh(ctx, defs))
+		for _, def := range defs {
+			doc := def.Head
+			assert.NoError(t, strategies.Append(ctx, &doc))
+		}
+		for i := 0; i < len(defs); i += 3 {
+			assert.NoError(t, strategies.Remove(ctx, defs[i].Head.Id))
+		}
+	}
+
+	refresh()
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			refresh()
+		}
+	}()
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				details := &Details{
+					Band:   BandOptions{Capacity: 20},
+					Filter: &resolver{EntityFilterEx: entityFilterMock{}},
+				}
+				ps, err := engine.Search(ctx, "аскорб", "name", details)
+				assert.NoError(t, err)
+				for _, p := range ps {
+					assert.True(t, p.Relevance > 0)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestNgramDraftDoesNotModifyPublishedSegment(t *testing.T) {
+	base := newNgramSegment()
+	draft := newNgramDraft(base, newRefList)
+	draft.set(1, []Ref{{Doc: 1}})
+	seg := draft.commit()
+
+	_, ok := base.find(1)
+	assert.False(t, ok)
+
+	refs, ok := seg.find(1)
+	require.True(t, ok)
+	assert.Equal(t, []Ref{{Doc: 1}}, refs.Refs())
+
+	draft = newNgramDraft(seg, newRefList)
+	draft.set(1, refsInclude(refs.Refs(), Ref{Doc: 2}))
+	draft.set(1, refsExclude(refs.Refs(), 1))
+	_ = draft.commit()
+
+	refs, _ = seg.find(1)
+	assert.Equal(t, []Ref{{Doc: 1}}, refs.Refs())
+}
+
+func TestNgramIndexRemoveAndReplace(t *testing.T) {
+	index := NewNgramIndex(nil, NgramIndexPositions{}).(*ngramIndex)
+	entries := func(ids ...NgramId) []NgramEntry {
+		res := make([]NgramEntry, len(ids))
+		for i, id := range ids {
+			res[i] = NgramEntry{Id: id, Pos: int16(i)}
+		}
+		return res
+	}
+
+	index.Append(1, entries(1, 2, 3), 1)
+	index.Append(2, entries(2, 3, 4), 1)
+
+	// Replace document 1
+	index.Append(1, entries(3, 5), 1)
+	assert.Equal(t, []NgramId{3, 5}, index.forward[1])
+	_, ok := index.segment().find(1)
+	assert.False(t, ok)
+	refs, _ := index.segment().find(2)
+	assert.Equal(t, []Ref{{Doc: 2, Pos: 0, Weight: 1, Len: 3}}, refs.Refs())
+
+	// Remove document 2
+	index.Remove(2)
+	_, ok = index.forward[2]
+	assert.False(t, ok)
+	for _, n := range []NgramId{2, 4} {
+		_, ok := index.segment().find(n)
+		assert.False(t, ok, n)
+	}
+	refs, _ = index.segment().find(3)
+	assert.Equal(t, []Ref{{Doc: 1, Pos: 0, Weight: 1, Len: 2}}, refs.Refs())
+
+	stats := index.Statistics()
+	assert.Equal(t, 1, stats.Count)
+	assert.Equal(t, 2, stats.Refs)
+	assert.Equal(t, 1, index.segment().docs)
+	assert.Equal(t, 2, index.segment().refs)
+}
+
+func TestNgramSegmentLayers(t *testing.T) {
+	base := newNgramSegment()
+	base.shards[1][1] = newRefList([]Ref{{Doc: 1}})
+
+	// Write doesn't copy shards
+	draft := newNgramDraft(base, newRefList)
+	draft.set(257, []Ref{{Doc: 2}})
+	seg := draft.commit()
+	assert.Equal(t, reflect.ValueOf(base.shards[1]).Pointer(), reflect.ValueOf(seg.shards[1]).Pointer())
+	require.Len(t, seg.layers, 1)
+
+	// Layers are merged, removed lists are hidden
+	for i := 0; i < ngramLayerLimit; i++ {
+		draft = newNgramDraft(seg, newRefList)
+		draft.set(NgramId(1000+i), []Ref{{Doc: int64(i)}})
+		if i == 0 {
+			draft.set(1, nil)
+		}
+		seg = draft.commit()
+	}
+	require.Len(t, seg.layers, 1)
+	_, ok := seg.find(1)
+	assert.False(t, ok)
+	refs, ok := seg.find(257)
+	require.True(t, ok)
+	assert.Equal(t, []Ref{{Doc: 2}}, refs.Refs())
+	assert.Len(t, seg.items(), ngramLayerLimit+1)
+
+	// Large overlay is folded into shards
+	for i := 0; i < ngramLayerLimit; i++ {
+		draft = newNgramDraft(seg, newRefList)
+		for j := 0; j < ngramOverlayLimit/ngramLayerLimit+1; j++ {
+			draft.set(NgramId(100000+i*ngramOverlayLimit+j), []Ref{{Doc: 1}})
+		}
+		seg = draft.commit()
+	}
+	assert.Empty(t, seg.layers)
+	_, ok = seg.find(1)
+	assert.False(t, ok)
+	_, ok = seg.find(257)
+	assert.True(t, ok)
+	_, ok = base.find(1)
+	assert.True(t, ok)
+	assert.Len(t, seg.items(), ngramLayerLimit+1+ngramLayerLimit*(ngramOverlayLimit/ngramLayerLimit+1))
+}
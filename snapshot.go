@@ -0,0 +1,174 @@
+package parcels
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Version of the snapshot format. Must be incremented on each change of the index layout.
+// Version 2 widens NgramId to 32 bits.
+// Version 3 adds length of document to Ref, it is restored by forward map for older snapshots.
+const snapshotVersion = 3
+
+// Oldest snapshot version, that can be migrated at load time.
+// Snapshots of version 1 store 16-bit ngram identifiers, which are decoded into wider ones
+// and validated against overflow by NgramDictionary.Load.
+const snapshotVersionMin = 1
+
+// Interval between periodic snapshots of the search index.
+const snapshotInterval = 10 * time.Minute
+
+// BinaryStorage is abstract storage for binary snapshots.
+type BinaryStorage interface {
+	Get(ctx context.Context, namespace, key string) ([]byte, error)
+	Put(ctx context.Context, namespace, key string, data []byte) error
+}
+
+// DocSnapshotter is document manager, that can be saved into snapshot.
+type DocSnapshotter interface {
+	// Load documents from decoder
+	Load(dec *gob.Decoder) error
+	// Save documents to encoder
+	Save(enc *gob.Encoder) error
+}
+
+// Header of the snapshot
+type snapshotHeader struct {
+	Version int    // Version of the snapshot format
+	Hash    string // Hash of strategy options
+}
+
+// Check, that snapshot with header h can be loaded by engine, that expects header expected.
+func (h snapshotHeader) isCompatible(expected snapshotHeader) bool {
+	return h.Hash == expected.Hash &&
+		h.Version >= snapshotVersionMin &&
+		h.Version <= expected.Version
+}
+
+func newSnapshotHeader(options *StrategyOptions) (snapshotHeader, error) {
+	data, err := json.Marshal(options)
+	if err != nil {
+		return snapshotHeader{}, fmt.Errorf("Marshal: %w", err)
+	}
+
+	hash := sha1.Sum(data)
+	return snapshotHeader{
+		Version: snapshotVersion,
+		Hash:    hex.EncodeToString(hash[:]),
+	}, nil
+}
+
+func (strategies *Strategies) Load(dec *gob.Decoder) error {
+	strategies.Lock()
+	defer strategies.Unlock()
+
+	if docs, ok := strategies.docs.(DocSnapshotter); ok {
+		err := docs.Load(dec)
+		if err != nil {
+			return fmt.Errorf("docs.Load: %w", err)
+		}
+	}
+
+	// Prefix tree and vocabulary aren't saved, they are rebuilt by loaded documents
+	if docs, ok := strategies.docs.(DocManagerEx); ok {
+		strategies.suggests.purge()
+		strategies.spells.purge()
+		err := docs.ForEach(context.Background(), func(ctx context.Context, doc *Doc) error {
+			strategies.suggests.append(doc)
+			strategies.spells.append(doc)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("docs.ForEach: %w", err)
+		}
+	}
+
+	err := strategies.Names.Load(dec)
+	if err != nil {
+		return fmt.Errorf("Names.Load: %w", err)
+	}
+
+	err = strategies.Inns.Load(dec)
+	if err != nil {
+		return fmt.Errorf("Inns.Load: %w", err)
+	}
+
+	err = strategies.Makers.Load(dec)
+	if err != nil {
+		return fmt.Errorf("Makers.Load: %w", err)
+	}
+
+	return nil
+}
+
+func (strategies *Strategies) Save(enc *gob.Encoder) error {
+	strategies.RLock()
+	defer strategies.RUnlock()
+
+	if docs, ok := strategies.docs.(DocSnapshotter); ok {
+		err := docs.Save(enc)
+		if err != nil {
+			return fmt.Errorf("docs.Save: %w", err)
+		}
+	}
+
+	err := strategies.Names.Save(enc)
+	if err != nil {
+		return fmt.Errorf("Names.Save: %w", err)
+	}
+
+	err = strategies.Inns.Save(enc)
+	if err != nil {
+		return fmt.Errorf("Inns.Save: %w", err)
+	}
+
+	err = strategies.Makers.Save(enc)
+	if err != nil {
+		return fmt.Errorf("Makers.Save: %w", err)
+	}
+
+	return nil
+}
+
+// Collect all persistent rules of the DAG, ordered by name.
+// Shared rules are included only once. Data of rules is matched by name at load time,
+// so different rules with the same name are rejected.
+func persistentRules(root Rule) ([]PersistentRule, error) {
+	visited := make(map[Rule]bool)
+	var res []PersistentRule
+
+	var walk func(rule Rule)
+	walk = func(rule Rule) {
+		if rule == nil || visited[rule] {
+			return
+		}
+		visited[rule] = true
+
+		if r, ok := rule.(PersistentRule); ok {
+			res = append(res, r)
+		}
+
+		for _, child := range rule.Children() {
+			walk(child)
+		}
+	}
+	walk(root)
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name() < res[j].Name()
+	})
+
+	for i := 1; i < len(res); i++ {
+		if res[i].Name() == res[i-1].Name() {
+			return nil, fmt.Errorf("duplicate persistent rule %q", res[i].Name())
+		}
+	}
+
+	return res, nil
+}
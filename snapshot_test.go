@@ -0,0 +1,191 @@
+package parcels
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNgramRuleSnapshot(t *testing.T) {
+	ctx := context.Background()
+	newRule := func() PersistentRule {
+		return NewNgramRule(
+			"ngram.3",
+			NewNgramIndex(nil, NgramIndexPositions{}),
+			NewNgramParserPrimary(3, NewParserEstimatorPrimary(1)),
+		).(PersistentRule)
+	}
+
+	docs := map[int64]string{
+		1: "аспирин",
+		2: "анальгин",
+		3: "аскорбинка",
+	}
+
+	src := newRule()
+	for id, name := range docs {
+		err := src.Append(ctx, id, []rune(name), 1)
+		require.NoError(t, err)
+	}
+
+	var data bytes.Buffer
+	err := src.Save(gob.NewEncoder(&data))
+	require.NoError(t, err)
+
+	dst := newRule()
+	err = dst.Load(gob.NewDecoder(&data))
+	require.NoError(t, err)
+
+	search := func(rule PersistentRule, query string) Hypotheses {
+		r := rule.(*ngramRule)
+		ngrams, err := r.Parser.Parse(ctx, []rune(query), false)
+		require.NoError(t, err)
+		return r.Index.Search(ngrams, 1)
+	}
+
+	for _, query := range []string{"аспир", "аскорб", "гин"} {
+		assert.Equal(t, search(src, query), search(dst, query), query)
+	}
+}
+
+func TestPersistentRules(t *testing.T) {
+	shared := NewNgramRule(
+		"shared",
+		NewNgramIndex(nil, NgramIndexPositions{}),
+		NewNgramParserPrimary(3, NewParserEstimatorPrimary(1)),
+	)
+	root := NewMultiRule(
+		"root",
+		NewEntries(
+			&Entry{Rule: NewMuteRule("mute", nil, nil, shared)},
+			&Entry{Rule: NewGuardRule("guard", nil, nil, shared)},
+		),
+		NewMaxEstimator(),
+		NewMaxMixer(),
+	)
+
+	rules, err := persistentRules(root)
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, "shared", rules[0].Name())
+
+	// Different rules with the same name can't be matched with their data
+	root.(*MultiRule).Entries["other"] = &Entry{
+		Rule: NewNgramRule(
+			"shared",
+			NewNgramIndex(nil, NgramIndexPositions{}),
+			NewNgramParserPrimary(4, NewParserEstimatorPrimary(1)),
+		),
+		Weight: 1,
+	}
+	_, err = persistentRules(root)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `duplicate persistent rule "shared"`)
+}
+
+func TestMapDocManagerLoad(t *testing.T) {
+	ctx := context.Background()
+	src := NewMapDocManager(nil)
+	require.NoError(t, src.Append(ctx, &Doc{Id: 1, NameSearchIndex: "аспирин"}))
+	var data bytes.Buffer
+	require.NoError(t, src.(DocSnapshotter).Save(gob.NewEncoder(&data)))
+
+	// Document 2 isn't in snapshot, so it is dropped by warm load
+	dst := NewMapDocManager(nil)
+	require.NoError(t, dst.Append(ctx, &Doc{Id: 2, NameSearchIndex: "анальгин"}))
+	require.NoError(t, dst.(DocSnapshotter).Load(gob.NewDecoder(&data)))
+	var ids []int64
+	require.NoError(t, dst.ForEach(ctx, func(ctx context.Context, doc *Doc) error {
+		ids = append(ids, doc.Id)
+		return nil
+	}))
+	assert.Equal(t, []int64{1}, ids)
+}
+
+func TestNgramDictionaryExhausted(t *testing.T) {
+	dict := NewNgramDictionary(2)
+
+	_, _, err := dict.Accept("абв", true)
+	require.NoError(t, err)
+	_, _, err = dict.Accept("бвг", true)
+	require.NoError(t, err)
+
+	id, ok, err := dict.Accept("абв", true)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, NgramId(1), id)
+
+	_, _, err = dict.Accept("вгд", true)
+	assert.True(t, errors.Is(err, ErrNgramDictionaryExhausted))
+}
+
+func TestNgramDictionaryLoadOverflowed(t *testing.T) {
+	var data bytes.Buffer
+	enc := gob.NewEncoder(&data)
+	require.NoError(t, enc.Encode(uint16(1)))
+	require.NoError(t, enc.Encode(map[string]uint16{"абв": 1, "бвг": 1}))
+
+	dict := NewNgramDictionary(MaxNgramId)
+	err := dict.Load(gob.NewDecoder(&data))
+	assert.Error(t, err)
+}
+
+func TestNgramDictionaryFind(t *testing.T) {
+	dict := NewNgramDictionary(0)
+	for _, text := range []string{"абв", "бвг", "вгд"} {
+		_, _, err := dict.Accept(text, true)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "бвг", dict.Find(2))
+	assert.Equal(t, "", dict.Find(0))
+	assert.Equal(t, "", dict.Find(4))
+
+	var data bytes.Buffer
+	require.NoError(t, dict.Save(gob.NewEncoder(&data)))
+	loaded := NewNgramDictionary(0)
+	require.NoError(t, loaded.Load(gob.NewDecoder(&data)))
+	assert.Equal(t, "вгд", loaded.Find(3))
+	id, _, err := loaded.Accept("где", true)
+	require.NoError(t, err)
+	assert.Equal(t, "где", loaded.Find(id))
+
+	loaded.Purge()
+	assert.Equal(t, "", loaded.Find(1))
+}
+
+func TestNgramIndexLoadVersion2(t *testing.T) {
+	// Ref of version 2 has no length of document
+	type refV2 struct {
+		Doc    int64
+		Pos    int16
+		Weight float64
+	}
+	items := map[NgramId][]refV2{
+		1: {{Doc: 1, Weight: 1}, {Doc: 2, Weight: 1}},
+		2: {{Doc: 1, Pos: 1, Weight: 1}},
+		3: {{Doc: 1, Pos: 2, Weight: 1}},
+	}
+	var data bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&data).Encode(items))
+
+	index := NewNgramIndex(nil, NgramIndexPositions{}).(*ngramIndex)
+	require.NoError(t, index.Load(gob.NewDecoder(&data)))
+	for n, refs := range index.segment().items() {
+		for _, r := range refs {
+			expected := map[int64]int16{1: 3, 2: 1}[r.Doc]
+			assert.Equal(t, expected, r.Len, n)
+		}
+	}
+
+	// Older versions are loaded with the same hash of options
+	expected := snapshotHeader{Version: snapshotVersion, Hash: "h"}
+	assert.True(t, snapshotHeader{Version: 2, Hash: "h"}.isCompatible(expected))
+	assert.True(t, snapshotHeader{Version: snapshotVersionMin, Hash: "h"}.isCompatible(expected))
+	assert.False(t, snapshotHeader{Version: snapshotVersion + 1, Hash: "h"}.isCompatible(expected))
+	assert.False(t, snapshotHeader{Version: 2, Hash: "other"}.isCompatible(expected))
+}
@@ -0,0 +1,498 @@
+package parcels
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Kinds of spec nodes
+const (
+	NodeKindNgram  = "ngram"
+	NodeKindMulti  = "multi"
+	NodeKindGuard  = "guard"
+	NodeKindMute   = "mute"
+	NodeKindLayout = "layout"
+	NodeKindFilter = "filter"
+)
+
+// StrategySpec is declarative definition of rule graph of strategy.
+// Nodes are named rules, which refer to other nodes by name,
+// so single node can be shared by several parents.
+type StrategySpec struct {
+	Root  string               `json:"root"`  // Name of root node
+	Mute  string               `json:"mute"`  // Mutator of query: lower, ru, ua. Default lower
+	Nodes map[string]*NodeSpec `json:"nodes"` // Nodes by name. Name of node is name of rule
+}
+
+// NodeSpec is declarative definition of rule.
+// Set of used properties depends on kind of node.
+type NodeSpec struct {
+	Kind string `json:"kind"` // ngram, multi, guard, mute, layout, filter
+
+	Rule string `json:"rule,omitempty"` // guard, mute, layout, filter: nested node
+
+	Entries   []EntrySpec      `json:"entries,omitempty"`   // multi: nested nodes. Weights are normalized like NewEntries
+	Ngrams    *NgramFamilySpec `json:"ngrams,omitempty"`    // multi: generated ngram rules
+	Mixer     string           `json:"mixer,omitempty"`     // multi: max, sum, intersect, wmin, difference, rrf, minmax, zscore. Default max
+	Base      string           `json:"base,omitempty"`      // multi: base node of difference mixer
+	Estimator string           `json:"estimator,omitempty"` // multi, layout: max, topmean, gap, coverage. Default max
+
+	Predicate string `json:"predicate,omitempty"` // guard: ru, ua, any
+	Mutator   string `json:"mutator,omitempty"`   // mute: ru, ua, lower, none
+
+	Layouts []string `json:"layouts,omitempty"` // layout: names of translators
+	Weight  float64  `json:"weight,omitempty"`  // layout: weight of translated query
+
+	Parser     string                     `json:"parser,omitempty"`     // ngram: primary, secondary
+	Length     int                        `json:"length,omitempty"`     // ngram: length of ngram
+	Position   NgramPositionBranchOptions `json:"position"`             // ngram: position info
+	Scoring    NgramScoringOptions        `json:"scoring"`              // ngram: scoring model
+	Compressed bool                       `json:"compressed,omitempty"` // ngram: compressed posting lists
+	TopK       bool                       `json:"top-k,omitempty"`      // ngram: top-k search
+}
+
+// EntrySpec is weighted reference to node
+type EntrySpec struct {
+	Node   string  `json:"node"`
+	Weight float64 `json:"weight"`
+}
+
+// NgramFamilySpec is family of ngram rules, generated by ngram options.
+type NgramFamilySpec struct {
+	Prefix  string        `json:"prefix"`  // Prefix of rule names
+	Options *NgramOptions `json:"options"` // Options of family. Default is options of strategy
+}
+
+var specMixers = map[string]func() Mixer{
+	"":           NewMaxMixer,
+	"max":        NewMaxMixer,
+	"sum":        NewSumMixer,
+	"intersect":  NewIntersectMixer,
+	"wmin":       NewWeightedMinMixer,
+	"difference": func() Mixer { return NewDifferenceMixer("") },
+	"rrf":        func() Mixer { return NewRRFMixer(0) },
+	"minmax":     NewMinMaxMixer,
+	"zscore":     NewZScoreMixer,
+}
+
+var specEstimators = map[string]func() Estimator{
+	"":         NewMaxEstimator,
+	"max":      NewMaxEstimator,
+	"topmean":  func() Estimator { return NewTopMeanEstimator(0) },
+	"gap":      func() Estimator { return NewGapEstimator(0) },
+	"coverage": NewCoverageEstimator,
+}
+
+var specPredicates = map[string]Predicate{
+	"":    defaultPredicate,
+	"any": defaultPredicate,
+	"ru":  ruPredicate,
+	"ua":  uaPredicate,
+}
+
+var specMutators = map[string]Mutator{
+	"none":  defaultMutator,
+	"lower": rootMute,
+	"ru":    ruMute,
+	"ua":    uaMute,
+}
+
+var specLayouts = map[string]func() LayoutTranslator{
+	"keyboard.en-ru": func() LayoutTranslator { return NewLayoutTranslator(layoutEn2RuKeyboard, dictEn, Rus) },
+	"keyboard.en-ua": func() LayoutTranslator { return NewLayoutTranslator(layoutEn2UaKeyboard, dictEn, Ukr) },
+	"keyboard.ru-ua": func() LayoutTranslator { return NewLayoutTranslator(layoutRu2UaKeyboard, dictRu, Ukr) },
+	"keyboard.ua-ru": func() LayoutTranslator { return NewLayoutTranslator(layoutUa2RuKeyboard, dictUa, Rus) },
+	"phonetic.ru-ua": func() LayoutTranslator { return NewLayoutTranslator(layoutUa2RuPhonetic, dictRu, Ukr) },
+	"phonetic.ua-ru": func() LayoutTranslator { return NewLayoutTranslator(layoutRu2UaPhonetic, dictUa, Rus) },
+}
+
+// Get names of nested nodes
+func (node *NodeSpec) references() []string {
+	switch node.Kind {
+	case NodeKindMulti:
+		res := make([]string, len(node.Entries))
+		for i, e := range node.Entries {
+			res[i] = e.Node
+		}
+		return res
+	case NodeKindGuard, NodeKindMute, NodeKindLayout, NodeKindFilter:
+		return []string{node.Rule}
+	default:
+		return nil
+	}
+}
+
+// Validate checks kinds of nodes, references between nodes and absence of cycles.
+func (spec *StrategySpec) Validate() error {
+	if _, ok := spec.Nodes[spec.Root]; !ok {
+		return fmt.Errorf("unknown root node %q", spec.Root)
+	}
+	if _, ok := specMutators[spec.Mute]; !ok && spec.Mute != "" {
+		return fmt.Errorf("unknown mutator %q", spec.Mute)
+	}
+
+	names := make([]string, 0, len(spec.Nodes))
+	for name, node := range spec.Nodes {
+		if node == nil {
+			return fmt.Errorf("node %q is empty", name)
+		}
+		switch node.Kind {
+		case NodeKindNgram, NodeKindMulti, NodeKindGuard, NodeKindMute, NodeKindLayout, NodeKindFilter:
+		default:
+			return fmt.Errorf("node %q: unknown kind %q", name, node.Kind)
+		}
+		for _, ref := range node.references() {
+			if _, ok := spec.Nodes[ref]; !ok {
+				return fmt.Errorf("node %q: unknown node %q", name, ref)
+			}
+		}
+		names = append(names, name)
+	}
+
+	// Search cycles in deterministic order
+	sort.Strings(names)
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int, len(spec.Nodes))
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("cycle %s", strings.Join(append(path, name), " -> "))
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, ref := range spec.Nodes[name].references() {
+			err := visit(ref, append(path, name))
+			if err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for _, name := range names {
+		err := visit(name, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// specBuilder builds rule graph from spec. Every node is built once.
+type specBuilder struct {
+	docs    DocManager
+	options *StrategyOptions
+	spec    *StrategySpec
+	rules   map[string]Rule
+}
+
+func (builder *specBuilder) build(name string) (Rule, error) {
+	if rule, ok := builder.rules[name]; ok {
+		return rule, nil
+	}
+
+	node := builder.spec.Nodes[name]
+	var rule Rule
+	var err error
+	switch node.Kind {
+	case NodeKindNgram:
+		rule, err = builder.buildNgram(name, node)
+	case NodeKindMulti:
+		rule, err = builder.buildMulti(name, node)
+	default:
+		rule, err = builder.buildDerivative(name, node)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("node %q: %w", name, err)
+	}
+
+	builder.rules[name] = rule
+	return rule, nil
+}
+
+func (builder *specBuilder) buildNgram(name string, node *NodeSpec) (Rule, error) {
+	if node.Length < 1 {
+		return nil, fmt.Errorf("invalid length %d", node.Length)
+	}
+
+	home := node.Position.Absolute + node.Position.Relative
+	if home != 0 {
+		home = node.Position.Absolute / home
+	}
+
+	var parser NgramParser
+	switch node.Parser {
+	case "", "primary":
+		parser = NewNgramParserPrimary(node.Length, NewParserEstimatorPrimary(home))
+	case "secondary":
+		parser = NewNgramParserSecondary(node.Length, NewParserEstimatorSecondary())
+	default:
+		return nil, fmt.Errorf("unknown parser %q", node.Parser)
+	}
+
+	scoring, err := ParseNgramScoring(node.Scoring.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	index := NewNgramIndex(
+		builder.docs,
+		NgramIndexPositions{
+			Weight:    node.Position.Weight,
+			Query:     node.Position.Query,
+			Inflation: node.Position.Inflation,
+			Scoring: NgramIndexScoring{
+				Model: scoring,
+				K1:    node.Scoring.K1,
+				B:     node.Scoring.B,
+			},
+			TopK:       node.TopK,
+			Compressed: node.Compressed,
+		},
+	)
+
+	return NewNgramRule(name, index, parser), nil
+}
+
+func (builder *specBuilder) buildMulti(name string, node *NodeSpec) (Rule, error) {
+	newMixer, ok := specMixers[node.Mixer]
+	if !ok {
+		return nil, fmt.Errorf("unknown mixer %q", node.Mixer)
+	}
+	newEstimator, ok := specEstimators[node.Estimator]
+	if !ok {
+		return nil, fmt.Errorf("unknown estimator %q", node.Estimator)
+	}
+
+	// Ngram family keeps weights of NewStrategyDefault, so all entries are normalized together
+	// to the summary weight of family. Without family they are normalized to 1, like NewEntries does.
+	var list []*Entry
+	target := float64(1)
+	if node.Ngrams != nil {
+		options := node.Ngrams.Options
+		if options == nil {
+			options = &builder.options.Ngrams
+		}
+		if _, err := options.mixer(); err != nil {
+			return nil, err
+		}
+		if _, err := options.Scoring.newScoring(); err != nil {
+			return nil, err
+		}
+		target = 0
+		for _, e := range options.newNgrams(builder.docs, node.Ngrams.Prefix) {
+			list = append(list, e)
+			target += e.Weight
+		}
+	}
+
+	// Explicit entries without weights are equal
+	weighted := false
+	for _, e := range node.Entries {
+		weighted = weighted || e.Weight != 0
+	}
+	for _, e := range node.Entries {
+		rule, err := builder.build(e.Node)
+		if err != nil {
+			return nil, err
+		}
+		weight := e.Weight
+		if !weighted {
+			weight = 1
+		}
+		list = append(list, &Entry{
+			Rule:   rule,
+			Weight: weight,
+		})
+	}
+
+	var total float64
+	entries := make(Entries, len(list))
+	for _, e := range list {
+		if _, ok := entries[e.Name()]; ok {
+			return nil, fmt.Errorf("duplicate entry %q", e.Name())
+		}
+		entries[e.Name()] = e
+		total += e.Weight
+	}
+	if total > 0 {
+		for _, e := range list {
+			e.Weight *= target / total
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no entries")
+	}
+
+	mixer := newMixer()
+	if m, ok := mixer.(*differenceMixer); ok {
+		if _, ok := entries[node.Base]; !ok {
+			return nil, fmt.Errorf("base %q is not entry of difference", node.Base)
+		}
+		m.Base = node.Base
+	}
+
+	return NewMultiRule(name, entries, newEstimator(), mixer), nil
+}
+
+func (builder *specBuilder) buildDerivative(name string, node *NodeSpec) (Rule, error) {
+	rule, err := builder.build(node.Rule)
+	if err != nil {
+		return nil, err
+	}
+
+	switch node.Kind {
+	case NodeKindGuard:
+		predicate, ok := specPredicates[node.Predicate]
+		if !ok {
+			return nil, fmt.Errorf("unknown predicate %q", node.Predicate)
+		}
+		return NewGuardRule(name, predicate, predicate, rule), nil
+
+	case NodeKindMute:
+		mutator, ok := specMutators[node.Mutator]
+		if !ok {
+			return nil, fmt.Errorf("unknown mutator %q", node.Mutator)
+		}
+		return NewMuteRule(name, mutator, mutator, rule), nil
+
+	case NodeKindLayout:
+		newEstimator, ok := specEstimators[node.Estimator]
+		if !ok {
+			return nil, fmt.Errorf("unknown estimator %q", node.Estimator)
+		}
+		layouts := make([]LayoutTranslator, len(node.Layouts))
+		for i, l := range node.Layouts {
+			newLayout, ok := specLayouts[l]
+			if !ok {
+				return nil, fmt.Errorf("unknown layout %q", l)
+			}
+			layouts[i] = newLayout()
+		}
+		return NewLayoutRule(name, rule, layouts, node.Weight, newEstimator()), nil
+
+	case NodeKindFilter:
+		return NewFilterRule(name, rule), nil
+	}
+
+	return nil, fmt.Errorf("unknown kind %q", node.Kind)
+}
+
+// ParseStrategySpec decodes and validates strategy spec from JSON
+func ParseStrategySpec(data []byte) (*StrategySpec, error) {
+	spec := new(StrategySpec)
+	err := json.Unmarshal(data, spec)
+	if err != nil {
+		return nil, fmt.Errorf("Unmarshal: %w", err)
+	}
+	err = spec.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("Validate: %w", err)
+	}
+	return spec, nil
+}
+
+// DefaultStrategySpec is spec of rule graph, built by NewStrategyDefault with default options.
+func DefaultStrategySpec() (*StrategySpec, error) {
+	return ParseStrategySpec([]byte(defaultStrategySpec))
+}
+
+// NewStrategyFromSpec is constructor for creating instance of strategy by spec.
+// Options are used for ngram families of spec.
+func NewStrategyFromSpec(
+	docs DocManager,
+	options *StrategyOptions,
+	spec *StrategySpec,
+	reader Reader,
+) (Strategy, error) {
+	if options == nil {
+		options = DefaultStrategyOptions()
+	}
+
+	err := spec.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("Validate: %w", err)
+	}
+
+	builder := &specBuilder{
+		docs:    docs,
+		options: options,
+		spec:    spec,
+		rules:   make(map[string]Rule, len(spec.Nodes)),
+	}
+	rule, err := builder.build(spec.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	var mute Mutator = rootMute
+	if spec.Mute != "" {
+		mute = specMutators[spec.Mute]
+	}
+
+	return NewStrategy(mute, rule, reader), nil
+}
+
+const defaultStrategySpec = `{
+	"root": "layout.max",
+	"mute": "lower",
+	"nodes": {
+		"layout.max": {
+			"kind": "multi",
+			"mixer": "max",
+			"estimator": "max",
+			"entries": [
+				{"node": "main.max", "weight": 8},
+				{"node": "ru.metaphone.guard", "weight": 1},
+				{"node": "ua.metaphone.guard", "weight": 1}
+			]
+		},
+		"main.max": {
+			"kind": "multi",
+			"mixer": "max",
+			"estimator": "max",
+			"ngrams": {"prefix": "main.ngram"}
+		},
+		"ru.metaphone.guard": {
+			"kind": "guard",
+			"predicate": "ru",
+			"rule": "ru.metaphone.distort"
+		},
+		"ru.metaphone.distort": {
+			"kind": "mute",
+			"mutator": "ru",
+			"rule": "ru.metaphone.max"
+		},
+		"ru.metaphone.max": {
+			"kind": "multi",
+			"mixer": "max",
+			"estimator": "max",
+			"ngrams": {"prefix": "ru.metaphone.ngram"}
+		},
+		"ua.metaphone.guard": {
+			"kind": "guard",
+			"predicate": "ua",
+			"rule": "ua.metaphone.distort"
+		},
+		"ua.metaphone.distort": {
+			"kind": "mute",
+			"mutator": "ua",
+			"rule": "ua.metaphone.max"
+		},
+		"ua.metaphone.max": {
+			"kind": "multi",
+			"mixer": "max",
+			"estimator": "max",
+			"ngrams": {"prefix": "ua.metaphone.ngram"}
+		}
+	}
+}`
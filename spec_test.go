@@ -0,0 +1,175 @@
+package parcels
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Describe rule graph for comparison
+func describeRule(sb *strings.Builder, rule Rule, indent string) {
+	fmt.Fprintf(sb, "%s%T %s", indent, rule, rule.Name())
+	switch r := rule.(type) {
+	case *ngramRule:
+		fmt.Fprintf(sb, " %T %+v\n", r.Parser, r.Index.(*ngramIndex).Position)
+		return
+	case *MultiRule:
+		fmt.Fprintf(sb, " %T %T\n", r.Estimator, r.Mixer)
+		names := make([]string, 0, len(r.Entries))
+		for k := range r.Entries {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			e := r.Entries[k]
+			fmt.Fprintf(sb, "%s  weight=%g\n", indent, e.Weight)
+			describeRule(sb, e.Rule, indent+"  ")
+		}
+		return
+	case *GuardRule:
+		fmt.Fprintf(sb, " %T", r.PredicateSearch)
+	case *muteRule:
+		fmt.Fprintf(sb, " %T", r.MutatorSearch)
+	case *layoutRule:
+		fmt.Fprintf(sb, " %d %g", len(r.Layouts), r.Weight)
+	}
+	sb.WriteString("\n")
+	for _, child := range rule.Children() {
+		describeRule(sb, child, indent+"  ")
+	}
+}
+
+func describeStrategy(st Strategy) string {
+	var sb strings.Builder
+	s := st.(*strategy)
+	fmt.Fprintf(&sb, "%T\n", s.Mutator)
+	describeRule(&sb, s.Rule, "")
+	return sb.String()
+}
+
+func TestDefaultStrategySpec(t *testing.T) {
+	spec, err := DefaultStrategySpec()
+	require.NoError(t, err)
+
+	expected, err := NewStrategyDefault(nil, nil, nil)
+	require.NoError(t, err)
+	actual, err := NewStrategyFromSpec(nil, nil, spec, nil)
+	require.NoError(t, err)
+	assert.Equal(t, describeStrategy(expected), describeStrategy(actual))
+}
+
+func TestStrategySpecSharedNode(t *testing.T) {
+	spec, err := ParseStrategySpec([]byte(`{
+		"root": "root",
+		"nodes": {
+			"root": {"kind": "multi", "mixer": "sum", "entries": [
+				{"node": "a", "weight": 1},
+				{"node": "b", "weight": 0.5}
+			]},
+			"a": {"kind": "filter", "rule": "shared"},
+			"b": {"kind": "layout", "rule": "shared", "layouts": ["keyboard.en-ru"], "weight": 0.9},
+			"shared": {"kind": "ngram", "parser": "secondary", "length": 3}
+		}
+	}`))
+	require.NoError(t, err)
+
+	st, err := NewStrategyFromSpec(nil, nil, spec, nil)
+	require.NoError(t, err)
+	root := st.(*strategy).Rule.(*MultiRule)
+	a := root.Entries["a"].Rule.Children()[0]
+	b := root.Entries["b"].Rule.Children()[0]
+	assert.True(t, a == b)
+	rules, err := persistentRules(root)
+	require.NoError(t, err)
+	assert.Len(t, rules, 1)
+}
+
+func TestStrategySpecEntriesWeights(t *testing.T) {
+	text := `{
+		"root": "root",
+		"nodes": {
+			"root": {"kind": "multi", "ngrams": {"prefix": "%s"}, "entries": [
+				{"node": "a", "weight": 1},
+				{"node": "b", "weight": 3}
+			]},
+			"a": {"kind": "ngram", "length": 3},
+			"b": {"kind": "ngram", "parser": "secondary", "length": 3}
+		}
+	}`
+
+	spec, err := ParseStrategySpec([]byte(fmt.Sprintf(text, "main")))
+	require.NoError(t, err)
+	st, err := NewStrategyFromSpec(nil, nil, spec, nil)
+	require.NoError(t, err)
+	var family, total float64
+	for _, e := range DefaultStrategyOptions().Ngrams.newNgrams(nil, "main") {
+		family += e.Weight
+	}
+	entries := st.(*strategy).Rule.(*MultiRule).Entries
+	for _, e := range entries {
+		total += e.Weight
+	}
+	// Union is normalized once to weight of family, explicit entries keep their proportion
+	assert.InDelta(t, family, total, 1e-9)
+	assert.InDelta(t, 3*entries["a"].Weight, entries["b"].Weight, 1e-9)
+
+	// Entry can't replace rule of family
+	spec, err = ParseStrategySpec([]byte(strings.Replace(fmt.Sprintf(text, "x"), `"a"`, `"x.p3"`, -1)))
+	require.NoError(t, err)
+	_, err = NewStrategyFromSpec(nil, nil, spec, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `duplicate entry "x.p3"`)
+}
+
+func TestNewStrategyByOptions(t *testing.T) {
+	spec, err := ParseStrategySpec([]byte(`{"root": "a", "nodes": {"a": {"kind": "ngram", "length": 3}}}`))
+	require.NoError(t, err)
+	options := DefaultStrategyOptions()
+	options.Spec = spec
+	st, err := NewStrategyByOptions(nil, options, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "a", st.(*strategy).Rule.Name())
+
+	// Options without spec build default strategy
+	st, err = NewStrategyByOptions(nil, DefaultStrategyOptions(), nil)
+	require.NoError(t, err)
+	expected, err := NewStrategyDefault(nil, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, describeStrategy(expected), describeStrategy(st))
+
+	// Spec is part of options, so snapshot of other spec isn't loaded
+	h1, err := newSnapshotHeader(DefaultStrategyOptions())
+	require.NoError(t, err)
+	h2, err := newSnapshotHeader(options)
+	require.NoError(t, err)
+	assert.False(t, h1.isCompatible(h2))
+}
+
+func TestStrategySpecValidate(t *testing.T) {
+	for text, expected := range map[string]string{
+		`{"root": "x", "nodes": {}}`:                                        `unknown root node "x"`,
+		`{"root": "a", "nodes": {"a": {"kind": "tree"}}}`:                   `node "a": unknown kind "tree"`,
+		`{"root": "a", "nodes": {"a": {"kind": "filter", "rule": "b"}}}`:    `node "a": unknown node "b"`,
+		`{"root": "a", "mute": "upper", "nodes": {"a": {"kind": "ngram"}}}`: `unknown mutator "upper"`,
+		`{"root": "a", "nodes": {
+			"a": {"kind": "filter", "rule": "b"},
+			"b": {"kind": "multi", "entries": [{"node": "c"}]},
+			"c": {"kind": "guard", "rule": "a"}
+		}}`: `cycle a -> b -> c -> a`,
+	} {
+		_, err := ParseStrategySpec([]byte(text))
+		require.Error(t, err, text)
+		assert.Contains(t, err.Error(), expected)
+	}
+
+	// Properties of nodes are checked while building
+	spec, err := ParseStrategySpec([]byte(`{"root": "a", "nodes": {"a": {"kind": "guard", "predicate": "en", "rule": "b"}, "b": {"kind": "ngram", "length": 3}}}`))
+	require.NoError(t, err)
+	_, err = NewStrategyFromSpec(nil, nil, spec, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `node "a": unknown predicate "en"`)
+}
@@ -0,0 +1,408 @@
+package parcels
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"spWebFront/FrontKeeper/server/app/domain/model"
+)
+
+// Default count of corrections
+const defaultSpellLimit = 3
+
+// Count of candidates per token of query
+const spellCandidates = 5
+
+// Tokens shorter than this are not corrected
+const spellMinLen = 3
+
+// SpellOptions is request of spelling corrections.
+type SpellOptions struct {
+	Limit     int     `json:"limit"`     // Maximal count of corrections. Default 3
+	Threshold float64 `json:"threshold"` // Relevance of weak results. Threshold of band, if zero
+	Rerun     bool    `json:"rerun"`     // Search by the best correction, if results are weak
+}
+
+// Correction is corrected query.
+type Correction struct {
+	Query    string   `json:"query"`
+	Distance int      `json:"distance"`          // Sum of edit distances of tokens
+	Layouts  []string `json:"layouts,omitempty"` // Layouts of translated tokens
+}
+
+// SpellResponse is search results with corrections of query.
+// Corrections are proposed only for weak results.
+type SpellResponse struct {
+	Parcels     model.Parcels `json:"parcels"`
+	Corrections []*Correction `json:"corrections,omitempty"`
+	Corrected   string        `json:"corrected,omitempty"` // Query of results, if search was rerun by correction
+}
+
+// Speller is manager, that can correct spelling of query.
+type Speller interface {
+	SearchSpelled(ctx context.Context, query string, typ string, details *Details, options SpellOptions) (*SpellResponse, error)
+}
+
+// spellWord is word of document names with count of its documents.
+// Words of removed documents are unlinked from index.
+type spellWord struct {
+	text  string
+	len   int
+	count int
+}
+
+// spellIndex is vocabulary of document names.
+// Words are indexed by ngrams, so candidates of misspelled token are found
+// without scan of the whole vocabulary.
+type spellIndex struct {
+	sync.RWMutex
+	words   map[string]*spellWord
+	lists   map[string][]*spellWord // Words by ngrams
+	docs    map[int64][]string      // Words of documents
+	layouts []spellLayout
+}
+
+// spellLayout is translator of tokens, typed in wrong layout
+type spellLayout struct {
+	name string
+	LayoutTranslator
+}
+
+// Make translators of layouts in stable order
+func newSpellLayouts() []spellLayout {
+	names := specLayoutNames()
+	res := make([]spellLayout, len(names))
+	for i, name := range names {
+		res[i] = spellLayout{name: name, LayoutTranslator: specLayouts[name]()}
+	}
+	return res
+}
+
+// Get padded trigrams of word, so short words have ngrams too
+func spellNgrams(word string) []string {
+	runes := []rune(" " + word + " ")
+	res := make([]string, 0, len(runes)-2)
+	for i := 0; i+3 <= len(runes); i++ {
+		res = append(res, string(runes[i:i+3]))
+	}
+	return res
+}
+
+// Maximal edit distance of correction of token
+func spellDistance(len int) int {
+	if len <= 4 {
+		return 1
+	}
+	return 2
+}
+
+func (index *spellIndex) purge() {
+	index.Lock()
+	defer index.Unlock()
+	index.words = nil
+	index.lists = nil
+	index.docs = nil
+}
+
+func (index *spellIndex) append(doc *Doc) {
+	index.Lock()
+	defer index.Unlock()
+	if index.words == nil {
+		index.words = make(map[string]*spellWord)
+		index.lists = make(map[string][]*spellWord)
+		index.docs = make(map[int64][]string)
+	}
+	if index.layouts == nil {
+		index.layouts = newSpellLayouts()
+	}
+
+	// Document may be updated
+	index.remove(doc.Id)
+	words := distinctStrings(suggestWords(docNameSearchIndexReader(doc)))
+	for _, text := range words {
+		word, ok := index.words[text]
+		if !ok {
+			word = &spellWord{text: text, len: len([]rune(text))}
+			for _, ngram := range spellNgrams(text) {
+				index.lists[ngram] = append(index.lists[ngram], word)
+			}
+			index.words[text] = word
+		}
+		word.count++
+	}
+	if len(words) != 0 {
+		index.docs[doc.Id] = words
+	}
+}
+
+func (index *spellIndex) delete(id int64) {
+	index.Lock()
+	defer index.Unlock()
+	index.remove(id)
+}
+
+func (index *spellIndex) remove(id int64) {
+	for _, text := range index.docs[id] {
+		if word, ok := index.words[text]; ok {
+			word.count--
+			if word.count <= 0 {
+				index.unlink(word)
+				delete(index.words, text)
+			}
+		}
+	}
+	delete(index.docs, id)
+}
+
+// Remove word from lists of its ngrams
+func (index *spellIndex) unlink(word *spellWord) {
+	for _, ngram := range spellNgrams(word.text) {
+		list := index.lists[ngram]
+		for i, w := range list {
+			if w == word {
+				list = append(list[:i], list[i+1:]...)
+				break
+			}
+		}
+		if len(list) == 0 {
+			delete(index.lists, ngram)
+		} else {
+			index.lists[ngram] = list
+		}
+	}
+}
+
+func distinctStrings(list []string) []string {
+	seen := make(map[string]bool, len(list))
+	res := list[:0]
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// spellCandidate is word of vocabulary, proposed for token
+type spellCandidate struct {
+	text     string
+	distance int
+	count    int
+	layout   string // Layout, which translates token into word
+}
+
+func (c *spellCandidate) less(other *spellCandidate) bool {
+	if c.distance != other.distance {
+		return c.distance < other.distance
+	}
+	if c.count != other.count {
+		return c.count > other.count
+	}
+	return c.text < other.text
+}
+
+// Find candidates of token. Known token is the only candidate of itself.
+func (index *spellIndex) candidates(ctx context.Context, token string) []*spellCandidate {
+	if word, ok := index.words[token]; ok {
+		return []*spellCandidate{{text: token, count: word.count}}
+	}
+	size := len([]rune(token))
+	if size < spellMinLen {
+		return nil
+	}
+
+	var res []*spellCandidate
+	// Token is typed in wrong layout
+	for _, layout := range index.layouts {
+		translated := string(layout.Translate(ctx, []rune(token), nil))
+		if translated == "" || translated == token {
+			continue
+		}
+		if word, ok := index.words[translated]; ok {
+			res = append(res, &spellCandidate{text: translated, count: word.count, layout: layout.name})
+		}
+	}
+
+	// Edit distance d changes at most 3*d trigrams
+	limit := spellDistance(size)
+	ngrams := spellNgrams(token)
+	shared := make(map[*spellWord]int)
+	for _, ngram := range ngrams {
+		for _, word := range index.lists[ngram] {
+			shared[word]++
+		}
+	}
+	for word, n := range shared {
+		if n < len(ngrams)-3*limit {
+			continue
+		}
+		if d := word.len - size; d > limit || -d > limit {
+			continue
+		}
+		if d := ComputeDistance(token, word.text); d <= limit {
+			res = append(res, &spellCandidate{text: word.text, distance: d, count: word.count})
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].less(res[j]) })
+	if len(res) > spellCandidates {
+		res = res[:spellCandidates]
+	}
+	return res
+}
+
+// spellPath is partially corrected query
+type spellPath struct {
+	tokens   []string
+	distance int
+	score    float64 // Sum of log counts of words
+	layouts  []string
+}
+
+func (p *spellPath) less(other *spellPath) bool {
+	if p.distance != other.distance {
+		return p.distance < other.distance
+	}
+	return p.score > other.score
+}
+
+// Propose corrections of query. Every token is replaced by its candidates,
+// unknown tokens without candidates are kept as is.
+func (index *spellIndex) correct(ctx context.Context, query string, limit int) []*Correction {
+	if limit <= 0 {
+		limit = defaultSpellLimit
+	}
+	tokens := suggestWords(query)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	index.RLock()
+	defer index.RUnlock()
+	if index.words == nil {
+		return nil
+	}
+
+	// Beam search over candidates of tokens
+	width := limit * spellCandidates
+	beam := []*spellPath{{}}
+	for _, token := range tokens {
+		cs := index.candidates(ctx, token)
+		if len(cs) == 0 {
+			cs = []*spellCandidate{{text: token}}
+		}
+		next := make([]*spellPath, 0, len(beam)*len(cs))
+		for _, p := range beam {
+			for _, c := range cs {
+				path := &spellPath{
+					tokens:   append(append([]string(nil), p.tokens...), c.text),
+					distance: p.distance + c.distance,
+					score:    p.score + math.Log1p(float64(c.count)),
+					layouts:  p.layouts,
+				}
+				if c.layout != "" {
+					path.layouts = append(append([]string(nil), p.layouts...), c.layout)
+				}
+				next = append(next, path)
+			}
+		}
+		sort.SliceStable(next, func(i, j int) bool { return next[i].less(next[j]) })
+		if len(next) > width {
+			next = next[:width]
+		}
+		beam = next
+	}
+
+	original := strings.Join(tokens, " ")
+	var res []*Correction
+	for _, p := range beam {
+		q := strings.Join(p.tokens, " ")
+		if q == original {
+			continue
+		}
+		res = append(
+			res,
+			&Correction{
+				Query:    q,
+				Distance: p.distance,
+				Layouts:  p.layouts,
+			},
+		)
+		if len(res) >= limit {
+			break
+		}
+	}
+	return res
+}
+
+// Names of layouts in stable order
+func specLayoutNames() []string {
+	res := make([]string, 0, len(specLayouts))
+	for name := range specLayouts {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Maximal relevance of parcels
+func maxParcelRelevance(ps model.Parcels) float64 {
+	var res float64
+	for _, p := range ps {
+		if p.Relevance > res {
+			res = p.Relevance
+		}
+	}
+	return res
+}
+
+// SearchSpelled searches results with corrections of query.
+// Corrections are proposed, if there are no results or the best relevance is below threshold.
+// If rerun is requested, results of the best correction replace weak results, if they are better.
+func (engine *advancedEngine) SearchSpelled(
+	ctx context.Context,
+	query string,
+	typ string,
+	details *Details,
+	options SpellOptions,
+) (*SpellResponse, error) {
+	if details == nil {
+		details = new(Details)
+		engine.InitDetails(details)
+	}
+	ps, err := engine.Search(ctx, query, typ, details)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &SpellResponse{Parcels: ps}
+	threshold := options.Threshold
+	if threshold == 0 {
+		threshold = details.Band.Threshold
+	}
+	relevance := maxParcelRelevance(ps)
+	if len(ps) != 0 && relevance >= threshold {
+		return res, nil
+	}
+
+	res.Corrections = engine.strategies.spells.correct(ctx, query, options.Limit)
+	if !options.Rerun || len(res.Corrections) == 0 {
+		return res, nil
+	}
+
+	best := res.Corrections[0].Query
+	ps, err = engine.Search(ctx, best, typ, details)
+	if err != nil {
+		return nil, fmt.Errorf("Search (%s): %w", best, err)
+	}
+	if len(ps) != 0 && (len(res.Parcels) == 0 || maxParcelRelevance(ps) > relevance) {
+		res.Parcels = ps
+		res.Corrected = best
+	}
+	return res, nil
+}
@@ -0,0 +1,116 @@
+package parcels
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpellIndex(t *testing.T) {
+	ctx := context.Background()
+	var index spellIndex
+	assert.Empty(t, index.correct(ctx, "аспирн", 3))
+
+	docs := []*Doc{
+		{Id: 1, NameSearchIndex: "аспирин кардио 100мг"},
+		{Id: 2, NameSearchIndex: "аспирин 500мг"},
+		{Id: 3, NameSearchIndex: "аспаркам"},
+		{Id: 4, NameSearchIndex: "кардиомагнил"},
+	}
+	for _, doc := range docs {
+		index.append(doc)
+	}
+
+	// Known query isn't corrected
+	assert.Empty(t, index.correct(ctx, "аспирин кардио", 3))
+
+	res := index.correct(ctx, "Аспирн кардио", 3)
+	require.NotEmpty(t, res)
+	assert.Equal(t, "аспирин кардио", res[0].Query)
+	assert.Equal(t, 1, res[0].Distance)
+	assert.Empty(t, res[0].Layouts)
+
+	// Unknown token without candidates is kept
+	res = index.correct(ctx, "аспирн xyzw", 3)
+	require.NotEmpty(t, res)
+	assert.Equal(t, "аспирин xyzw", res[0].Query)
+
+	// Token is typed in english layout
+	res = index.correct(ctx, "fcgbhby", 3)
+	require.NotEmpty(t, res)
+	assert.Equal(t, "аспирин", res[0].Query)
+	assert.Equal(t, 0, res[0].Distance)
+	assert.Equal(t, []string{"keyboard.en-ru"}, res[0].Layouts)
+
+	// Short tokens are not corrected
+	assert.Empty(t, index.correct(ctx, "ас", 3))
+
+	// Words of removed documents are not proposed and are unlinked from index
+	index.delete(1)
+	index.delete(2)
+	for _, c := range index.correct(ctx, "аспирн", 3) {
+		assert.False(t, c.Query == "аспирин", c.Query)
+	}
+	assert.NotContains(t, index.words, "аспирин")
+	for ngram, list := range index.lists {
+		require.NotEmpty(t, list, ngram)
+		for _, word := range list {
+			assert.True(t, word.count > 0, word.text)
+		}
+	}
+	_, ok := index.lists[" ас"]
+	assert.True(t, ok)
+	_, ok = index.lists["рин"]
+	assert.False(t, ok)
+
+	index.purge()
+	assert.Empty(t, index.correct(ctx, "аспирн", 3))
+}
+
+func TestAdvancedEngineSearchSpelled(t *testing.T) {
+	ctx := context.Background()
+	repo := &parcelRepositoryMock{docs: make(map[int64]string)}
+	docs := &mapDocManager{parcels: repo}
+	engine := newEngineMock(t, docs, DefaultStrategyOptions())
+	for _, doc := range []*Doc{
+		{Id: 2, NameSearchIndex: "аспирин кардио"},
+		{Id: 4, NameSearchIndex: "аспаркам"},
+		{Id: 6, NameSearchIndex: "кардиомагнил"},
+	} {
+		repo.docs[doc.Id] = fmt.Sprintf(`{"name": %q}`, doc.NameSearchIndex)
+		require.NoError(t, engine.strategies.Append(ctx, doc))
+	}
+	details := func() *Details {
+		return &Details{
+			Band:   BandOptions{Capacity: 10},
+			Filter: &resolver{EntityFilterEx: entityFilterMock{}},
+		}
+	}
+
+	// Weak results are kept without rerun
+	weak, err := engine.SearchSpelled(ctx, "аспирн", "name", details(), SpellOptions{Threshold: 0.4})
+	require.NoError(t, err)
+	require.Len(t, weak.Parcels, 1)
+	require.NotEmpty(t, weak.Corrections)
+	assert.Equal(t, "аспирин", weak.Corrections[0].Query)
+	assert.Empty(t, weak.Corrected)
+
+	// Results of the best correction replace weak results
+	res, err := engine.SearchSpelled(ctx, "аспирн", "name", details(), SpellOptions{Threshold: 0.4, Rerun: true})
+	require.NoError(t, err)
+	assert.Equal(t, "аспирин", res.Corrected)
+	assert.Equal(t, weak.Corrections, res.Corrections)
+	require.Len(t, res.Parcels, 1)
+	assert.Equal(t, "аспирин кардио", res.Parcels[0].Name)
+	assert.True(t, res.Parcels[0].Relevance > weak.Parcels[0].Relevance)
+
+	// Strong results aren't corrected
+	res, err = engine.SearchSpelled(ctx, "аспирин", "name", details(), SpellOptions{Threshold: 0.4, Rerun: true})
+	require.NoError(t, err)
+	assert.Len(t, res.Parcels, 1)
+	assert.Empty(t, res.Corrections)
+	assert.Empty(t, res.Corrected)
+}
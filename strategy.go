@@ -2,6 +2,7 @@ package parcels
 
 import (
 	"context"
+	"encoding/gob"
 	"fmt"
 	"math"
 	"strings"
@@ -27,10 +28,10 @@ func (strategy *strategy) Purge(
 func (strategy *strategy) Append(
 	ctx context.Context,
 	doc *Doc,
-) {
+) error {
 	name := strategy.reader(doc)
 	runes := strategy.prepare(ctx, name)
-	strategy.Rule.Append(ctx, doc.Id, runes, 1)
+	return strategy.Rule.Append(ctx, doc.Id, runes, 1)
 }
 
 func (strategy *strategy) Remove(
@@ -52,7 +53,26 @@ func (strategy *strategy) Search(
 		return nil, fmt.Errorf("newResolver: %w", err)
 	}
 	defer resolver.Close(ctx)
-	return resolver.Resolve(ctx, strategy.Rule, runes, 1, details), nil
+	if state := highlightStateFrom(ctx); state != nil {
+		state.source = &highlightSource{
+			reader:  strategy.reader,
+			mutator: strategy.Mutator,
+		}
+	}
+	hs := resolver.Resolve(ctx, strategy.Rule, runes, 1, details)
+	if debug {
+		stats := resolver.Statistics()
+		log.Debugf("RESOLVER CACHE FOR QUERY %q: hits=%d, misses=%d", query, stats.Hits, stats.Misses)
+	}
+	return hs, nil
+}
+
+func (strategy *strategy) BeginBuild(ctx context.Context) {
+	strategy.Rule.BeginBuild(ctx)
+}
+
+func (strategy *strategy) Commit(ctx context.Context) error {
+	return strategy.Rule.Commit(ctx)
 }
 
 func (strategy *strategy) Log(ctx context.Context) {
@@ -63,24 +83,61 @@ func (strategy *strategy) prepare(ctx context.Context, s string) []rune {
 	return strategy.Mutator.Mute(ctx, []rune(s))
 }
 
-/*
-func (strategy *strategy) Load(r io.Reader) error {
-	dec := gob.NewDecoder(r)
-	err := dec.Decode(strategy)
+func (strategy *strategy) Load(dec *gob.Decoder) error {
+	rules, err := persistentRules(strategy.Rule)
 	if err != nil {
-		return fmt.Errorf("Strategy.Load: %w", err)
+		return fmt.Errorf("persistentRules: %w", err)
 	}
+	var count int
+	err = dec.Decode(&count)
+	if err != nil {
+		return fmt.Errorf("Decode count: %w", err)
+	}
+	if count != len(rules) {
+		return fmt.Errorf("rule count mismatch: %d != %d", count, len(rules))
+	}
+
+	for _, rule := range rules {
+		var name string
+		err := dec.Decode(&name)
+		if err != nil {
+			return fmt.Errorf("Decode name: %w", err)
+		}
+		if name != rule.Name() {
+			return fmt.Errorf("rule name mismatch: %q != %q", name, rule.Name())
+		}
+		err = rule.Load(dec)
+		if err != nil {
+			return fmt.Errorf("Load %q: %w", name, err)
+		}
+	}
+
 	return nil
 }
 
-func (strategy *strategy) Save(w io.Writer) error {
-	enc := gob.NewEncoder(w)
-	err := enc.Encode(strategy)
+func (strategy *strategy) Save(enc *gob.Encoder) error {
+	rules, err := persistentRules(strategy.Rule)
 	if err != nil {
-		return fmt.Errorf("Save: %w", err)
+		return fmt.Errorf("persistentRules: %w", err)
 	}
+	err = enc.Encode(len(rules))
+	if err != nil {
+		return fmt.Errorf("Encode count: %w", err)
+	}
+
+	for _, rule := range rules {
+		err := enc.Encode(rule.Name())
+		if err != nil {
+			return fmt.Errorf("Encode name: %w", err)
+		}
+		err = rule.Save(enc)
+		if err != nil {
+			return fmt.Errorf("Save %q: %w", rule.Name(), err)
+		}
+	}
+
 	return nil
-}*/
+}
 
 // NewStrategy is constructor for creating instance of strategy.
 func NewStrategy(
@@ -91,6 +148,7 @@ func NewStrategy(
 	if mutator == nil {
 		mutator = defaultMutator
 	}
+	disableMixedTopK(rule, false, make(map[Rule]bool))
 
 	return &strategy{
 		Rule:    rule,
@@ -166,23 +224,30 @@ type NgramPositionBranchOptions struct {
 }
 
 type NgramBranchOptions struct {
-	Min      int                        `json:"min"`      // Минимальная длина ngram [2..10]
-	Max      int                        `json:"max"`      // Максимальная длина ngram [2..10]
-	Grow     float64                    `json:"grow"`     // Шаг приращения веса более длинной ngram [1..]
-	Weight   float64                    `json:"weight"`   // Вес ветки
-	Position NgramPositionBranchOptions `json:"position"` // Позиционная информация
+	Min        int                        `json:"min"`        // Минимальная длина ngram [2..10]
+	Max        int                        `json:"max"`        // Максимальная длина ngram [2..10]
+	Grow       float64                    `json:"grow"`       // Шаг приращения веса более длинной ngram [1..]
+	Weight     float64                    `json:"weight"`     // Вес ветки
+	Position   NgramPositionBranchOptions `json:"position"`   // Позиционная информация
+	Compressed bool                       `json:"compressed"` // Хранить сжатые списки документов
 }
 
 func (options *NgramBranchOptions) newNgrams(
 	docs DocManager,
 	name string,
 	weight float64,
+	positions NgramIndexPositions,
 	newParser func(len int, home float64) NgramParser,
 ) []*Entry {
 	if weight <= 0 {
 		return nil
 	}
 
+	positions.Weight = options.Position.Weight
+	positions.Query = options.Position.Query
+	positions.Inflation = options.Position.Inflation
+	positions.Compressed = options.Compressed
+
 	home := options.Position.Absolute + options.Position.Relative
 	if home != 0 {
 		home = options.Position.Absolute / home
@@ -201,14 +266,7 @@ func (options *NgramBranchOptions) newNgrams(
 					Weight: ww,
 					Rule: NewNgramRule(
 						fmt.Sprintf("%s%d", name, i),
-						NewNgramIndex(
-							docs,
-							NgramIndexPositions{
-								Weight:    options.Position.Weight,
-								Query:     options.Position.Query,
-								Inflation: options.Position.Inflation,
-							},
-						),
+						NewNgramIndex(docs, positions),
 						newParser(i, home),
 					),
 				},
@@ -221,21 +279,72 @@ func (options *NgramBranchOptions) newNgrams(
 }
 
 type NgramOptions struct {
-	Disabled  bool               `json:"disabled"`  // Ngram searc is disabled
-	Mix       bool               `json:"mix"`       // Разрешить режим микширования
-	Primary   NgramBranchOptions `json:"primary"`   // Ведущая ветка
-	Secondary NgramBranchOptions `json:"secondary"` // Ведомая ветка
+	Disabled  bool                `json:"disabled"`            // Ngram searc is disabled
+	Mix       bool                `json:"mix"`                 // Разрешить режим микширования
+	Mixer     string              `json:"mixer,omitempty"`     // Миксер веток: max, sum, rrf, minmax, zscore. Если пусто, то sum при mix, иначе max
+	Estimator string              `json:"estimator,omitempty"` // Оценка веток: max, topmean, gap, coverage. Если пусто, то max
+	Primary   NgramBranchOptions  `json:"primary"`             // Ведущая ветка
+	Secondary NgramBranchOptions  `json:"secondary"`           // Ведомая ветка
+	Scoring   NgramScoringOptions `json:"scoring"`             // Модель оценки ngram
+	TopK      bool                `json:"top-k"`               // Искать только документы, входящие в полосу. Игнорируется при микшировании
+}
+
+// Mixers of ngram branches, selectable by options
+var ngramMixers = map[string]func() Mixer{
+	"max":    NewMaxMixer,
+	"sum":    NewSumMixer,
+	"rrf":    func() Mixer { return NewRRFMixer(0) },
+	"minmax": NewMinMaxMixer,
+	"zscore": NewZScoreMixer,
+}
+
+// Get name of mixer of branches. Empty mixer is chosen by Mix flag.
+func (options *NgramOptions) mixer() (string, error) {
+	if _, ok := ngramMixers[options.Mixer]; ok {
+		return options.Mixer, nil
+	}
+	if options.Mixer != "" {
+		return "", fmt.Errorf("unknown mixer %q", options.Mixer)
+	}
+	if options.Mix {
+		return "sum", nil
+	}
+	return "max", nil
+}
+
+// Check, that results of several branches are combined.
+// Unknown mixer is reported by constructors of strategy, so it only keeps top-k disabled.
+func (options *NgramOptions) mixing() bool {
+	mixer, err := options.mixer()
+	return err != nil || mixer != "max"
+}
+
+// Get estimator of branches by name. Empty name is max estimator.
+func newNgramEstimator(name string) (Estimator, error) {
+	newEstimator, ok := specEstimators[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown estimator %q", name)
+	}
+	return newEstimator(), nil
 }
 
 func (options *NgramOptions) newNgrams(
 	docs DocManager,
 	name string,
 ) Entries {
+	// Unknown scoring is reported by constructors of strategy
+	scoring, _ := options.Scoring.newScoring()
+	// Top of summed rules can't be bounded by tops of each rule
+	positions := NgramIndexPositions{
+		Scoring: scoring,
+		TopK:    options.TopK && !options.mixing(),
+	}
 	weight := options.Primary.Weight + options.Secondary.Weight
 	entries1 := options.Primary.newNgrams(
 		docs,
 		name+".p",
 		options.Primary.Weight/weight,
+		positions,
 		func(length int, home float64) NgramParser {
 			return NewNgramParserPrimary(
 				length,
@@ -249,6 +358,7 @@ func (options *NgramOptions) newNgrams(
 		docs,
 		name+".s",
 		options.Secondary.Weight/weight,
+		positions,
 		func(length int, home float64) NgramParser {
 			return NewNgramParserSecondary(
 				length,
@@ -266,10 +376,29 @@ func (options *NgramOptions) newNgrams(
 	return entries
 }
 
+type NgramScoringOptions struct {
+	Model string  `json:"model"` // Модель оценки: uniform, idf, bm25. Default uniform
+	K1    float64 `json:"k1"`    // Насыщение BM25 [0..]
+	B     float64 `json:"b"`     // Нормализация BM25 по длине документа [0..1]
+}
+
+func (options *NgramScoringOptions) newScoring() (NgramIndexScoring, error) {
+	model, err := ParseNgramScoring(options.Model)
+	if err != nil {
+		return NgramIndexScoring{}, err
+	}
+	return NgramIndexScoring{
+		Model: model,
+		K1:    options.K1,
+		B:     options.B,
+	}, nil
+}
+
 type NgramTranslators struct {
-	Weight   float64              `json:"weight"`
-	Keyboard NgramKeyboardOptions `json:"keyboard"`
-	Phonetic NgramPhoneticOptions `json:"phonetic"`
+	Weight    float64              `json:"weight"`
+	Estimator string               `json:"estimator,omitempty"` // Оценка выбора раскладки: max, topmean, gap, coverage. Если пусто, то max
+	Keyboard  NgramKeyboardOptions `json:"keyboard"`
+	Phonetic  NgramPhoneticOptions `json:"phonetic"`
 }
 
 type NgramKeyboardOptions struct {
@@ -285,14 +414,16 @@ type NgramPhoneticOptions struct {
 }
 
 type BandOptions struct {
+	Mode      string          `json:"mode"`      // Mode of cutoff: default, absolute, relative, top, knee. Default uses threshold, rel and abs
 	Capacity  int             `json:"capacity"`  // Maximal count in band. Default 100
 	Threshold float64         `json:"threshold"` // The absolute relevance value for accept
 	Diff      BandDiffOptions `json:"diff"`      // Differential
+	Knee      float64         `json:"knee"`      // The minimal drop at knee as share of relevance range [0..1]. Default 0.
 }
 
 type BandDiffOptions struct {
-	Rel float64 `json:"rel"` // The maximum difference between two adjacent lines [0..1]. Default 0.
-	Abs float64 `json:"abs"` // The maximum difference between first and current lines (cur/max) [0..1]. Default 0.
+	Rel float64 `json:"rel"` // The minimal ratio of current line to the previous one (cur/prev) [0..1]. Default 0.
+	Abs float64 `json:"abs"` // The minimal ratio of current line to the first one (cur/max) [0..1]. Default 0.
 }
 
 type StrategyOptions struct {
@@ -301,6 +432,7 @@ type StrategyOptions struct {
 	Metaphone   MetaphoneOptions `json:"metaphone"`
 	Band        BandOptions      `json:"band"`
 	Group       bool             `json:"group"`
+	Spec        *StrategySpec    `json:"spec,omitempty"` // Граф правил стратегии. Если пусто, то граф NewStrategyDefault
 }
 
 func DefaultStrategyOptions() *StrategyOptions {
@@ -336,6 +468,11 @@ func DefaultStrategyOptions() *StrategyOptions {
 					Relative:  0,
 				},
 			},
+			Scoring: NgramScoringOptions{
+				Model: "uniform",
+				K1:    1.2,
+				B:     0.75,
+			},
 		},
 		Translators: NgramTranslators{
 			Weight: 0,
@@ -355,23 +492,51 @@ func (m *muteRoot) Mute(ctx context.Context, runes []rune) []rune {
 
 var rootMute = new(muteRoot)
 
+// NewStrategyByOptions is constructor of strategy by spec of options.
+// Strategy of NewStrategyDefault is used, if options have no spec.
+func NewStrategyByOptions(
+	docs DocManager,
+	options *StrategyOptions,
+	reader Reader,
+) (Strategy, error) {
+	if options == nil || options.Spec == nil {
+		return NewStrategyDefault(docs, options, reader)
+	}
+	return NewStrategyFromSpec(docs, options, options.Spec, reader)
+}
+
 func NewStrategyDefault(
 	docs DocManager,
 	options *StrategyOptions,
 	reader Reader,
-) Strategy {
+) (Strategy, error) {
 	if options == nil {
 		options = DefaultStrategyOptions()
 	}
 
 	var merge func(name string, entries Entries) Rule
 
-	var estimator Estimator
-	estimator = NewMaxEstimator()
+	estimator, err := newNgramEstimator(options.Ngrams.Estimator)
+	if err != nil {
+		return nil, fmt.Errorf("newNgramEstimator: %w", err)
+	}
+	layoutEstimator, err := newNgramEstimator(options.Translators.Estimator)
+	if err != nil {
+		return nil, fmt.Errorf("newNgramEstimator: %w", err)
+	}
+	mixer, err := options.Ngrams.mixer()
+	if err != nil {
+		return nil, fmt.Errorf("mixer: %w", err)
+	}
+	_, err = options.Ngrams.Scoring.newScoring()
+	if err != nil {
+		return nil, fmt.Errorf("newScoring: %w", err)
+	}
 
-	if options.Ngrams.Mix {
+	if mixer != "max" {
+		newMixer := ngramMixers[mixer]
 		merge = func(name string, entries Entries) Rule {
-			return NewMultiRule(name+".mix", entries, estimator, NewSumMixer())
+			return NewMultiRule(name+".mix", entries, estimator, newMixer())
 		}
 	} else {
 		merge = func(name string, entries Entries) Rule {
@@ -515,11 +680,11 @@ func NewStrategyDefault(
 			rule,
 			translators,
 			options.Translators.Weight,
-			estimator,
+			layoutEstimator,
 		)
 	}
 
-	return NewStrategy(rootMute, rule, reader)
+	return NewStrategy(rootMute, rule, reader), nil
 }
 
 /*
@@ -644,24 +809,32 @@ func (strategy *exactStrategy) Log(ctx context.Context) {
 	// nothing
 }
 
-/*
-func (strategy *exactStrategy) Load(r io.Reader) error {
+func (strategy *exactStrategy) Load(dec *gob.Decoder) error {
 	return nil
 }
 
-func (strategy *exactStrategy) Save(w io.Writer) error {
+func (strategy *exactStrategy) Save(enc *gob.Encoder) error {
 	return nil
-}*/
+}
 
 func (strategy *exactStrategy) Purge(ctx context.Context) error {
 	return nil
 }
 
+func (strategy *exactStrategy) BeginBuild(ctx context.Context) {
+	// nothing
+}
+
+func (strategy *exactStrategy) Commit(ctx context.Context) error {
+	return nil
+}
+
 func (strategy *exactStrategy) Append(
 	ctx context.Context,
 	doc *Doc,
-) {
+) error {
 	// nothing
+	return nil
 }
 
 func (strategy *exactStrategy) Remove(
@@ -751,13 +924,37 @@ func (strategy multiStrategy) Purge(
 	return nil
 }
 
+func (strategy multiStrategy) BeginBuild(
+	ctx context.Context,
+) {
+	for _, s := range strategy {
+		s.BeginBuild(ctx)
+	}
+}
+
+func (strategy multiStrategy) Commit(
+	ctx context.Context,
+) error {
+	for _, s := range strategy {
+		err := s.Commit(ctx)
+		if err != nil {
+			return fmt.Errorf("Commit: %w", err)
+		}
+	}
+	return nil
+}
+
 func (strategy multiStrategy) Append(
 	ctx context.Context,
 	doc *Doc,
-) {
+) error {
 	for _, s := range strategy {
-		s.Append(ctx, doc)
+		err := s.Append(ctx, doc)
+		if err != nil {
+			return fmt.Errorf("Append: %w", err)
+		}
 	}
+	return nil
 }
 
 func (strategy multiStrategy) Remove(
@@ -769,6 +966,30 @@ func (strategy multiStrategy) Remove(
 	}
 }
 
+func (strategy multiStrategy) Load(
+	dec *gob.Decoder,
+) error {
+	for _, s := range strategy {
+		err := s.Load(dec)
+		if err != nil {
+			return fmt.Errorf("Load: %w", err)
+		}
+	}
+	return nil
+}
+
+func (strategy multiStrategy) Save(
+	enc *gob.Encoder,
+) error {
+	for _, s := range strategy {
+		err := s.Save(enc)
+		if err != nil {
+			return fmt.Errorf("Save: %w", err)
+		}
+	}
+	return nil
+}
+
 func (strategy multiStrategy) Search(
 	ctx context.Context,
 	manager Manager,
@@ -853,10 +1074,22 @@ func (strategy *innStrategy) Purge(
 	return nil
 }
 
+func (strategy *innStrategy) BeginBuild(
+	ctx context.Context,
+) {
+}
+
+func (strategy *innStrategy) Commit(
+	ctx context.Context,
+) error {
+	return nil
+}
+
 func (strategy *innStrategy) Append(
 	ctx context.Context,
 	doc *Doc,
-) {
+) error {
+	return nil
 }
 
 func (strategy *innStrategy) Remove(
@@ -865,6 +1098,18 @@ func (strategy *innStrategy) Remove(
 ) {
 }
 
+func (strategy *innStrategy) Load(
+	dec *gob.Decoder,
+) error {
+	return nil
+}
+
+func (strategy *innStrategy) Save(
+	enc *gob.Encoder,
+) error {
+	return nil
+}
+
 func (strategy *innStrategy) Search(
 	ctx context.Context,
 	manager Manager,
@@ -916,10 +1161,22 @@ func (strategy *makerStrategy) Purge(
 	return nil
 }
 
+func (strategy *makerStrategy) BeginBuild(
+	ctx context.Context,
+) {
+}
+
+func (strategy *makerStrategy) Commit(
+	ctx context.Context,
+) error {
+	return nil
+}
+
 func (strategy *makerStrategy) Append(
 	ctx context.Context,
 	doc *Doc,
-) {
+) error {
+	return nil
 }
 
 func (strategy *makerStrategy) Remove(
@@ -928,6 +1185,18 @@ func (strategy *makerStrategy) Remove(
 ) {
 }
 
+func (strategy *makerStrategy) Load(
+	dec *gob.Decoder,
+) error {
+	return nil
+}
+
+func (strategy *makerStrategy) Save(
+	enc *gob.Encoder,
+) error {
+	return nil
+}
+
 func (strategy *makerStrategy) Search(
 	ctx context.Context,
 	manager Manager,
@@ -967,10 +1236,22 @@ func (strategy *primarySearchStrategy) Purge(
 	return nil
 }
 
+func (strategy *primarySearchStrategy) BeginBuild(
+	ctx context.Context,
+) {
+}
+
+func (strategy *primarySearchStrategy) Commit(
+	ctx context.Context,
+) error {
+	return nil
+}
+
 func (strategy *primarySearchStrategy) Append(
 	ctx context.Context,
 	doc *Doc,
-) {
+) error {
+	return nil
 }
 
 func (strategy *primarySearchStrategy) Remove(
@@ -979,6 +1260,18 @@ func (strategy *primarySearchStrategy) Remove(
 ) {
 }
 
+func (strategy *primarySearchStrategy) Load(
+	dec *gob.Decoder,
+) error {
+	return nil
+}
+
+func (strategy *primarySearchStrategy) Save(
+	enc *gob.Encoder,
+) error {
+	return nil
+}
+
 func (strategy *primarySearchStrategy) Search(
 	ctx context.Context,
 	manager Manager,
@@ -0,0 +1,398 @@
+package parcels
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Fields of suggestions
+const (
+	SuggestName = "name" // Group of name
+	SuggestInn  = "inn"  // Group of active substance
+)
+
+var suggestReaders = []struct {
+	field  string
+	reader Reader
+}{
+	{SuggestName, docNameGroupIndexReader},
+	{SuggestInn, docInnGroupIndexReader},
+}
+
+// Keyboard layouts, which are tried, if prefix is typed in wrong layout
+var suggestLayouts = []string{
+	"keyboard.en-ru",
+	"keyboard.en-ua",
+	"keyboard.ru-ua",
+	"keyboard.ua-ru",
+}
+
+// Default count of suggestions
+const defaultSuggestLimit = 10
+
+// Suggestion is group name, which starts with prefix.
+type Suggestion struct {
+	Value  string `json:"value"`
+	Field  string `json:"field"`  // Field of group: name, inn
+	Count  int    `json:"count"`  // Count of documents of group
+	Layout string `json:"layout"` // Keyboard layout of prefix, if prefix is typed in wrong layout
+}
+
+// Suggester is manager, that can suggest group names by prefix.
+type Suggester interface {
+	Suggest(ctx context.Context, prefix string, limit int) ([]*Suggestion, error)
+}
+
+type suggestKey struct {
+	field string
+	value string
+}
+
+// suggestTerm is distinct group name with count of its documents.
+// Term is removed from index with its last document.
+type suggestTerm struct {
+	suggestKey
+	count int
+}
+
+// suggestNode is node of prefix tree.
+// Node holds terms, which have word equal to path of node.
+// Bounds of counts let search skip subtrees, which can't enter suggestions.
+// Bounds are not lowered by removal of documents, so they are upper bounds.
+type suggestNode struct {
+	children map[rune]*suggestNode
+	terms    []suggestWord
+	head     int // Bound of count of terms in subtree, which are matched by the first word
+	best     int // Bound of count of all terms in subtree
+}
+
+// suggestWord is word of term. Word 0 is the beginning of term.
+type suggestWord struct {
+	term  *suggestTerm
+	index int
+}
+
+// suggestIndex is prefix tree over words of group names.
+// Index is built alongside strategies and is safe for concurrent use.
+type suggestIndex struct {
+	sync.RWMutex
+	root  *suggestNode
+	terms map[suggestKey]*suggestTerm
+	docs  map[int64][]suggestKey // Terms of documents
+}
+
+// Split value into lowercase words
+func suggestWords(value string) []string {
+	runes := SkipPunct(context.Background(), []rune(strings.ToLower(value)))
+	return strings.Fields(string(runes))
+}
+
+func (index *suggestIndex) purge() {
+	index.Lock()
+	defer index.Unlock()
+	index.root = nil
+	index.terms = nil
+	index.docs = nil
+}
+
+func (index *suggestIndex) append(doc *Doc) {
+	index.Lock()
+	defer index.Unlock()
+	if index.root == nil {
+		index.root = new(suggestNode)
+		index.terms = make(map[suggestKey]*suggestTerm)
+		index.docs = make(map[int64][]suggestKey)
+	}
+
+	// Document may be updated
+	index.remove(doc.Id)
+	var keys []suggestKey
+	for _, r := range suggestReaders {
+		value := strings.TrimSpace(r.reader(doc))
+		if value == "" {
+			continue
+		}
+		key := suggestKey{field: r.field, value: value}
+		term, ok := index.terms[key]
+		if !ok {
+			term = &suggestTerm{suggestKey: key}
+			index.terms[key] = term
+			index.insert(term)
+		}
+		term.count++
+		index.raise(term)
+		keys = append(keys, key)
+	}
+	if len(keys) != 0 {
+		index.docs[doc.Id] = keys
+	}
+}
+
+// Get paths of term words in tree.
+// Path of word runs till the end of term, so prefix may include next words.
+func suggestPaths(value string) []string {
+	words := suggestWords(value)
+	res := make([]string, len(words))
+	for i := range words {
+		res[i] = strings.Join(words[i:], " ")
+	}
+	return res
+}
+
+// Insert words of term into tree
+func (index *suggestIndex) insert(term *suggestTerm) {
+	for i, path := range suggestPaths(term.value) {
+		node := index.root
+		for _, r := range path {
+			child, ok := node.children[r]
+			if !ok {
+				if node.children == nil {
+					node.children = make(map[rune]*suggestNode)
+				}
+				child = new(suggestNode)
+				node.children[r] = child
+			}
+			node = child
+		}
+		node.terms = append(node.terms, suggestWord{term: term, index: i})
+	}
+}
+
+// Raise bounds of nodes on paths of term words
+func (index *suggestIndex) raise(term *suggestTerm) {
+	for i, path := range suggestPaths(term.value) {
+		node := index.root
+		for _, r := range path {
+			node = node.children[r]
+			if i == 0 && node.head < term.count {
+				node.head = term.count
+			}
+			if node.best < term.count {
+				node.best = term.count
+			}
+		}
+	}
+}
+
+// Remove words of term from tree. Empty nodes are removed too.
+func (index *suggestIndex) unlink(term *suggestTerm) {
+	for i, path := range suggestPaths(term.value) {
+		runes := []rune(path)
+		nodes := make([]*suggestNode, len(runes)+1)
+		nodes[0] = index.root
+		for j, r := range runes {
+			nodes[j+1] = nodes[j].children[r]
+		}
+
+		node := nodes[len(runes)]
+		for j, w := range node.terms {
+			if w.term == term && w.index == i {
+				node.terms = append(node.terms[:j], node.terms[j+1:]...)
+				break
+			}
+		}
+		for j := len(runes); j > 0; j-- {
+			if len(nodes[j].terms) != 0 || len(nodes[j].children) != 0 {
+				break
+			}
+			delete(nodes[j-1].children, runes[j-1])
+		}
+	}
+}
+
+func (index *suggestIndex) delete(id int64) {
+	index.Lock()
+	defer index.Unlock()
+	index.remove(id)
+}
+
+func (index *suggestIndex) remove(id int64) {
+	for _, key := range index.docs[id] {
+		if term, ok := index.terms[key]; ok {
+			term.count--
+			if term.count <= 0 {
+				index.unlink(term)
+				delete(index.terms, key)
+			}
+		}
+	}
+	delete(index.docs, id)
+}
+
+// suggestMatch is term, found by prefix
+type suggestMatch struct {
+	term   *suggestTerm
+	word   int    // The first word, which starts with prefix
+	layout string // Layout of prefix
+}
+
+// suggestItem is node of tree or word of term in queue of search.
+// Node has the best key, which its words can have.
+type suggestItem struct {
+	node  *suggestNode
+	word  suggestWord
+	head  bool // Word is the first word of term
+	count int
+}
+
+// Check, that item goes before other item in order of suggestMatch.
+// Node goes before words with the same key, so all such words are in queue, when they are taken.
+func (item *suggestItem) less(other *suggestItem) bool {
+	if item.head != other.head {
+		return item.head
+	}
+	if item.count != other.count {
+		return item.count > other.count
+	}
+	if (item.node == nil) != (other.node == nil) {
+		return item.node != nil
+	}
+	if item.node != nil {
+		return false
+	}
+	a, b := item.word.term, other.word.term
+	if a.value != b.value {
+		return a.value < b.value
+	}
+	return a.field < b.field
+}
+
+// suggestQueue is queue of search, the best item is the first.
+type suggestQueue []*suggestItem
+
+func (q suggestQueue) Len() int            { return len(q) }
+func (q suggestQueue) Less(i, j int) bool  { return q[i].less(q[j]) }
+func (q suggestQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *suggestQueue) Push(x interface{}) { *q = append(*q, x.(*suggestItem)) }
+func (q *suggestQueue) Pop() interface{} {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+func (q *suggestQueue) pushNode(node *suggestNode) {
+	if node.head > 0 {
+		heap.Push(q, &suggestItem{node: node, head: true, count: node.head})
+	} else {
+		heap.Push(q, &suggestItem{node: node, count: node.best})
+	}
+}
+
+// Find limit of the best terms, which have word starting with prefix.
+// Subtrees are searched from the best bound, so search stops, when limit is found.
+func (index *suggestIndex) find(prefix string, layout string, limit int, matches map[suggestKey]*suggestMatch) {
+	node := index.root
+	for _, r := range prefix {
+		node = node.children[r]
+		if node == nil {
+			return
+		}
+	}
+
+	found := make(map[*suggestTerm]bool, limit)
+	var queue suggestQueue
+	queue.pushNode(node)
+	for len(queue) != 0 && len(found) < limit {
+		item := heap.Pop(&queue).(*suggestItem)
+		if item.node == nil {
+			// The first word of term has the best key
+			w := item.word
+			if found[w.term] {
+				continue
+			}
+			found[w.term] = true
+			m := &suggestMatch{term: w.term, word: w.index, layout: layout}
+			if old, ok := matches[w.term.suggestKey]; !ok || m.less(old) {
+				matches[w.term.suggestKey] = m
+			}
+			continue
+		}
+
+		for _, w := range item.node.terms {
+			heap.Push(&queue, &suggestItem{word: w, head: w.index == 0, count: w.term.count})
+		}
+		for _, child := range item.node.children {
+			queue.pushNode(child)
+		}
+	}
+}
+
+// Matches in typed layout go first, then matches of term beginning, then popular terms
+func (m *suggestMatch) less(other *suggestMatch) bool {
+	if (m.layout == "") != (other.layout == "") {
+		return m.layout == ""
+	}
+	if (m.word == 0) != (other.word == 0) {
+		return m.word == 0
+	}
+	if m.term.count != other.term.count {
+		return m.term.count > other.term.count
+	}
+	if m.term.value != other.term.value {
+		return m.term.value < other.term.value
+	}
+	return m.term.field < other.term.field
+}
+
+func (index *suggestIndex) suggest(ctx context.Context, prefix string, limit int) []*Suggestion {
+	if limit <= 0 {
+		limit = defaultSuggestLimit
+	}
+	words := suggestWords(prefix)
+	if len(words) == 0 {
+		return nil
+	}
+	prefix = strings.Join(words, " ")
+
+	index.RLock()
+	defer index.RUnlock()
+	if index.root == nil {
+		return nil
+	}
+
+	matches := make(map[suggestKey]*suggestMatch)
+	index.find(prefix, "", limit, matches)
+	if len(matches) < limit {
+		for _, name := range suggestLayouts {
+			translated := specLayouts[name]().Translate(ctx, []rune(prefix), nil)
+			if len(translated) == 0 || string(translated) == prefix {
+				continue
+			}
+			index.find(string(translated), name, limit, matches)
+		}
+	}
+
+	list := make([]*suggestMatch, 0, len(matches))
+	for _, m := range matches {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].less(list[j]) })
+	if len(list) > limit {
+		list = list[:limit]
+	}
+
+	res := make([]*Suggestion, len(list))
+	for i, m := range list {
+		res[i] = &Suggestion{
+			Value:  m.term.value,
+			Field:  m.term.field,
+			Count:  m.term.count,
+			Layout: m.layout,
+		}
+	}
+	return res
+}
+
+// Suggest suggests names and active substances of groups by prefix.
+// Full search isn't run, so suggestions are cheap for every keystroke.
+func (engine *advancedEngine) Suggest(
+	ctx context.Context,
+	prefix string,
+	limit int,
+) ([]*Suggestion, error) {
+	return engine.strategies.suggests.suggest(ctx, prefix, limit), nil
+}
@@ -0,0 +1,126 @@
+package parcels
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSuggestIndex(t *testing.T) {
+	ctx := context.Background()
+	var index suggestIndex
+	assert.Empty(t, index.suggest(ctx, "асп", 10))
+
+	docs := []*Doc{
+		{Id: 1, NameGroupIndex: "Аспирин", InnGroupIndex: "Ацетилсалициловая кислота"},
+		{Id: 2, NameGroupIndex: "Аспирин", InnGroupIndex: "Ацетилсалициловая кислота"},
+		{Id: 3, NameGroupIndex: "Аспаркам", InnGroupIndex: "Калия и магния аспарагинат"},
+		{Id: 4, NameGroupIndex: "Кардиомагнил", InnGroupIndex: "Ацетилсалициловая кислота"},
+		{Id: 5, NameGroupIndex: "Аскорбиновая кислота"},
+	}
+	for _, doc := range docs {
+		index.append(doc)
+	}
+
+	values := func(ss []*Suggestion) []string {
+		res := make([]string, len(ss))
+		for i, s := range ss {
+			res[i] = s.Value
+		}
+		return res
+	}
+
+	// Beginning of group goes before words inside, popular groups go first
+	res := index.suggest(ctx, "Асп", 10)
+	assert.Equal(t, []string{"Аспирин", "Аспаркам", "Калия и магния аспарагинат"}, values(res))
+	assert.Equal(t, SuggestName, res[0].Field)
+	assert.Equal(t, 2, res[0].Count)
+	assert.Equal(t, SuggestInn, res[2].Field)
+	assert.Empty(t, res[0].Layout)
+
+	assert.Equal(t, []string{"Аспирин"}, values(index.suggest(ctx, "асп", 1)))
+	assert.Equal(
+		t,
+		[]string{"Ацетилсалициловая кислота", "Аскорбиновая кислота"},
+		values(index.suggest(ctx, "кисл", 10)),
+	)
+	// Prefix may include several words
+	assert.Equal(t, []string{"Аскорбиновая кислота"}, values(index.suggest(ctx, "аскорбиновая  кис", 10)))
+
+	// Prefix is typed in english layout
+	res = index.suggest(ctx, "fcg", 10)
+	require.NotEmpty(t, res)
+	assert.Equal(t, "Аспирин", res[0].Value)
+	assert.Equal(t, "keyboard.en-ru", res[0].Layout)
+
+	// Groups of removed documents aren't suggested
+	index.delete(3)
+	assert.Equal(t, []string{"Аспирин"}, values(index.suggest(ctx, "асп", 10)))
+	// Updated document is moved to another group
+	index.append(&Doc{Id: 2, NameGroupIndex: "Аспаркам"})
+	res = index.suggest(ctx, "асп", 10)
+	require.Len(t, res, 2)
+	assert.Equal(t, 1, res[0].Count)
+	assert.Equal(t, 1, res[1].Count)
+
+	index.purge()
+	assert.Empty(t, index.suggest(ctx, "асп", 10))
+}
+
+func TestSuggestIndexRemoveTerms(t *testing.T) {
+	ctx := context.Background()
+	var index suggestIndex
+	index.append(&Doc{Id: 1, NameGroupIndex: "Аспирин"})
+	index.append(&Doc{Id: 2, NameGroupIndex: "Аспаркам"})
+
+	// Term of the last document is removed with its words
+	index.delete(1)
+	assert.Len(t, index.terms, 1)
+	node := index.root
+	for _, r := range "асп" {
+		node = node.children[r]
+		require.NotNil(t, node)
+	}
+	assert.Len(t, node.children, 1)
+	assert.Nil(t, node.children['и'])
+
+	index.delete(2)
+	assert.Empty(t, index.terms)
+	assert.Empty(t, index.root.children)
+	assert.Empty(t, index.suggest(ctx, "асп", 10))
+}
+
+func TestSuggestIndexLimit(t *testing.T) {
+	ctx := context.Background()
+	var index suggestIndex
+	var id int64
+	for i := 0; i < 40; i++ {
+		// Popularity doesn't follow order of names, group of the first word goes after groups of word beginning
+		value := fmt.Sprintf("асп%02d", (i*7)%40)
+		if i%5 == 0 {
+			value = "таблетки " + value
+		}
+		for j := 0; j <= i%6; j++ {
+			id++
+			index.append(&Doc{Id: id, NameGroupIndex: value})
+		}
+	}
+	// Removed documents lower counts, but not bounds of tree
+	for id := int64(1); id <= 30; id += 3 {
+		index.delete(id)
+	}
+
+	// Limit above count of terms walks the whole subtree
+	all := index.suggest(ctx, "асп", 100)
+	require.Len(t, all, len(index.terms))
+	for i := 1; i < len(all); i++ {
+		assert.True(t, all[i-1].Count >= all[i].Count || strings.HasPrefix(all[i-1].Value, "асп"))
+	}
+	for _, limit := range []int{1, 3, 7, 20} {
+		assert.Equal(t, all[:limit], index.suggest(ctx, "асп", limit), limit)
+	}
+}
@@ -0,0 +1,215 @@
+package parcels
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+)
+
+// Upper bound of reference weight
+const maxRefWeight = 1
+
+// Top-k search of rule fetches more documents, than band holds,
+// because entity filter rejects some of them after search.
+const topKOverfetch = 2
+
+// topKState tracks truncation of results by top-k search during single search.
+// Top is truncated before entity filter, so filter can reject all candidates of band.
+// Engine repeats only such search with full search of rules: second search is cheaper,
+// than full search of every query. Bands, cut by capacity, threshold or other cutters, are not repeated.
+type topKState struct {
+	full      bool // Rules search all documents
+	truncated bool // Some rule returned complete top, so documents out of top were dropped
+	rejected  bool // Entity filter rejected documents, and band ran out of candidates
+}
+
+type topKStateKey struct{}
+
+func topKStateFrom(ctx context.Context) *topKState {
+	state, _ := ctx.Value(topKStateKey{}).(*topKState)
+	return state
+}
+
+func withTopKState(ctx context.Context, state *topKState) context.Context {
+	return context.WithValue(ctx, topKStateKey{}, state)
+}
+
+// Disable top-k search of ngram rules below mixers other than max.
+// Top of mixed branches can't be bounded by tops of each branch,
+// so only rules, which reach the root through max mixers, keep top-k search.
+func disableMixedTopK(rule Rule, mixed bool, visited map[Rule]bool) {
+	if e, ok := rule.(*Entry); ok {
+		rule = e.Rule
+	}
+	if done, ok := visited[rule]; ok && (done || !mixed) {
+		return
+	}
+	visited[rule] = mixed
+	switch r := rule.(type) {
+	case *MultiRule:
+		if _, ok := r.Mixer.(*maxMixer); !ok {
+			mixed = true
+		}
+	case *ngramRule:
+		if index, ok := r.Index.(*ngramIndex); ok && mixed {
+			index.Position.TopK = false
+		}
+	}
+	for _, child := range rule.Children() {
+		disableMixedTopK(child, mixed, visited)
+	}
+}
+
+// topHypothesis is document in top of search.
+type topHypothesis struct {
+	doc int64
+	rel float64
+}
+
+// topHeap is min-heap of top documents, the worst document is the first.
+type topHeap []topHypothesis
+
+func (h topHeap) Len() int            { return len(h) }
+func (h topHeap) Less(i, j int) bool  { return h[i].rel < h[j].rel }
+func (h topHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *topHeap) Push(x interface{}) { *h = append(*h, x.(topHypothesis)) }
+func (h *topHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// topTerm is posting list of query ngram in top-k search.
+type topTerm struct {
+	cursor refCursor
+	ngram  NgramEntry
+	rel    float64 // Weight of ngram in query
+	bound  float64 // Upper bound of ngram contribution
+}
+
+// SearchTop searches only documents, that can enter top of limit documents.
+// Search is done document-at-a-time with MaxScore pruning: posting lists
+// are ordered by upper bound of contribution, and lists, which can't make
+// top document on their own, are only probed for candidates of other lists.
+// Full search is used, if top-k mode is disabled or limit is not positive.
+func (index *ngramIndex) SearchTop(
+	query []NgramEntry,
+	weight float64,
+	limit int,
+) Hypotheses {
+	if !index.Position.TopK || limit <= 0 {
+		return index.Search(query, weight)
+	}
+	if len(query) == 0 {
+		return newHypotheses()
+	}
+
+	segment := index.segment()
+	scoring := index.Position.Scoring
+	weights := scoring.weights(segment, query)
+	avgLen := segment.avgLen()
+	posWeight := index.Position.Weight
+
+	terms := make([]*topTerm, 0, len(query))
+	for i, ngram := range query {
+		if rs, ok := segment.find(ngram.Id); ok {
+			terms = append(
+				terms,
+				&topTerm{
+					cursor: rs.Cursor(),
+					ngram:  ngram,
+					rel:    weights[i],
+					bound:  weights[i] * (0.5*maxRefWeight + 0.5*weight),
+				},
+			)
+		}
+	}
+	sort.Slice(terms, func(i, j int) bool { return terms[i].bound < terms[j].bound })
+
+	// Summary bound of terms[:i]
+	prefix := make([]float64, len(terms)+1)
+	for i, t := range terms {
+		prefix[i+1] = prefix[i] + t.bound
+	}
+	// Upper bound of relevance for document with partial relevance rel
+	// and unknown contribution of terms[:i]
+	upper := func(rel float64, i int) float64 {
+		return (rel+prefix[i])*(1-posWeight) + posWeight
+	}
+	match := func(t *topTerm, r Ref) (float64, float64) {
+		rel := t.rel * scoring.norm(r.Len, avgLen) * (0.5*r.Weight + 0.5*weight)
+		pos := index.Position.Pattern*float64(r.Pos) + index.Position.Query*float64(t.ngram.Pos)
+		return rel, pos
+	}
+
+	top := make(topHeap, 0, limit)
+	threshold := -1.0
+	essential := 0 // Terms before essential can't make top document on their own
+	for {
+		// The next candidate is the least document of essential terms
+		var doc int64
+		var found bool
+		for _, t := range terms[essential:] {
+			if r, ok := t.cursor.Ref(); ok && (!found || r.Doc < doc) {
+				doc = r.Doc
+				found = true
+			}
+		}
+		if !found {
+			break
+		}
+
+		var rel, pos float64
+		for _, t := range terms[essential:] {
+			if r, ok := t.cursor.Ref(); ok && r.Doc == doc {
+				rl, ps := match(t, r)
+				rel += rl
+				pos += ps
+				t.cursor.Next()
+			}
+		}
+
+		// Probe non-essential terms, while document can enter top
+		skip := false
+		for i := essential - 1; i >= 0; i-- {
+			if upper(rel, i+1) < threshold {
+				skip = true
+				break
+			}
+			t := terms[i]
+			t.cursor.Advance(doc)
+			if r, ok := t.cursor.Ref(); ok && r.Doc == doc {
+				rl, ps := match(t, r)
+				rel += rl
+				pos += ps
+			}
+		}
+		if skip {
+			continue
+		}
+
+		h := topHypothesis{doc: doc, rel: index.relevance(rel, pos)}
+		if len(top) < limit {
+			heap.Push(&top, h)
+		} else if h.rel > top[0].rel {
+			top[0] = h
+			heap.Fix(&top, 0)
+		} else {
+			continue
+		}
+
+		if len(top) == limit {
+			threshold = top[0].rel
+			for essential < len(terms) && upper(0, essential+1) < threshold {
+				essential++
+			}
+		}
+	}
+
+	hs := make(Hypotheses, len(top))
+	for _, h := range top {
+		hs[h.doc] = h.rel
+	}
+	return hs
+}
@@ -0,0 +1,195 @@
+package parcels
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"testing"
+
+	"spWebFront/FrontKeeper/infrastructure/core"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTopTestRule(b testing.TB, positions NgramIndexPositions, names []string) *ngramRule {
+	ctx := context.Background()
+	rule := NewNgramRule(
+		"ngram.3",
+		NewNgramIndex(nil, positions),
+		NewNgramParserPrimary(3, NewParserEstimatorPrimary(1)),
+	).(*ngramRule)
+	rule.BeginBuild(ctx)
+	for i, name := range names {
+		require.NoError(b, rule.Append(ctx, int64(i+1), []rune(name), 1))
+	}
+	require.NoError(b, rule.Commit(ctx))
+	return rule
+}
+
+// Get relevance values of top documents in descending order
+func topRelevance(hs Hypotheses, limit int) []float64 {
+	res := make([]float64, 0, len(hs))
+	for _, rel := range hs {
+		res = append(res, rel)
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(res)))
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res
+}
+
+func TestNgramIndexSearchTop(t *testing.T) {
+	ctx := context.Background()
+	names := newSyntheticNames(2000)
+	queries := []string{"аспирин", "ибупрофен таблетки", "мазь", names[10], names[500][:8]}
+
+	for _, positions := range []NgramIndexPositions{
+		{TopK: true},
+		{TopK: true, Weight: 0.3, Inflation: 0.3},
+		{TopK: true, Weight: 0.3, Inflation: 0.3, Compressed: true},
+		{TopK: true, Scoring: NgramIndexScoring{Model: NgramScoringBM25, K1: 1.2, B: 0.75}},
+	} {
+		rule := newTopTestRule(t, positions, names)
+		for _, query := range queries {
+			ngrams, err := rule.Parser.Parse(ctx, []rune(query), false)
+			require.NoError(t, err)
+
+			all := rule.Index.Search(ngrams, 1)
+			for _, limit := range []int{1, 10, 100} {
+				top := rule.Index.SearchTop(ngrams, 1, limit)
+				assert.LessOrEqual(t, len(top), limit)
+				assert.InDeltaSlice(t, topRelevance(all, limit), topRelevance(top, limit), 1e-9, query)
+				for doc, rel := range top {
+					assert.InDelta(t, all[doc], rel, 1e-9, query)
+				}
+			}
+
+			// Full search without limit
+			assert.Equal(t, len(all), len(rule.Index.SearchTop(ngrams, 1, 0)))
+		}
+	}
+}
+
+// Filter accepts every fourth document
+type sparseFilterMock struct{}
+
+func (f sparseFilterMock) Filter(ctx context.Context, id int64, attrs map[string]interface{}) error {
+	if id%4 != 0 {
+		return core.ErrAbort
+	}
+	return nil
+}
+
+func (f sparseFilterMock) Release(ctx context.Context) error {
+	return nil
+}
+
+func TestAdvancedEngineSearchTopFiltered(t *testing.T) {
+	ctx := context.Background()
+	repo := &parcelRepositoryMock{docs: make(map[int64]string)}
+	docs := &mapDocManager{parcels: repo}
+	options := DefaultStrategyOptions()
+	options.Ngrams.TopK = true
+	engine := newEngineMock(t, docs, options)
+	for i := 1; i <= 100; i++ {
+		name := fmt.Sprintf("аскорбинка %d", i)
+		repo.docs[int64(i)] = fmt.Sprintf(`{"name": %q}`, name)
+		require.NoError(t, engine.strategies.Append(ctx, &Doc{Id: int64(i), NameSearchIndex: name}))
+	}
+
+	// Over-fetched top of 20 documents has only 5 accepted documents, so search is repeated
+	state := &topKState{}
+	details := &Details{
+		Band:   BandOptions{Capacity: 10},
+		Filter: &resolver{EntityFilterEx: sparseFilterMock{}},
+	}
+	ps, err := engine.Search(withTopKState(ctx, state), "аскорбинка", "name", details)
+	require.NoError(t, err)
+	assert.True(t, state.truncated)
+	assert.True(t, state.full)
+	require.Len(t, ps, 10)
+	for _, p := range ps {
+		var attrs struct {
+			Id int64 `json:".id"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(p.Document), &attrs))
+		assert.Equal(t, int64(0), attrs.Id%4, p.Document)
+	}
+
+	// Band, filled by the first search, isn't searched again
+	state = &topKState{}
+	details.Filter = &resolver{EntityFilterEx: entityFilterMock{}}
+	details.Band.Capacity = 5
+	ps, err = engine.Search(withTopKState(ctx, state), "аскорбинка", "name", details)
+	require.NoError(t, err)
+	assert.Len(t, ps, 5)
+	assert.False(t, state.full)
+
+	// Band, emptied by threshold instead of filter, isn't searched again
+	state = &topKState{}
+	details.Filter = &resolver{EntityFilterEx: sparseFilterMock{}}
+	details.Band.Capacity = 10
+	details.Band.Threshold = 2
+	ps, err = engine.Search(withTopKState(ctx, state), "аскорбинка", "name", details)
+	require.NoError(t, err)
+	assert.Empty(t, ps)
+	assert.True(t, state.truncated)
+	assert.False(t, state.full)
+}
+
+func TestStrategyMixedTopK(t *testing.T) {
+	spec, err := ParseStrategySpec([]byte(`{
+		"root": "root",
+		"nodes": {
+			"root": {"kind": "multi", "mixer": "max", "entries": [
+				{"node": "alone"},
+				{"node": "shared"},
+				{"node": "both"}
+			]},
+			"both": {"kind": "multi", "mixer": "intersect", "entries": [
+				{"node": "shared"},
+				{"node": "mixed"}
+			]},
+			"alone": {"kind": "ngram", "length": 3, "top-k": true},
+			"shared": {"kind": "ngram", "length": 2, "top-k": true},
+			"mixed": {"kind": "ngram", "parser": "secondary", "length": 3, "top-k": true}
+		}
+	}`))
+	require.NoError(t, err)
+
+	// Rules below intersection lose top-k search, even if they are shared with max mixer
+	st, err := NewStrategyFromSpec(nil, nil, spec, nil)
+	require.NoError(t, err)
+	root := st.(*strategy).Rule.(*MultiRule)
+	topK := func(e *Entry) bool {
+		return e.Rule.(*ngramRule).Index.(*ngramIndex).Position.TopK
+	}
+	assert.True(t, topK(root.Entries["alone"]))
+	assert.False(t, topK(root.Entries["shared"]))
+	for name, e := range root.Entries["both"].Rule.(*MultiRule).Entries {
+		assert.False(t, topK(e), name)
+	}
+}
+
+func benchmarkNgramIndexSearch(b *testing.B, topK bool) {
+	ctx := context.Background()
+	names := newSyntheticNames(100000)
+	rule := newTopTestRule(b, NgramIndexPositions{TopK: topK, Weight: 0.3, Inflation: 0.3}, names)
+	ngrams, err := rule.Parser.Parse(ctx, []rune("аспирин таблетки"), false)
+	require.NoError(b, err)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_ = rule.Index.SearchTop(ngrams, 1, 100)
+	}
+}
+
+func BenchmarkNgramIndexSearchFull(b *testing.B) {
+	benchmarkNgramIndexSearch(b, false)
+}
+
+func BenchmarkNgramIndexSearchTop(b *testing.B) {
+	benchmarkNgramIndexSearch(b, true)
+}
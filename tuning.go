@@ -0,0 +1,357 @@
+package parcels
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+// Methods of tuning
+const (
+	TuneMethodRandom     = "random"     // Random search
+	TuneMethodCoordinate = "coordinate" // Coordinate descent
+)
+
+// Metrics of tuning
+const (
+	TuneMetricMRR    = "mrr"
+	TuneMetricNDCG   = "ndcg"
+	TuneMetricRecall = "recall"
+)
+
+// TuneSettings is settings of search in space of strategy options.
+type TuneSettings struct {
+	Method     string   `json:"method"`     // Method of search: random or coordinate. Default coordinate
+	Metric     string   `json:"metric"`     // Metric to maximize: mrr, ndcg or recall. Default ndcg
+	K          int      `json:"k"`          // Cutoff of metrics. Default 10
+	Iterations int      `json:"iterations"` // Maximal count of evaluations. Default 100
+	Seed       int64    `json:"seed"`       // Seed of random search
+	Params     []string `json:"params"`     // Names of tuned parameters. All parameters, if empty
+}
+
+// TuneResult is the best options, found by tuning.
+type TuneResult struct {
+	Options     *StrategyOptions `json:"options"`
+	Score       float64          `json:"score"`       // Metric of the best options
+	BaseScore   float64          `json:"baseScore"`   // Metric of the initial options
+	Evaluations int              `json:"evaluations"` // Count of evaluated options
+	Report      *EvalReport      `json:"report"`      // Report of the best options
+}
+
+// TuneParam is tunable parameter of strategy options.
+// Range of parameter follows the range, documented in options.
+type TuneParam struct {
+	Name    string
+	Min     float64
+	Max     float64
+	Step    float64 // Step of coordinate descent
+	Integer bool
+	get     func(options *StrategyOptions) float64
+	set     func(options *StrategyOptions, value float64)
+}
+
+// Get values of coordinate descent
+func (param *TuneParam) grid() []float64 {
+	var res []float64
+	for i := 0; ; i++ {
+		// Rounding keeps values readable in JSON
+		v := math.Round((param.Min+float64(i)*param.Step)*1e6) / 1e6
+		if v > param.Max {
+			return res
+		}
+		res = append(res, v)
+	}
+}
+
+// Get random value in range
+func (param *TuneParam) random(rnd *rand.Rand) float64 {
+	v := param.Min + rnd.Float64()*(param.Max-param.Min)
+	if param.Integer {
+		v = math.Round(v)
+	}
+	return v
+}
+
+func newTuneFloat(
+	name string,
+	min, max, step float64,
+	field func(options *StrategyOptions) *float64,
+) *TuneParam {
+	return &TuneParam{
+		Name: name,
+		Min:  min,
+		Max:  max,
+		Step: step,
+		get: func(options *StrategyOptions) float64 {
+			return *field(options)
+		},
+		set: func(options *StrategyOptions, value float64) {
+			*field(options) = value
+		},
+	}
+}
+
+func newTuneInt(
+	name string,
+	min, max int,
+	field func(options *StrategyOptions) *int,
+) *TuneParam {
+	return &TuneParam{
+		Name:    name,
+		Min:     float64(min),
+		Max:     float64(max),
+		Step:    1,
+		Integer: true,
+		get: func(options *StrategyOptions) float64 {
+			return float64(*field(options))
+		},
+		set: func(options *StrategyOptions, value float64) {
+			*field(options) = int(math.Round(value))
+		},
+	}
+}
+
+// Bounds of tuned options, which are unbounded in StrategyOptions.
+// Tuner walks grid of each parameter, so ranges are chosen to keep grids small:
+// each next ngram length weighs Grow times more, so with Grow 4 the longest ngram
+// already dominates the branch, and metaphone branches are weighted relative
+// to each other, so ten steps of 1 cover useful ratios.
+const (
+	tuneMaxGrow            = 4
+	tuneMaxMetaphoneWeight = 10
+)
+
+func newTuneBranch(
+	name string,
+	branch func(options *StrategyOptions) *NgramBranchOptions,
+) []*TuneParam {
+	return []*TuneParam{
+		newTuneInt(name+".min", 2, 10, func(o *StrategyOptions) *int { return &branch(o).Min }),
+		newTuneInt(name+".max", 2, 10, func(o *StrategyOptions) *int { return &branch(o).Max }),
+		newTuneFloat(name+".grow", 1, tuneMaxGrow, 0.5, func(o *StrategyOptions) *float64 { return &branch(o).Grow }),
+		newTuneFloat(name+".weight", 0, 1, 0.1, func(o *StrategyOptions) *float64 { return &branch(o).Weight }),
+		newTuneFloat(name+".position.weight", 0, 1, 0.1, func(o *StrategyOptions) *float64 { return &branch(o).Position.Weight }),
+		newTuneFloat(name+".position.query", 0, 1, 0.1, func(o *StrategyOptions) *float64 { return &branch(o).Position.Query }),
+		newTuneFloat(name+".position.absolute", 0, 1, 0.1, func(o *StrategyOptions) *float64 { return &branch(o).Position.Absolute }),
+		newTuneFloat(name+".position.relative", 0, 1, 0.1, func(o *StrategyOptions) *float64 { return &branch(o).Position.Relative }),
+		newTuneFloat(name+".position.inflation", 0, 1, 0.1, func(o *StrategyOptions) *float64 { return &branch(o).Position.Inflation }),
+	}
+}
+
+// TuneParams returns all tunable parameters of strategy options.
+// Names of parameters are paths of JSON fields.
+func TuneParams() []*TuneParam {
+	var params []*TuneParam
+	params = append(params, newTuneBranch("ngrams.primary", func(o *StrategyOptions) *NgramBranchOptions { return &o.Ngrams.Primary })...)
+	params = append(params, newTuneBranch("ngrams.secondary", func(o *StrategyOptions) *NgramBranchOptions { return &o.Ngrams.Secondary })...)
+	params = append(
+		params,
+		newTuneFloat("metaphone.original", 0, tuneMaxMetaphoneWeight, 1, func(o *StrategyOptions) *float64 { return &o.Metaphone.Original }),
+		newTuneFloat("metaphone.russian", 0, tuneMaxMetaphoneWeight, 1, func(o *StrategyOptions) *float64 { return &o.Metaphone.Russian }),
+		newTuneFloat("metaphone.ukrainian", 0, tuneMaxMetaphoneWeight, 1, func(o *StrategyOptions) *float64 { return &o.Metaphone.Ukrainian }),
+		newTuneFloat("translators.weight", 0, 1, 0.1, func(o *StrategyOptions) *float64 { return &o.Translators.Weight }),
+		newTuneFloat("band.threshold", 0, 1, 0.05, func(o *StrategyOptions) *float64 { return &o.Band.Threshold }),
+		newTuneFloat("band.diff.rel", 0, 1, 0.1, func(o *StrategyOptions) *float64 { return &o.Band.Diff.Rel }),
+		newTuneFloat("band.diff.abs", 0, 1, 0.1, func(o *StrategyOptions) *float64 { return &o.Band.Diff.Abs }),
+		newTuneFloat("band.knee", 0, 1, 0.1, func(o *StrategyOptions) *float64 { return &o.Band.Knee }),
+	)
+	return params
+}
+
+// Select parameters by names
+func selectTuneParams(names []string) ([]*TuneParam, error) {
+	params := TuneParams()
+	if len(names) == 0 {
+		return params, nil
+	}
+
+	index := make(map[string]*TuneParam, len(params))
+	for _, param := range params {
+		index[param.Name] = param
+	}
+	res := make([]*TuneParam, 0, len(names))
+	for _, name := range names {
+		param, ok := index[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown parameter %q", name)
+		}
+		res = append(res, param)
+	}
+	return res, nil
+}
+
+// Check, that options can build strategy
+func validTuneOptions(options *StrategyOptions) bool {
+	ngrams := &options.Ngrams
+	if ngrams.Primary.Min > ngrams.Primary.Max || ngrams.Secondary.Min > ngrams.Secondary.Max {
+		return false
+	}
+	if ngrams.Primary.Weight+ngrams.Secondary.Weight <= 0 {
+		return false
+	}
+	metaphone := &options.Metaphone
+	return metaphone.Original+metaphone.Russian+metaphone.Ukrainian > 0
+}
+
+// tuning is state of search in space of strategy options.
+type tuning struct {
+	catalog     EvalCatalog
+	queries     []*EvalQuery
+	settings    TuneSettings
+	params      []*TuneParam
+	best        *TuneResult
+	evaluations int
+}
+
+// Evaluate options and remember the best of them
+func (t *tuning) evaluate(ctx context.Context, options *StrategyOptions) (float64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	t.evaluations++
+	report, err := Evaluate(ctx, t.catalog, t.queries, options, t.settings.K)
+	if err != nil {
+		return 0, fmt.Errorf("Evaluate: %w", err)
+	}
+
+	var score float64
+	switch t.settings.Metric {
+	case TuneMetricMRR:
+		score = report.MRR
+	case TuneMetricRecall:
+		score = report.Recall
+	default:
+		score = report.NDCG
+	}
+
+	if t.best == nil || score > t.best.Score {
+		clone := *options
+		t.best = &TuneResult{
+			Options: &clone,
+			Score:   score,
+			Report:  report,
+		}
+	}
+	return score, nil
+}
+
+func (t *tuning) exhausted() bool {
+	return t.evaluations >= t.settings.Iterations
+}
+
+// Sample random options in ranges of parameters
+func (t *tuning) random(ctx context.Context, initial *StrategyOptions) error {
+	rnd := rand.New(rand.NewSource(t.settings.Seed))
+	for !t.exhausted() {
+		options := *initial
+		for _, param := range t.params {
+			param.set(&options, param.random(rnd))
+		}
+		if !validTuneOptions(&options) {
+			// Invalid options are counted, so search always stops
+			t.evaluations++
+			continue
+		}
+		_, err := t.evaluate(ctx, &options)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Improve the best options by one parameter at time, while metric grows
+func (t *tuning) coordinate(ctx context.Context) error {
+	for improved := true; improved && !t.exhausted(); {
+		improved = false
+		for _, param := range t.params {
+			current := param.get(t.best.Options)
+			for _, value := range param.grid() {
+				if t.exhausted() {
+					return nil
+				}
+				if value == current {
+					continue
+				}
+				options := *t.best.Options
+				param.set(&options, value)
+				if !validTuneOptions(&options) {
+					continue
+				}
+				score := t.best.Score
+				s, err := t.evaluate(ctx, &options)
+				if err != nil {
+					return err
+				}
+				if s > score {
+					improved = true
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// TuneStrategyOptions searches options of default strategy, which maximize metric of judged queries.
+// Search starts from initial options (default options, if nil) and never returns worse options.
+func TuneStrategyOptions(
+	ctx context.Context,
+	catalog EvalCatalog,
+	queries []*EvalQuery,
+	initial *StrategyOptions,
+	settings TuneSettings,
+) (*TuneResult, error) {
+	if initial == nil {
+		initial = DefaultStrategyOptions()
+	}
+	if settings.K <= 0 {
+		settings.K = 10
+	}
+	if settings.Iterations <= 0 {
+		settings.Iterations = 100
+	}
+	settings.Metric = strings.ToLower(settings.Metric)
+	switch settings.Metric {
+	case "", TuneMetricMRR, TuneMetricNDCG, TuneMetricRecall:
+	default:
+		return nil, fmt.Errorf("unknown metric %q", settings.Metric)
+	}
+
+	settings.Method = strings.ToLower(settings.Method)
+	switch settings.Method {
+	case "", TuneMethodCoordinate, TuneMethodRandom:
+	default:
+		return nil, fmt.Errorf("unknown method %q", settings.Method)
+	}
+
+	params, err := selectTuneParams(settings.Params)
+	if err != nil {
+		return nil, fmt.Errorf("selectTuneParams: %w", err)
+	}
+
+	t := &tuning{
+		catalog:  catalog,
+		queries:  queries,
+		settings: settings,
+		params:   params,
+	}
+	base, err := t.evaluate(ctx, initial)
+	if err != nil {
+		return nil, err
+	}
+
+	if settings.Method == TuneMethodRandom {
+		err = t.random(ctx, initial)
+	} else {
+		err = t.coordinate(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t.best.BaseScore = base
+	t.best.Evaluations = t.evaluations
+	return t.best, nil
+}
@@ -0,0 +1,69 @@
+package parcels
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTuneParams(t *testing.T) {
+	params, err := selectTuneParams([]string{"ngrams.primary.min", "band.diff.rel"})
+	require.NoError(t, err)
+	require.Len(t, params, 2)
+	assert.Equal(t, []float64{2, 3, 4, 5, 6, 7, 8, 9, 10}, params[0].grid())
+	assert.Equal(t, []float64{0, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1}, params[1].grid())
+
+	options := DefaultStrategyOptions()
+	params[0].set(options, 4)
+	assert.Equal(t, 4, options.Ngrams.Primary.Min)
+	assert.True(t, validTuneOptions(options))
+	params[0].set(options, 8)
+	assert.False(t, validTuneOptions(options))
+
+	_, err = selectTuneParams([]string{"ngrams.unknown"})
+	assert.Error(t, err)
+}
+
+func TestTuneStrategyOptions(t *testing.T) {
+	catalog, err := ParseEvalCatalog([]byte("аспирин\nаспаркам\nанальгин\nпарацетамол\n"))
+	require.NoError(t, err)
+	queries, err := ParseEvalQueries([]byte(`[
+		{"query": "аспирин", "relevant": ["аспирин"]},
+		{"query": "парацетомол", "relevant": ["парацетамол"]}
+	]`))
+	require.NoError(t, err)
+
+	// Threshold drops every document
+	initial := DefaultStrategyOptions()
+	initial.Band.Threshold = 1
+
+	ctx := context.Background()
+	for _, method := range []string{TuneMethodCoordinate, TuneMethodRandom} {
+		res, err := TuneStrategyOptions(
+			ctx,
+			catalog,
+			queries,
+			initial,
+			TuneSettings{
+				Method:     method,
+				Metric:     TuneMetricMRR,
+				Iterations: 8,
+				Seed:       1,
+				Params:     []string{"band.threshold"},
+			},
+		)
+		require.NoError(t, err)
+		assert.Equal(t, 0.0, res.BaseScore, method)
+		assert.Greater(t, res.Score, res.BaseScore, method)
+		assert.Less(t, res.Options.Band.Threshold, 1.0, method)
+		assert.LessOrEqual(t, res.Evaluations, 8, method)
+		assert.Equal(t, res.Score, res.Report.MRR, method)
+		// Initial options are not modified
+		assert.Equal(t, 1.0, initial.Band.Threshold, method)
+	}
+
+	_, err = TuneStrategyOptions(ctx, catalog, queries, nil, TuneSettings{Method: "genetic"})
+	assert.Error(t, err)
+}